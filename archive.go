@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Settings of the import of archives of comments.
+const (
+	ArchiveImportBatchSize        = 1000
+	ArchiveImportMaxLineSize      = 16 * 1024 * 1024
+	ArchiveImportProgressInterval = 10 * time.Second
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ArchiveImportStats describes the progress of the import of an archive.
+type ArchiveImportStats struct {
+	Lines    uint64 // Number of lines read
+	Invalid  uint64 // Number of lines that couldn't be decoded
+	Matched  uint64 // Number of comments whose author is registered
+	Inserted uint64 // Number of comments that weren't already in the database
+	Read     int64  // Number of bytes read from the file, before decompression
+	Size     int64  // Size of the file
+}
+
+// String implements Stringer.
+func (s ArchiveImportStats) String() string {
+	var percentage float64
+	if s.Size > 0 {
+		percentage = 100 * float64(s.Read) / float64(s.Size)
+	}
+	return fmt.Sprintf("%.1f%% of the file read, %d lines, %d invalid, %d comments from registered users, %d new",
+		percentage, s.Lines, s.Invalid, s.Matched, s.Inserted)
+}
+
+// archivedComment is a comment such as it is found in the dumps of Pushshift.
+type archivedComment struct {
+	ID         string
+	Author     string
+	Score      int64
+	Permalink  string
+	Subreddit  string
+	LinkID     string           `json:"link_id"`
+	CreatedUTC archiveTimestamp `json:"created_utc"`
+	Body       string
+}
+
+// ToComment converts the archived comment, and builds its permalink if the dump doesn't have it.
+func (ac archivedComment) ToComment(author string) Comment {
+	permalink := ac.Permalink
+	if permalink == "" {
+		permalink = fmt.Sprintf("/r/%s/comments/%s/_/%s/", ac.Subreddit, strings.TrimPrefix(ac.LinkID, "t3_"), ac.ID)
+	}
+	return Comment{
+		ID:        ac.ID,
+		Author:    author,
+		Score:     ac.Score,
+		Permalink: permalink,
+		Sub:       ac.Subreddit,
+		Created:   time.Unix(int64(ac.CreatedUTC), 0),
+		Body:      ac.Body,
+	}
+}
+
+// archiveTimestamp is a UNIX timestamp that some dumps encode as a string.
+type archiveTimestamp int64
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (at *archiveTimestamp) UnmarshalJSON(data []byte) error {
+	raw := strings.Trim(string(data), `"`)
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %s: %v", data, err)
+	}
+	*at = archiveTimestamp(value)
+	return nil
+}
+
+// ImportArchive reads a file of newline-delimited JSON comments, like the dumps of Pushshift,
+// and saves the comments whose authors are registered, even if they are deleted.
+// The file can be compressed with gzip or zstd; the latter requires the command zstd.
+// Comments that are already saved are left untouched, so an import can safely be run again.
+func ImportArchive(ctx context.Context, logger LevelLogger, conn StorageConn, path string) (ArchiveImportStats, error) {
+	var stats ArchiveImportStats
+
+	users, err := conn.ListAllUsers()
+	if err != nil {
+		return stats, err
+	}
+	if len(users) == 0 {
+		return stats, errors.New("no registered user whose comments could be imported")
+	}
+	names := make(map[string]string, len(users))
+	for _, user := range users {
+		names[strings.ToLower(user.Name)] = user.Name
+	}
+
+	// Archives compressed with zstd are also detected from their content, but most can be checked before starting.
+	if strings.HasSuffix(path, ZstdExtension) {
+		if err := CheckZstd("decompressing the archive"); err != nil {
+			return stats, err
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return stats, err
+	}
+	defer file.Close()
+
+	if info, err := file.Stat(); err == nil {
+		stats.Size = info.Size()
+	}
+
+	counter := &countingReader{actual: file}
+	input, err := decompressArchive(ctx, counter)
+	if err != nil {
+		return stats, err
+	}
+	defer input.Close()
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 64*1024), ArchiveImportMaxLineSize)
+
+	batch := make([]Comment, 0, ArchiveImportBatchSize)
+	save := func() error {
+		inserted, err := conn.SaveArchivedComments(batch)
+		stats.Inserted += uint64(inserted)
+		batch = batch[:0]
+		return err
+	}
+
+	lastProgress := time.Now()
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+
+		stats.Lines++
+		stats.Read = counter.Count()
+
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var archived archivedComment
+		if err := json.Unmarshal(line, &archived); err != nil || archived.ID == "" {
+			stats.Invalid++
+			logger.Debugf("invalid comment on line %d: %v", stats.Lines, err)
+			continue
+		}
+
+		author, ok := names[strings.ToLower(archived.Author)]
+		if !ok {
+			continue
+		}
+		stats.Matched++
+		batch = append(batch, archived.ToComment(author))
+
+		if len(batch) == ArchiveImportBatchSize {
+			if err := save(); err != nil {
+				return stats, err
+			}
+		}
+
+		if time.Now().Sub(lastProgress) > ArchiveImportProgressInterval {
+			logger.Infof("importing %q: %s", path, stats)
+			lastProgress = time.Now()
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, err
+	}
+
+	if err := save(); err != nil {
+		return stats, err
+	}
+	stats.Read = counter.Count()
+
+	// Errors of the decompression command are only known once it has exited.
+	return stats, input.Close()
+}
+
+// decompressArchive detects the compression of an archive from its first bytes.
+func decompressArchive(ctx context.Context, reader io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+	magic, err := buffered.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(magic, gzipMagic) {
+		return gzip.NewReader(buffered)
+	} else if bytes.HasPrefix(magic, zstdMagic) {
+		return newZstdReader(ctx, buffered)
+	}
+	return io.NopCloser(buffered), nil
+}
+
+// countingReader counts the bytes that are read, possibly from another goroutine.
+type countingReader struct {
+	actual io.Reader
+	count  int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.actual.Read(p)
+	atomic.AddInt64(&cr.count, int64(n))
+	return n, err
+}
+
+func (cr *countingReader) Count() int64 {
+	return atomic.LoadInt64(&cr.count)
+}
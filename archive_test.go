@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testArchive = `{"id": "c1", "author": "user1", "score": -10, "subreddit": "sub", "link_id": "t3_abc", "created_utc": 1500000000, "body": "first"}
+{"id": "c2", "author": "User2", "score": 5, "permalink": "/r/sub/comments/abc/_/c2/", "subreddit": "sub", "created_utc": "1500000100", "body": "second"}
+{"id": "c3", "author": "stranger", "score": 1, "subreddit": "sub", "link_id": "t3_abc", "created_utc": 1500000200, "body": "third"}
+not json
+
+{"id": "c4", "author": "User1", "score": 3, "subreddit": "other", "link_id": "t3_def", "created_utc": 1500000300, "body": "fourth"}
+`
+
+func TestImportArchive(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	logger := NewTestLevelLogger(t)
+	dir := t.TempDir()
+
+	_, conn, err := NewStorage(ctx, logger, StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, name := range []string{"User1", "User2"} {
+		if err := conn.AddUser(name, false, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	existing := Comment{ID: "c4", Author: "User1", Score: 42, Permalink: "/r/other/comments/def/_/c4/", Sub: "other", Created: time.Unix(1500000300, 0), Body: "fourth, already saved"}
+	if _, err := conn.SaveArchivedComments([]Comment{existing}); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := filepath.Join(dir, "comments.ndjson")
+	if err := ioutil.WriteFile(plain, []byte(testArchive), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("plain", func(t *testing.T) {
+		stats, err := ImportArchive(ctx, logger, conn, plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Lines != 6 || stats.Invalid != 1 || stats.Matched != 3 || stats.Inserted != 2 {
+			t.Errorf("unexpected statistics: %+v", stats)
+		}
+		if stats.Read != stats.Size {
+			t.Errorf("%d bytes should have been read instead of %d", stats.Size, stats.Read)
+		}
+
+		comment, exists, err := conn.GetComment("c1")
+		if err != nil {
+			t.Fatal(err)
+		} else if !exists {
+			t.Fatal("comment c1 should have been imported")
+		}
+		if comment.Author != "User1" {
+			t.Errorf("author of c1 should be the registered name User1, not %q", comment.Author)
+		}
+		if comment.Permalink != "/r/sub/comments/abc/_/c1/" {
+			t.Errorf("unexpected permalink of c1 %q", comment.Permalink)
+		}
+		if comment.Score != -10 || comment.Body != "first" || comment.Sub != "sub" {
+			t.Errorf("unexpected content of c1: %+v", comment)
+		}
+
+		comment, exists, err = conn.GetComment("c2")
+		if err != nil {
+			t.Fatal(err)
+		} else if !exists {
+			t.Fatal("comment c2 should have been imported")
+		}
+		if !comment.Created.Equal(time.Unix(1500000100, 0)) {
+			t.Errorf("c2 should have been created at a date given as a string, not at %v", comment.Created)
+		}
+
+		if _, exists, err := conn.GetComment("c3"); err != nil {
+			t.Fatal(err)
+		} else if exists {
+			t.Error("comment c3 from an unregistered user shouldn't have been imported")
+		}
+
+		comment, _, err = conn.GetComment("c4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if comment.Score != existing.Score || comment.Body != existing.Body {
+			t.Errorf("already saved comment c4 shouldn't have been changed: %+v", comment)
+		}
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write([]byte(testArchive)); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "comments.ndjson.gz")
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := ImportArchive(ctx, logger, conn, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Lines != 6 || stats.Matched != 3 || stats.Inserted != 0 {
+			t.Errorf("unexpected statistics: %+v", stats)
+		}
+	})
+
+	t.Run("zstd", func(t *testing.T) {
+		if err := CheckZstd("testing the import"); err != nil {
+			t.Skip(err)
+		}
+		path := filepath.Join(dir, "comments.ndjson"+ZstdExtension)
+		if err := exec.Command(ZstdCommand, "--quiet", "-o", path, plain).Run(); err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := ImportArchive(ctx, logger, conn, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Lines != 6 || stats.Matched != 3 || stats.Inserted != 0 {
+			t.Errorf("unexpected statistics: %+v", stats)
+		}
+	})
+}
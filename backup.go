@@ -0,0 +1,298 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Compressions of the backups of the database.
+const (
+	BackupCompressionNone BackupCompression = "none"
+	BackupCompressionGzip BackupCompression = "gzip"
+	BackupCompressionZstd BackupCompression = "zstd"
+)
+
+// Names of the files of the backups.
+const (
+	BackupChecksumExtension  = ".sha256"
+	BackupNamePrefix         = "dab-"
+	BackupNameTimeFormat     = "20060102T150405Z"
+	BackupNameExtension      = ".sqlite3"
+	BackupTemporaryExtension = ".tmp"
+)
+
+// ErrNoBackup is returned when the requested backup doesn't exist.
+var ErrNoBackup = errors.New("no such backup")
+
+// BackupCompression is the compression applied to the backups of the database.
+type BackupCompression string
+
+// Valid tells if the compression is known.
+func (bc BackupCompression) Valid() bool {
+	switch bc {
+	case BackupCompressionNone, BackupCompressionGzip, BackupCompressionZstd:
+		return true
+	}
+	return false
+}
+
+// Compressed tells if the backups are compressed.
+func (bc BackupCompression) Compressed() bool {
+	return bc == BackupCompressionGzip || bc == BackupCompressionZstd
+}
+
+// Extension returns the extension of the files compressed this way.
+func (bc BackupCompression) Extension() string {
+	switch bc {
+	case BackupCompressionGzip:
+		return ".gz"
+	case BackupCompressionZstd:
+		return ZstdExtension
+	}
+	return ""
+}
+
+// Encoding returns the HTTP content coding of the files compressed this way, or an empty string if they aren't.
+func (bc BackupCompression) Encoding() string {
+	if bc.Compressed() {
+		return string(bc)
+	}
+	return ""
+}
+
+// MIMEType returns the type of the files compressed this way, when served without content coding.
+func (bc BackupCompression) MIMEType() string {
+	switch bc {
+	case BackupCompressionGzip:
+		return "application/gzip"
+	case BackupCompressionZstd:
+		return "application/zstd"
+	}
+	return "application/x-sqlite3"
+}
+
+// Compress writes to dest a compressed copy of the file at src.
+// Compression with zstd requires the command zstd.
+func (bc BackupCompression) Compress(ctx context.Context, src, dest string) error {
+	switch bc {
+	case BackupCompressionGzip:
+		return gzipFile(src, dest)
+	case BackupCompressionZstd:
+		return zstdCompressFile(ctx, src, dest)
+	}
+	return fmt.Errorf("invalid compression %q", bc)
+}
+
+func gzipFile(src, dest string) error {
+	input, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	output, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	writer := gzip.NewWriter(output)
+	if _, err := io.Copy(writer, input); err != nil {
+		return err
+	} else if err := writer.Close(); err != nil {
+		return err
+	}
+	return output.Close()
+}
+
+// BackupFile describes a published backup of the database.
+type BackupFile struct {
+	Checksum    string            // Hexadecimal SHA-256 checksum of the file
+	Compression BackupCompression // Compression of the file
+	Created     time.Time         // When the backup was made
+	Name        string            // Name of the file
+	Path        string            // Path to the file
+	Size        int64             // Size of the file in bytes
+}
+
+// HumanSize returns the size of the file in a human-readable format.
+func (bf BackupFile) HumanSize() string {
+	size := float64(bf.Size)
+	for _, unit := range []string{"B", "KiB", "MiB", "GiB"} {
+		if size < 1024 {
+			return fmt.Sprintf("%.1f %s", size, unit)
+		}
+		size /= 1024
+	}
+	return fmt.Sprintf("%.1f TiB", size)
+}
+
+// BackupFileName returns the name of the file of a retained backup.
+func BackupFileName(created time.Time, compression BackupCompression) string {
+	return BackupNamePrefix + created.UTC().Format(BackupNameTimeFormat) + BackupNameExtension + compression.Extension()
+}
+
+func parseBackupFileName(name string) (time.Time, BackupCompression, bool) {
+	compression := BackupCompressionNone
+	for _, candidate := range []BackupCompression{BackupCompressionGzip, BackupCompressionZstd} {
+		if strings.HasSuffix(name, candidate.Extension()) {
+			compression = candidate
+			name = strings.TrimSuffix(name, candidate.Extension())
+			break
+		}
+	}
+	if !strings.HasPrefix(name, BackupNamePrefix) || !strings.HasSuffix(name, BackupNameExtension) {
+		return time.Time{}, compression, false
+	}
+	raw := strings.TrimSuffix(strings.TrimPrefix(name, BackupNamePrefix), BackupNameExtension)
+	created, err := time.Parse(BackupNameTimeFormat, raw)
+	return created, compression, err == nil
+}
+
+// ListBackups returns the retained backups in a directory, from the newest to the oldest.
+// Files whose checksum hasn't been written yet are ignored, as they aren't completely published.
+func ListBackups(dir string) ([]BackupFile, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var backups []BackupFile
+	for _, info := range infos {
+		created, compression, ok := parseBackupFileName(info.Name())
+		if !ok || !info.Mode().IsRegular() {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		checksum, err := readChecksumFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		backups = append(backups, BackupFile{
+			Checksum:    checksum,
+			Compression: compression,
+			Created:     created,
+			Name:        info.Name(),
+			Path:        path,
+			Size:        info.Size(),
+		})
+	}
+
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Created.After(backups[j].Created) })
+	return backups, nil
+}
+
+// Expired returns the backups that aren't retained, from a list sorted from the newest to the oldest.
+// For each period (hour, day, and ISO week), the most recent backup of each of the last periods that have one is retained.
+func (conf BackupRetentionConf) Expired(backups []BackupFile) []BackupFile {
+	rules := []struct {
+		nb     uint
+		period func(time.Time) string
+	}{
+		{conf.Hourly, func(t time.Time) string { return t.Format("2006-01-02T15") }},
+		{conf.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{conf.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", year, week)
+		}},
+	}
+
+	retained := make(map[string]bool)
+	for _, rule := range rules {
+		periods := make(map[string]bool)
+		for _, backup := range backups {
+			if uint(len(periods)) >= rule.nb {
+				break
+			}
+			if period := rule.period(backup.Created.UTC()); !periods[period] {
+				periods[period] = true
+				retained[backup.Name] = true
+			}
+		}
+	}
+
+	var expired []BackupFile
+	for _, backup := range backups {
+		if !retained[backup.Name] {
+			expired = append(expired, backup)
+		}
+	}
+	return expired
+}
+
+// BackupsIndex describes the page of the retained backups.
+type BackupsIndex struct {
+	Backups []BackupFile // Retained backups, from the newest
+	Version SemVer       // Version of the application
+}
+
+func checksumFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeChecksumFile writes a checksum next to the file it describes, in the format of the command sha256sum.
+func writeChecksumFile(path, checksum string) error {
+	content := fmt.Sprintf("%s  %s\n", checksum, filepath.Base(path))
+	return ioutil.WriteFile(path+BackupChecksumExtension, []byte(content), 0644)
+}
+
+func readChecksumFile(path string) (string, error) {
+	content, err := ioutil.ReadFile(path + BackupChecksumExtension)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum file for %q", path)
+	}
+	return fields[0], nil
+}
+
+// linkOrCopyFile creates a hard link to a file, or copies it if that isn't possible, like on another file system.
+func linkOrCopyFile(src, dest string) error {
+	if err := os.Link(src, dest); err == nil {
+		return nil
+	}
+
+	input, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer input.Close()
+
+	output, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	if _, err := io.Copy(output, input); err != nil {
+		return err
+	}
+	return output.Close()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackups(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{
+		BackupCompression: BackupCompressionGzip,
+		BackupPath:        filepath.Join(dir, "dab.db.backup"),
+		BackupRetention:   BackupRetentionConf{Dir: filepath.Join(dir, "backups"), Daily: 1},
+		Path:              filepath.Join(dir, "dab.db"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.AddUser("username", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.Backup(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("published", func(t *testing.T) {
+		backup, file, err := storage.LatestBackup(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		if checksum, err := checksumFile(backup.Path); err != nil {
+			t.Fatal(err)
+		} else if checksum != backup.Checksum {
+			t.Errorf("the published checksum %s doesn't match the one of the backup, %s", backup.Checksum, checksum)
+		}
+
+		// Opening a database writes to it, so use a copy.
+		content, err := ioutil.ReadFile(backup.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		restored := filepath.Join(dir, "restored.db")
+		if err := ioutil.WriteFile(restored, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, backupConn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: restored})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer backupConn.Close()
+		if users, err := backupConn.ListUsers(); err != nil {
+			t.Fatal(err)
+		} else if len(users) != 1 {
+			t.Errorf("the backup should contain the user that was added, got %v", users)
+		}
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		backup, file, err := storage.LatestBackup(true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if backup.Compression != BackupCompressionGzip {
+			t.Errorf("the compressed backup should be served, got %q", backup.Compression)
+		}
+
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decompressed, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		original, err := ioutil.ReadFile(storage.BackupPath())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decompressed, original) {
+			t.Error("the compressed backup should contain the same data as the uncompressed one")
+		}
+	})
+
+	t.Run("retained", func(t *testing.T) {
+		backups, err := storage.RetainedBackups()
+		if err != nil {
+			t.Fatal(err)
+		} else if len(backups) != 1 {
+			t.Fatalf("one backup should be retained, got %v", backups)
+		}
+
+		backup, file, err := storage.RetainedBackup(backups[0].Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		if backup.Compression != BackupCompressionGzip || backup.Checksum != backups[0].Checksum {
+			t.Errorf("unexpected retained backup %+v", backup)
+		}
+
+		if _, _, err := storage.RetainedBackup("../dab.db"); err != ErrNoBackup {
+			t.Errorf("only retained backups should be served, got error %v", err)
+		}
+	})
+}
+
+func TestBackupRetention(t *testing.T) {
+	t.Parallel()
+
+	// Backups every 12 hours, and a few less than an hour apart, during 4 weeks.
+	start := time.Date(2021, time.March, 1, 0, 30, 0, 0, time.UTC) // A Monday
+	var backups []BackupFile
+	for date := start; date.Before(start.AddDate(0, 0, 28)); date = date.Add(12 * time.Hour) {
+		backups = append([]BackupFile{{Name: BackupFileName(date, BackupCompressionNone), Created: date}}, backups...)
+	}
+	last := backups[0].Created
+	for i := 1; i <= 2; i++ {
+		created := last.Add(time.Duration(i) * 20 * time.Minute)
+		backups = append([]BackupFile{{Name: BackupFileName(created, BackupCompressionNone), Created: created}}, backups...)
+	}
+
+	retention := BackupRetentionConf{Hourly: 2, Daily: 3, Weekly: 3}
+	retained := make(map[time.Time]bool)
+	for _, backup := range backups {
+		retained[backup.Created] = true
+	}
+	for _, backup := range retention.Expired(backups) {
+		delete(retained, backup.Created)
+	}
+
+	expected := []time.Time{
+		last.Add(40 * time.Minute), // Last hour, day, and week
+		last.Add(20 * time.Minute), // Previous hour with a backup
+		last.AddDate(0, 0, -1),     // Previous days
+		last.AddDate(0, 0, -2),
+		last.AddDate(0, 0, -7), // Previous weeks
+		last.AddDate(0, 0, -14),
+	}
+	if len(retained) != len(expected) {
+		t.Errorf("%d backups should be retained, got %d: %v", len(expected), len(retained), retained)
+	}
+	for _, date := range expected {
+		if !retained[date] {
+			t.Errorf("the backup of %s should be retained", date)
+		}
+	}
+
+	if expired := (BackupRetentionConf{Daily: 1}).Expired(nil); len(expired) != 0 {
+		t.Errorf("nothing should expire without backups, got %v", expired)
+	}
+}
+
+func TestBackupCompressionZstd(t *testing.T) {
+	t.Parallel()
+
+	if err := CheckZstd("testing the compression of backups"); err != nil {
+		t.Skip(err)
+	}
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "backup")
+	content := bytes.Repeat([]byte("compressible content "), 1000)
+	if err := ioutil.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := src + BackupCompressionZstd.Extension()
+	if err := BackupCompressionZstd.Compress(ctx, src, dest); err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := os.Open(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer compressed.Close()
+
+	reader, err := newZstdReader(ctx, compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	} else if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, content) {
+		t.Error("the compressed backup should contain the same data as the original")
+	}
+}
@@ -1,30 +1,61 @@
 package main
 
 import (
+	"fmt"
 	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// Dimensions of the coordinate system of the timelines of scores.
+const (
+	TimelineHeight = 100
+	TimelineWidth  = 600
+)
+
+// TextDiffMaxCells is the maximum size of the table used to compare two texts;
+// beyond that, the parts of the texts that differ are shown as entirely replaced.
+const TextDiffMaxCells = 1 << 20
+
+// Kinds of DiffSegment.
+const (
+	DiffEqual  = "equal"
+	DiffDelete = "delete"
+	DiffInsert = "insert"
+)
+
+// matchDiffTokens splits texts into words and white spaces to compare them.
+var matchDiffTokens = regexp.MustCompile(`\s+|\S+`)
+
 // CompendiumFactory generates data structures for any page of the compendium.
 type CompendiumFactory struct {
-	NbTop    uint           // Number of most downvoted comments
-	Timezone *time.Location // Timezone of the dates
+	NbRedditAccounts uint           // Number of Reddit accounts the scans are split between
+	NbTop            uint           // Number of most downvoted comments
+	ScanSubmissions  bool           // Whether submissions are scanned along comments
+	Timezone         *time.Location // Timezone of the dates
 }
 
 // NewCompendiumFactory returns a new CompendiumFactory.
 func NewCompendiumFactory(conf CompendiumConf) CompendiumFactory {
 	return CompendiumFactory{
-		NbTop:    conf.NbTop,
-		Timezone: conf.Timezone.Value,
+		NbRedditAccounts: conf.NbRedditAccounts,
+		NbTop:            conf.NbTop,
+		ScanSubmissions:  conf.ScanSubmissions,
+		Timezone:         conf.Timezone.Value,
 	}
 }
 
 // Index returns the data structure that describes the compendium's index.
 func (cf CompendiumFactory) Index(conn StorageConn) (Compendium, error) {
 	ci := Compendium{
-		NbTop:    cf.NbTop,
-		Timezone: cf.Timezone,
-		Version:  Version,
+		NbRedditAccounts: cf.NbRedditAccounts,
+		NbTop:            cf.NbTop,
+		ScanSubmissions:  cf.ScanSubmissions,
+		Timezone:         cf.Timezone,
+		Version:          Version,
 	}
 
 	err := conn.WithTx(func() error {
@@ -43,6 +74,16 @@ func (cf CompendiumFactory) Index(conn StorageConn) (Compendium, error) {
 		ci.Negative = negative.OrderBy(func(a, b Stats) bool { return a.Sum < b.Sum }).ToView(ci.Timezone)
 
 		ci.rawComments, err = conn.Comments(Pagination{Limit: ci.NbTop})
+		if err != nil {
+			return err
+		}
+
+		ci.revised, err = conn.RevisedComments(ci.rawComments)
+		if err != nil {
+			return err
+		}
+
+		ci.rawSubmissions, err = conn.Submissions(Pagination{Limit: ci.NbTop})
 		return err
 	})
 	if err != nil {
@@ -65,8 +106,15 @@ func (cf CompendiumFactory) Comments(conn StorageConn, page Pagination) (Compend
 		Timezone: cf.Timezone,
 		Version:  Version,
 	}
-	var err error
-	c.rawComments, err = conn.Comments(page)
+	err := conn.WithTx(func() error {
+		var err error
+		c.rawComments, err = conn.Comments(page)
+		if err != nil {
+			return err
+		}
+		c.revised, err = conn.RevisedComments(c.rawComments)
+		return err
+	})
 	return c, err
 }
 
@@ -101,6 +149,22 @@ func (cf CompendiumFactory) User(conn StorageConn, username string) (CompendiumU
 		cu.SummaryNegative = negative.Stats().ToView(0, cu.Timezone)
 
 		cu.rawComments, err = conn.UserComments(cu.User().Name, Pagination{Limit: cu.NbTop})
+		if err != nil {
+			return err
+		}
+
+		cu.revised, err = conn.RevisedComments(cu.rawComments)
+		if err != nil {
+			return err
+		}
+
+		cu.karmaHistory, err = conn.KarmaHistory(cu.User().Name)
+		if err != nil {
+			return err
+		}
+		cu.Karma = NewKarmaTrend(cu.karmaHistory, KarmaTrendPeriod).InTimezone(cu.Timezone)
+
+		cu.rawSubmissions, err = conn.UserSubmissions(cu.User().Name, Pagination{Limit: cu.NbTop})
 		return err
 	})
 	return cu, err
@@ -128,22 +192,190 @@ func (cf CompendiumFactory) UserComments(conn StorageConn, username string, page
 
 		var err error
 		cu.rawComments, err = conn.UserComments(cu.User().Name, page)
+		if err != nil {
+			return err
+		}
+
+		cu.revised, err = conn.RevisedComments(cu.rawComments)
 		return err
 	})
 	return cu, err
 }
 
+// Comment returns a data structure that describes the compendium page for a single comment and the history of its score.
+func (cf CompendiumFactory) Comment(conn StorageConn, id string) (CompendiumComment, error) {
+	cc := CompendiumComment{
+		Compendium: Compendium{
+			NbTop:    1,
+			Timezone: cf.Timezone,
+			Version:  Version,
+		},
+	}
+
+	err := conn.WithTx(func() error {
+		comment, exists, err := conn.GetComment(id)
+		if err != nil || !exists {
+			return err
+		}
+		cc.rawComments = []Comment{comment}
+
+		cc.revised, err = conn.RevisedComments(cc.rawComments)
+		if err != nil {
+			return err
+		}
+
+		cc.Scores, err = conn.CommentScores(comment.ID)
+		return err
+	})
+	if err != nil {
+		return cc, err
+	}
+
+	for i := range cc.Scores {
+		cc.Scores[i].Observed = cc.Scores[i].Observed.In(cf.Timezone)
+	}
+
+	return cc, nil
+}
+
+// CommentHistory returns a data structure that describes the compendium page for the previous versions of the body of a single comment.
+func (cf CompendiumFactory) CommentHistory(conn StorageConn, id string) (CompendiumCommentHistory, error) {
+	ch := CompendiumCommentHistory{
+		Compendium: Compendium{
+			NbTop:    1,
+			Timezone: cf.Timezone,
+			Version:  Version,
+		},
+	}
+
+	err := conn.WithTx(func() error {
+		comment, exists, err := conn.GetComment(id)
+		if err != nil || !exists {
+			return err
+		}
+		ch.rawComments = []Comment{comment}
+
+		ch.Revisions, err = conn.CommentRevisions(comment.ID)
+		return err
+	})
+	if err != nil || !ch.Exists() {
+		return ch, err
+	}
+
+	ch.revised = map[string]bool{id: len(ch.Revisions) > 0}
+	for i := range ch.Revisions {
+		ch.Revisions[i].Replaced = ch.Revisions[i].Replaced.In(cf.Timezone)
+	}
+
+	return ch, nil
+}
+
+// Removals returns a data structure that describes the removed comments, either in all subreddits along with
+// statistics for each of them, or in a single subreddit if sub isn't empty.
+func (cf CompendiumFactory) Removals(conn StorageConn, sub string, page Pagination) (CompendiumRemovals, error) {
+	cr := CompendiumRemovals{
+		Compendium: Compendium{
+			NbTop:    page.Limit,
+			Offset:   page.Offset,
+			Timezone: cf.Timezone,
+			Version:  Version,
+		},
+		Sub: sub,
+	}
+
+	err := conn.WithTx(func() error {
+		var err error
+		if sub != "" {
+			cr.rawComments, err = conn.SubRemovals(sub, page)
+		} else {
+			cr.Subs, err = conn.RemovalsPerSub()
+			if err != nil {
+				return err
+			}
+			cr.rawComments, err = conn.Removals(page)
+		}
+		if err != nil {
+			return err
+		}
+
+		cr.revised, err = conn.RevisedComments(cr.rawComments)
+		return err
+	})
+	if err != nil {
+		return cr, err
+	}
+
+	for i := range cr.Subs {
+		cr.Subs[i].Latest = cr.Subs[i].Latest.In(cf.Timezone)
+	}
+
+	return cr, nil
+}
+
+// Search returns a data structure that describes the comments of non-hidden users that match a full-text search;
+// if the search has no term, only the form is to be shown.
+func (cf CompendiumFactory) Search(conn StorageConn, search CommentSearch, page Pagination) (CompendiumSearch, error) {
+	cs := CompendiumSearch{
+		Compendium: Compendium{
+			NbTop:    page.Limit,
+			Offset:   page.Offset,
+			Timezone: cf.Timezone,
+			Version:  Version,
+		},
+		Search: search,
+	}
+	if !cs.Searched() {
+		return cs, nil
+	}
+	err := conn.WithTx(func() error {
+		var err error
+		cs.rawComments, err = conn.SearchComments(search, page)
+		if err != nil {
+			return err
+		}
+		cs.revised, err = conn.RevisedComments(cs.rawComments)
+		return err
+	})
+	return cs, err
+}
+
+// Candidates returns a data structure that describes the pending candidates for registration.
+func (cf CompendiumFactory) Candidates(conn StorageConn) (CompendiumCandidates, error) {
+	cc := CompendiumCandidates{
+		Compendium: Compendium{
+			Timezone: cf.Timezone,
+			Version:  Version,
+		},
+	}
+
+	candidates, err := conn.ListCandidates()
+	if err != nil {
+		return cc, err
+	}
+
+	cc.Candidates = make([]Candidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		cc.Candidates = append(cc.Candidates, candidate.InTimezone(cf.Timezone))
+	}
+
+	return cc, nil
+}
+
 // Compendium describes the basic data of a page of the compendium.
 // Specific pages may use it directly or extend it.
 type Compendium struct {
-	All         []StatsView    // Statistics about every comments
-	NbTop       uint           // Number of most downvoted comments
-	Negative    []StatsView    // Statistics about comments with a negative score
-	Offset      uint           // Offset in the rank of the comments
-	Timezone    *time.Location // Timezone of the dates
-	Users       []User         // Users in the compendium
-	Version     SemVer         // Version of the application
-	rawComments []Comment
+	All              []StatsView    // Statistics about every comments
+	NbRedditAccounts uint           // Number of Reddit accounts the scans are split between
+	NbTop            uint           // Number of most downvoted comments
+	Negative         []StatsView    // Statistics about comments with a negative score
+	Offset           uint           // Offset in the rank of the comments
+	ScanSubmissions  bool           // Whether submissions are scanned along comments
+	Timezone         *time.Location // Timezone of the dates
+	Users            []User         // Users in the compendium
+	Version          SemVer         // Version of the application
+	rawComments      []Comment
+	rawSubmissions   []Submission
+	revised          map[string]bool // Comments whose body has been edited
 
 	CommentBodyConverter CommentBodyConverter
 }
@@ -159,11 +391,26 @@ func (c Compendium) Comments() []CommentView {
 	views := make([]CommentView, 0, len(c.rawComments))
 	for i, comment := range c.rawComments {
 		view := comment.ToView(uint64(i+1)+offset, c.Timezone, c.CommentBodyConverter)
+		view.Revised = c.revised[comment.ID]
 		views = append(views, view)
 	}
 	return views
 }
 
+// SubmissionsLen returns the number of top submissions without generating them.
+func (c Compendium) SubmissionsLen() int {
+	return len(c.rawSubmissions)
+}
+
+// Submissions generates the views for the top submissions.
+func (c Compendium) Submissions() []SubmissionView {
+	views := make([]SubmissionView, 0, len(c.rawSubmissions))
+	for i, submission := range c.rawSubmissions {
+		views = append(views, submission.ToView(uint64(i+1), c.Timezone, c.CommentBodyConverter))
+	}
+	return views
+}
+
 // HiddenUsersLen returns the number of hidden users.
 func (c Compendium) HiddenUsersLen() int {
 	var nb int
@@ -188,14 +435,23 @@ func (c Compendium) UsualScanDuration() time.Duration {
 			count++
 		}
 	}
-	return time.Duration(count) * RedditAPIRequestWait
+	duration := time.Duration(count) * RedditAPIRequestWait
+	if c.ScanSubmissions {
+		duration *= 2
+	}
+	if c.NbRedditAccounts > 1 {
+		duration /= time.Duration(c.NbRedditAccounts)
+	}
+	return duration
 }
 
 // CompendiumUser describes the compendium page for a single user.
 type CompendiumUser struct {
 	Compendium
-	Summary         StatsView // Statistics summarizing the user's activity
-	SummaryNegative StatsView // Statistics summarizing the user's activity based only on comments with a negative score
+	Karma           KarmaTrend // Trend of the karma of the account according to Reddit
+	Summary         StatsView  // Statistics summarizing the user's activity
+	SummaryNegative StatsView  // Statistics summarizing the user's activity based only on comments with a negative score
+	karmaHistory    []UserKarma
 }
 
 // Exists tells if the user exists.
@@ -208,6 +464,20 @@ func (cu CompendiumUser) User() User {
 	return cu.Users[0]
 }
 
+// KarmaTimeline returns the timeline of the karma of the comments of the user according to Reddit.
+func (cu CompendiumUser) KarmaTimeline() Timeline {
+	samples := make([]CommentScore, 0, len(cu.karmaHistory))
+	for _, sample := range cu.karmaHistory {
+		samples = append(samples, CommentScore{Score: sample.CommentKarma, Observed: sample.Observed})
+	}
+	return NewTimeline(samples)
+}
+
+// KarmaHistoryLen returns the number of samples of the karma of the user.
+func (cu CompendiumUser) KarmaHistoryLen() int {
+	return len(cu.karmaHistory)
+}
+
 // PercentageNegative returns the rounded percentage of comments in the negatives.
 func (cu CompendiumUser) PercentageNegative() int64 {
 	if cu.Summary.Count == 0 || cu.SummaryNegative.Count == 0 {
@@ -215,3 +485,237 @@ func (cu CompendiumUser) PercentageNegative() int64 {
 	}
 	return int64(math.Round(100 * float64(cu.SummaryNegative.Count) / float64(cu.Summary.Count)))
 }
+
+// CompendiumRemovals describes the compendium page for the removed comments.
+type CompendiumRemovals struct {
+	Compendium
+	Sub  string         // Subreddit of the removed comments, empty for all of them
+	Subs []RemovalStats // Statistics about the removals in each subreddit if Sub is empty
+}
+
+// CompendiumSearch describes the compendium page for a full-text search in the comments.
+type CompendiumSearch struct {
+	Compendium
+	Params url.Values    // Parameters of the search as given by the user, to fill the form and link to the next page
+	Search CommentSearch // Search that was done, if any
+}
+
+// Searched tells if a search was done, as opposed to only showing the form.
+func (cs CompendiumSearch) Searched() bool {
+	return cs.Search.MatchExpression() != ""
+}
+
+// NextURL returns the URL of the next page of results.
+func (cs CompendiumSearch) NextURL() string {
+	params := url.Values{}
+	for key, values := range cs.Params {
+		params[key] = values
+	}
+	params.Set("limit", strconv.FormatUint(uint64(cs.NbTop), 10))
+	params.Set("offset", strconv.FormatUint(uint64(cs.NextOffset()), 10))
+	return "/compendium/search?" + params.Encode()
+}
+
+// CompendiumCandidates describes the page of the pending candidates for registration.
+type CompendiumCandidates struct {
+	Compendium
+	Candidates []Candidate // Pending candidates, from the lowest score
+}
+
+// CompendiumComment describes the compendium page for a single comment and the history of its score.
+type CompendiumComment struct {
+	Compendium
+	Scores []CommentScore // Samples of the score, from the oldest to the newest
+}
+
+// Exists tells if the comment exists.
+func (cc CompendiumComment) Exists() bool {
+	return len(cc.rawComments) > 0
+}
+
+// Comment returns the single comment being described.
+func (cc CompendiumComment) Comment() CommentView {
+	return cc.Comments()[0]
+}
+
+// Timeline returns the coordinates of a line chart of the history of the score.
+func (cc CompendiumComment) Timeline() Timeline {
+	return NewTimeline(cc.Scores)
+}
+
+// CompendiumCommentHistory describes the compendium page for the previous versions of the body of a single comment.
+type CompendiumCommentHistory struct {
+	Compendium
+	Revisions []CommentRevision // Previous versions of the body, from the oldest to the newest
+}
+
+// Exists tells if the comment exists.
+func (ch CompendiumCommentHistory) Exists() bool {
+	return len(ch.rawComments) > 0
+}
+
+// Comment returns the single comment being described.
+func (ch CompendiumCommentHistory) Comment() CommentView {
+	return ch.Comments()[0]
+}
+
+// Versions returns all the versions of the body, from the oldest to the current one, with what changed in each of them.
+func (ch CompendiumCommentHistory) Versions() []CommentVersion {
+	versions := make([]CommentVersion, 0, len(ch.Revisions)+1)
+	for i, revision := range ch.Revisions {
+		versions = append(versions, CommentVersion{Number: i + 1, Body: revision.Body, Replaced: revision.Replaced})
+	}
+	versions = append(versions, CommentVersion{Number: len(versions) + 1, Body: ch.rawComments[0].Body, Current: true})
+
+	for i := range versions {
+		if i == 0 {
+			versions[i].Diff = []DiffSegment{{Kind: DiffEqual, Text: versions[i].Body}}
+		} else {
+			versions[i].Diff = NewTextDiff(versions[i-1].Body, versions[i].Body)
+		}
+	}
+	return versions
+}
+
+// CommentVersion is a version of the body of a comment.
+type CommentVersion struct {
+	Number   int           // Number of the version, starting at 1
+	Body     string        // Body of the comment in that version
+	Current  bool          // True if it is the current version
+	Replaced time.Time     // Date when the version was seen to be replaced, zero for the current version
+	Diff     []DiffSegment // Changes from the previous version, or the whole body for the first one
+}
+
+// DiffSegment is a part of a text that is either unchanged, deleted, or inserted.
+type DiffSegment struct {
+	Kind string // DiffEqual, DiffDelete, or DiffInsert
+	Text string
+}
+
+// NewTextDiff returns the changes between two texts, word by word.
+func NewTextDiff(before, after string) []DiffSegment {
+	var diff []DiffSegment
+	add := func(kind string, tokens ...string) {
+		if len(tokens) == 0 {
+			return
+		}
+		text := strings.Join(tokens, "")
+		if last := len(diff) - 1; last >= 0 && diff[last].Kind == kind {
+			diff[last].Text += text
+		} else {
+			diff = append(diff, DiffSegment{Kind: kind, Text: text})
+		}
+	}
+
+	a := matchDiffTokens.FindAllString(before, -1)
+	b := matchDiffTokens.FindAllString(after, -1)
+
+	// Edits are usually localized, so there's no need to compare the unchanged start and end.
+	var prefix, suffix int
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	add(DiffEqual, a[:prefix]...)
+	common := a[len(a)-suffix:]
+
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(a), len(b)
+	if n*m > TextDiffMaxCells {
+		add(DiffDelete, a...)
+		add(DiffInsert, b...)
+	} else {
+		// lengths[i*(m+1)+j] is the length of the longest common subsequence of a[i:] and b[j:].
+		lengths := make([]int32, (n+1)*(m+1))
+		for i := n - 1; i >= 0; i-- {
+			for j := m - 1; j >= 0; j-- {
+				if a[i] == b[j] {
+					lengths[i*(m+1)+j] = lengths[(i+1)*(m+1)+j+1] + 1
+				} else if down, right := lengths[(i+1)*(m+1)+j], lengths[i*(m+1)+j+1]; down >= right {
+					lengths[i*(m+1)+j] = down
+				} else {
+					lengths[i*(m+1)+j] = right
+				}
+			}
+		}
+
+		i, j := 0, 0
+		for i < n && j < m {
+			if a[i] == b[j] {
+				add(DiffEqual, a[i])
+				i++
+				j++
+			} else if lengths[(i+1)*(m+1)+j] >= lengths[i*(m+1)+j+1] {
+				add(DiffDelete, a[i])
+				i++
+			} else {
+				add(DiffInsert, b[j])
+				j++
+			}
+		}
+		add(DiffDelete, a[i:]...)
+		add(DiffInsert, b[j:]...)
+	}
+
+	add(DiffEqual, common...)
+	return diff
+}
+
+// Timeline describes the history of a score such as it is suitable to draw an SVG line chart in a template.
+type Timeline struct {
+	Height  float64 // Height of the coordinate system
+	Width   float64 // Width of the coordinate system
+	Min     int64   // Lowest score
+	Max     int64   // Highest score
+	Points  string  // Coordinates of the points, as the attribute of an SVG polyline
+	HasZero bool    // True if the score was both positive and negative
+	Zero    float64 // Vertical coordinate of a score of 0
+}
+
+// NewTimeline returns the Timeline of samples of scores ordered by date.
+func NewTimeline(scores []CommentScore) Timeline {
+	tl := Timeline{Height: TimelineHeight, Width: TimelineWidth}
+	if len(scores) == 0 {
+		return tl
+	}
+
+	tl.Min, tl.Max = scores[0].Score, scores[0].Score
+	for _, sample := range scores {
+		if sample.Score < tl.Min {
+			tl.Min = sample.Score
+		} else if sample.Score > tl.Max {
+			tl.Max = sample.Score
+		}
+	}
+
+	start := scores[0].Observed
+	duration := scores[len(scores)-1].Observed.Sub(start)
+
+	y := func(score int64) float64 {
+		if tl.Max == tl.Min {
+			return tl.Height / 2
+		}
+		return tl.Height * float64(tl.Max-score) / float64(tl.Max-tl.Min)
+	}
+
+	points := make([]string, 0, len(scores)+1)
+	for _, sample := range scores {
+		var x float64
+		if duration > 0 {
+			x = tl.Width * float64(sample.Observed.Sub(start)) / float64(duration)
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y(sample.Score)))
+	}
+	// A single sample is drawn as a flat line.
+	if duration == 0 {
+		points = append(points, fmt.Sprintf("%.1f,%.1f", tl.Width, y(scores[0].Score)))
+	}
+	tl.Points = strings.Join(points, " ")
+
+	tl.HasZero = tl.Min < 0 && tl.Max > 0
+	tl.Zero = y(0)
+
+	return tl
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -17,10 +18,18 @@ const Defaults string = `{
 	"timezone": "UTC",
 
 	"database": {
+		"backup_compression": "none",
+		"backup_interval": "0s",
 		"backup_max_age": "24h",
 		"backup_path": "./dab.db.backup",
+		"backup_retention": {
+			"daily": 7,
+			"hourly": 24,
+			"weekly": 4
+		},
 		"cleanup_interval": "30m",
 		"path": "./dab.db",
+		"score_history_retention": "720h",
 		"retry_connection": {
 			"times": 25,
 			"max_interval": "10s",
@@ -41,20 +50,39 @@ const Defaults string = `{
 	},
 
 	"reddit": {
+		"access_token_url": "https://www.reddit.com/api/v1/access_token",
+		"api_url": "https://oauth.reddit.com",
+		"app_only": false,
 		"retry_connection": {
 			"times": 10,
 			"max_interval": "5m",
 			"reset_after": "1h"
 		},
+		"backfill": false,
+		"compendium_wiki_interval": "1h",
+		"compendium_wiki_page": "compendium",
+		"discovery_cutoff": -10,
+		"discovery_interval": "5m",
 		"full_scan_interval": "6h",
 		"inactivity_threshold": "2200h",
+		"karma_interval": "0s",
 		"max_age": "24h",
-		"max_batches": 5
+		"max_batches": 5,
+		"min_scan_interval": "2m",
+		"report_delay": "1h",
+		"report_update_interval": "6h",
+		"report_update_max_age": "72h",
+		"scan_submissions": false,
+		"score_refresh_interval": "0s",
+		"score_refresh_max_age": "336h",
+		"score_refresh_max_comments": 1000,
+		"timeout": "1m"
 	},
 
 	"report": {
 		"cutoff": -50,
-		"nb_top": 5
+		"nb_top": 5,
+		"submissions_cutoff": -50
 	},
 
 	"web": {
@@ -68,13 +96,26 @@ const Defaults string = `{
 
 // StorageConf describes the configuration of the Storage layer.
 type StorageConf struct {
-	BackupMaxAge    Duration  `json:"backup_max_age"`
-	BackupPath      string    `json:"backup_path"`
-	CleanupInterval Duration  `json:"cleanup_interval"`
-	LogLevel        string    `json:"log_level"`
-	Path            string    `json:"path"`
-	Retry           RetryConf `json:"retry_connection"`
-	Timeout         Duration  `json:"timeout"`
+	BackupCompression     BackupCompression   `json:"backup_compression"`
+	BackupInterval        Duration            `json:"backup_interval"`
+	BackupMaxAge          Duration            `json:"backup_max_age"`
+	BackupPath            string              `json:"backup_path"`
+	BackupRetention       BackupRetentionConf `json:"backup_retention"`
+	CleanupInterval       Duration            `json:"cleanup_interval"`
+	LogLevel              string              `json:"log_level"`
+	Path                  string              `json:"path"`
+	Retry                 RetryConf           `json:"retry_connection"`
+	ScoreHistoryRetention Duration            `json:"score_history_retention"`
+	Timeout               Duration            `json:"timeout"`
+}
+
+// BackupRetentionConf describes which backups of the database are kept in a directory,
+// as the most recent backup of each of the last hours, days, and weeks.
+type BackupRetentionConf struct {
+	Daily  uint   `json:"daily"`
+	Dir    string `json:"dir"`
+	Hourly uint   `json:"hourly"`
+	Weekly uint   `json:"weekly"`
 }
 
 // RetryConf describes the configuration of the retry logic for a component.
@@ -88,17 +129,55 @@ type RetryConf struct {
 type RedditAuth struct {
 	ID       string `json:"id"`
 	Password string `json:"password"`
+	Proxy    string `json:"proxy"`
 	Secret   string `json:"secret"`
 	Username string `json:"username"`
 }
 
+// Name returns the name of the account to use in messages, which is the ID of the application if there is no user name.
+func (auth RedditAuth) Name() string {
+	if auth.Username == "" {
+		return "application " + auth.ID
+	}
+	return auth.Username
+}
+
+// RedditAPIConf describes the configuration of the clients of Reddit's API.
+type RedditAPIConf struct {
+	AccessTokenURL string   `json:"access_token_url"`
+	APIURL         string   `json:"api_url"`
+	AppOnly        bool     `json:"app_only"`
+	Timeout        Duration `json:"timeout"`
+}
+
 // RedditScannerConf describes the configuration of the scanner for Reddit.
 type RedditScannerConf struct {
-	FullScanInterval    Duration `json:"full_scan_interval"`
-	HighScoreThreshold  int64    `json:"-"`
-	InactivityThreshold Duration `json:"inactivity_threshold"`
-	MaxAge              Duration `json:"max_age"`
-	MaxBatches          uint     `json:"max_batches"`
+	Backfill                bool     `json:"backfill"`
+	FullScanInterval        Duration `json:"full_scan_interval"`
+	HighScoreThreshold      int64    `json:"-"`
+	InactivityThreshold     Duration `json:"inactivity_threshold"`
+	MaxAge                  Duration `json:"max_age"`
+	MaxBatches              uint     `json:"max_batches"`
+	MinScanInterval         Duration `json:"min_scan_interval"`
+	ScanSubmissions         bool     `json:"scan_submissions"`
+	ScoreRefreshInterval    Duration `json:"score_refresh_interval"`
+	ScoreRefreshMaxAge      Duration `json:"score_refresh_max_age"`
+	ScoreRefreshMaxComments uint     `json:"score_refresh_max_comments"`
+}
+
+// RedditReportsConf describes the configuration for publishing the reports on Reddit.
+type RedditReportsConf struct {
+	ReportDelay          Duration `json:"report_delay"`
+	ReportSub            string   `json:"report_sub"`
+	ReportUpdateInterval Duration `json:"report_update_interval"`
+	ReportUpdateMaxAge   Duration `json:"report_update_max_age"`
+}
+
+// RedditWikiConf describes the configuration for publishing the compendium on the wiki of a subreddit.
+type RedditWikiConf struct {
+	CompendiumWikiInterval Duration `json:"compendium_wiki_interval"`
+	CompendiumWikiPage     string   `json:"compendium_wiki_page"`
+	CompendiumWikiSub      string   `json:"compendium_wiki_sub"`
 }
 
 // WatchSubmissions describes the configuration for watching submissions to a subreddit (deprecated).
@@ -111,6 +190,10 @@ type WatchSubmissions struct {
 type RedditUsersConf struct {
 	Compendium               WatchCompendiumConf `json:"compendium"`
 	CompendiumUpdateInterval Duration            `json:"compendium_update_interval"` // Deprecated
+	DiscoveryCutOff          int64               `json:"discovery_cutoff"`
+	DiscoveryInterval        Duration            `json:"discovery_interval"`
+	DiscoverySubs            []string            `json:"discovery_subs"`
+	KarmaInterval            Duration            `json:"karma_interval"`
 	ResurrectionsInterval    Duration            `json:"resurrections_interval"`
 	UnsuspensionInterval     Duration            `json:"unsuspension_interval"` // Deprecated
 }
@@ -123,16 +206,19 @@ type WatchCompendiumConf struct {
 
 // CompendiumConf describes the configuration for the compendium generated by the application.
 type CompendiumConf struct {
-	NbTop    uint     `json:"nb_top"`
-	Timezone Timezone `json:"-"`
+	NbRedditAccounts uint     `json:"-"`
+	NbTop            uint     `json:"nb_top"`
+	ScanSubmissions  bool     `json:"-"`
+	Timezone         Timezone `json:"-"`
 }
 
 // ReportConf describes the configuration for generating reports, which is propagated to the configuration of the compendium.
 type ReportConf struct {
-	CutOff   int64    `json:"cutoff"`
-	Leeway   Duration `json:"leeway"` // Deprecated
-	NbTop    uint     `json:"nb_top"`
-	Timezone Timezone `json:"-"`
+	CutOff            int64    `json:"cutoff"`
+	Leeway            Duration `json:"leeway"` // Deprecated
+	NbTop             uint     `json:"nb_top"`
+	SubmissionsCutOff int64    `json:"submissions_cutoff"`
+	Timezone          Timezone `json:"-"`
 }
 
 // DiscordBotConf describes the configuration for the bot for Discord.
@@ -157,14 +243,15 @@ type DiscordBotChannelsID struct {
 
 // WebConf describes the configuration for the application's web server.
 type WebConf struct {
-	DBOptimize   Duration `json:"db_optimize"`
-	DefaultLimit uint     `json:"default_limit"`
-	DirtyReads   bool     `json:"dirty_reads"`
-	IPHeader     string   `json:"ip_header"`
-	Listen       string   `json:"listen"`
-	MaxLimit     uint     `json:"max_limit"`
-	NbDBConn     uint     `json:"nb_db_conn"`
-	RootDir      string   `json:"root_dir"`
+	DBOptimize      Duration          `json:"db_optimize"`
+	DefaultLimit    uint              `json:"default_limit"`
+	DirtyReads      bool              `json:"dirty_reads"`
+	IPHeader        string            `json:"ip_header"`
+	Listen          string            `json:"listen"`
+	MaxLimit        uint              `json:"max_limit"`
+	NbDBConn        uint              `json:"nb_db_conn"`
+	PrivilegedUsers map[string]string `json:"privileged_users"`
+	RootDir         string            `json:"root_dir"`
 }
 
 // Configuration holds the configuration for the whole application.
@@ -180,8 +267,12 @@ type Configuration struct {
 
 	Reddit struct {
 		RedditAuth
+		RedditAPIConf
+		RedditReportsConf
 		RedditScannerConf
 		RedditUsersConf
+		RedditWikiConf
+		Accounts         []RedditAuth       `json:"accounts"`
 		DVTInterval      Duration           `json:"dvt_interval"` // Deprecated
 		LogLevel         string             `json:"log_level"`
 		Retry            RetryConf          `json:"retry_connection"`
@@ -207,6 +298,7 @@ type Configuration struct {
 }
 
 // NewConfiguration returns the configuration for the whole application by reading a JSON file given as a path.
+// If the path is empty, only the defaults are used.
 func NewConfiguration(path string) (Configuration, error) {
 	var conf Configuration
 	buffer := bytes.NewBuffer([]byte(Defaults))
@@ -216,13 +308,15 @@ func NewConfiguration(path string) (Configuration, error) {
 		return conf, err
 	}
 
-	rawConf, err := ioutil.ReadFile(path)
-	if err != nil {
-		return conf, err
-	}
-	buffer.Write(rawConf)
-	if err := decoder.Decode(&conf); err != nil {
-		return conf, err
+	if path != "" {
+		rawConf, err := ioutil.ReadFile(path)
+		if err != nil {
+			return conf, err
+		}
+		buffer.Write(rawConf)
+		if err := decoder.Decode(&conf); err != nil {
+			return conf, err
+		}
 	}
 
 	conf.Report.Timezone = conf.Timezone
@@ -231,6 +325,13 @@ func NewConfiguration(path string) (Configuration, error) {
 
 	conf.Compendium.NbTop = conf.Report.NbTop
 
+	// The credentials directly in the reddit section are those of the first account.
+	if conf.Reddit.RedditAuth != (RedditAuth{}) {
+		conf.Reddit.Accounts = append([]RedditAuth{conf.Reddit.RedditAuth}, conf.Reddit.Accounts...)
+	}
+	conf.Compendium.NbRedditAccounts = uint(len(conf.Reddit.Accounts))
+	conf.Compendium.ScanSubmissions = conf.Reddit.ScanSubmissions
+
 	conf.Reddit.RedditScannerConf.HighScoreThreshold = conf.Discord.HighScoreThreshold
 	if conf.Discord.DiscordBotConf.HidePrefix == "" {
 		conf.Discord.DiscordBotConf.HidePrefix = conf.HidePrefix
@@ -268,26 +369,72 @@ func (conf Configuration) HasSaneValues() error {
 		return errors.New("backup max age before renewal can't be less than an hour")
 	} else if conf.Database.Path == conf.Database.BackupPath {
 		return errors.New("backup path can't be the same as the database's path")
+	} else if !conf.Database.BackupCompression.Valid() {
+		return fmt.Errorf("invalid compression %q of the backups, it must be \"none\", \"gzip\" or \"zstd\"", conf.Database.BackupCompression)
+	} else if val := conf.Database.BackupInterval.Value; val != 0 && val < time.Hour {
+		return errors.New("interval between scheduled backups can't be less than an hour if non-zero")
+	} else if r := conf.Database.BackupRetention; r.Dir != "" && r.Hourly+r.Daily+r.Weekly == 0 {
+		return errors.New("at least one backup must be retained if the directory of the retained backups is set")
 	} else if val := conf.Database.CleanupInterval.Value; val != 0 && val < time.Minute {
 		return errors.New("interval between database cleanups can't be less than a minute")
+	} else if val := conf.Database.ScoreHistoryRetention.Value; val != 0 && val < 24*time.Hour {
+		return errors.New("retention of the full history of the scores of comments can't be less than a day if non-zero")
+	} else if conf.Reddit.AppOnly && hasRedditUserCredentials(conf.Reddit.Accounts) {
+		return errors.New("reddit accounts can't have a username or a password in application-only mode")
+	} else if name := duplicateRedditAccount(conf.Reddit.Accounts); name != "" {
+		return fmt.Errorf("reddit account %q is used more than once", name)
+	} else if err := invalidRedditProxy(conf.Reddit.Accounts); err != nil {
+		return err
+	} else if err := invalidRedditURLs(conf.Reddit.RedditAPIConf); err != nil {
+		return err
+	} else if conf.Reddit.Timeout.Value < 5*time.Second {
+		return errors.New("timeout of requests to reddit can't be less than 5 seconds")
 	} else if conf.Reddit.FullScanInterval.Value < time.Hour {
 		return errors.New("interval for the full scan can't be less an hour")
+	} else if conf.Reddit.MinScanInterval.Value < time.Minute {
+		return errors.New("minimum interval between scans of a user can't be less than a minute")
+	} else if conf.Reddit.MinScanInterval.Value > conf.Reddit.FullScanInterval.Value {
+		return errors.New("minimum interval between scans of a user can't be more than the interval for the full scan")
 	} else if conf.Reddit.InactivityThreshold.Value < 24*time.Hour {
 		return errors.New("inactivity threshold can't be less than a day")
 	} else if conf.Reddit.MaxAge.Value < 24*time.Hour {
 		return errors.New("max comment age for further scanning can't be less than a day")
+	} else if val := conf.Reddit.ScoreRefreshInterval.Value; val != 0 && val < time.Minute {
+		return errors.New("interval between refreshes of the scores of comments can't be less than a minute if non-zero")
+	} else if conf.Reddit.ScoreRefreshInterval.Value != 0 && conf.Reddit.ScoreRefreshMaxAge.Value <= conf.Reddit.MaxAge.Value {
+		return errors.New("max age of comments whose score is refreshed must be higher than the max comment age for further scanning")
 	} else if conf.Reddit.HighScoreThreshold > -1 {
 		return errors.New("high-score threshold can't be positive")
 	} else if val := conf.Reddit.ResurrectionsInterval.Value; val != 0 && val < time.Minute {
 		return errors.New("interval between batches of checks of resurrections of users can't be less than a minute if non-zero")
+	} else if len(conf.Reddit.DiscoverySubs) > 0 && conf.Reddit.DiscoveryInterval.Value < time.Minute {
+		return errors.New("interval between checks of the subreddits where users are discovered can't be less than a minute")
+	} else if conf.Reddit.DiscoveryCutOff > 0 {
+		return errors.New("cut-off of the comments from which users are discovered can't be higher than 0")
+	} else if val := conf.Reddit.KarmaInterval.Value; val != 0 && val < time.Hour {
+		return errors.New("interval between samples of the karma of users can't be less than an hour if non-zero")
+	} else if conf.Reddit.ReportSub != "" && (conf.Reddit.ReportDelay.Value < 0 || conf.Reddit.ReportUpdateMaxAge.Value < 0) {
+		return errors.New("delay before publishing reports on reddit and how long they are updated can't be negative")
+	} else if conf.Reddit.ReportSub != "" && conf.Reddit.ReportUpdateInterval.Value < 10*time.Minute {
+		return errors.New("interval between updates of the reports published on reddit can't be less than 10 minutes")
+	} else if conf.Reddit.ReportSub != "" && conf.Reddit.ReportDelay.Value+conf.Reddit.ReportUpdateMaxAge.Value > 7*24*time.Hour {
+		return errors.New("delay before publishing reports on reddit and how long they are updated can't add up to more than a week")
+	} else if conf.Reddit.CompendiumWikiSub != "" && conf.Reddit.CompendiumWikiInterval.Value < 10*time.Minute {
+		return errors.New("interval between updates of the compendium published on reddit can't be less than 10 minutes")
+	} else if conf.Reddit.CompendiumWikiSub != "" && strings.Trim(conf.Reddit.CompendiumWikiPage, "/ ") == "" {
+		return errors.New("page of the wiki where the compendium is published can't be empty")
 	} else if conf.Report.Leeway.Value < 0 { // Deprecated
 		return errors.New("reports' leeway can't be negative")
 	} else if conf.Report.CutOff > 0 {
 		return errors.New("reports' cut-off can't be higher than 0")
+	} else if conf.Report.SubmissionsCutOff > 0 {
+		return errors.New("reports' cut-off for submissions can't be higher than 0")
 	} else if conf.Web.DBOptimize.Value < 5*time.Minute {
 		return errors.New("the duration of the optimization of the web server's connections to the database can't be less than 5 minutes")
 	} else if conf.Web.NbDBConn == 0 {
 		return errors.New("the number of database connections from the web server can't be 0")
+	} else if name := emptyPassword(conf.Web.PrivilegedUsers); name != "" {
+		return fmt.Errorf("privileged user %q of the web server has an empty password", name)
 	}
 	return nil
 }
@@ -305,7 +452,7 @@ func (conf Configuration) Deprecations() []string {
 	}
 
 	if conf.Reddit.WatchSubmissions != nil {
-		msgs = append(msgs, "reddit.watch_submissions is deprecated")
+		msgs = append(msgs, "reddit.watch_submissions is deprecated, use reddit.scan_submissions to scan the submissions of registered users")
 	}
 
 	if conf.Reddit.CompendiumUpdateInterval.Value != 0 {
@@ -331,12 +478,23 @@ func (conf Configuration) Deprecations() []string {
 func (conf Configuration) Components() ComponentsConf {
 	c := ComponentsConf{}
 
-	redditRequired := map[string]string{
-		"id":         conf.Reddit.ID,
-		"password":   conf.Reddit.Password,
-		"secret":     conf.Reddit.Secret,
-		"user agent": conf.Reddit.UserAgent,
-		"username":   conf.Reddit.Username,
+	redditRequired := map[string]string{"user agent": conf.Reddit.UserAgent}
+	accounts := conf.Reddit.Accounts
+	if len(accounts) == 0 {
+		accounts = []RedditAuth{{}}
+	}
+	for i, auth := range accounts {
+		prefix := ""
+		if i > 0 {
+			prefix = fmt.Sprintf("account #%d ", i+1)
+		}
+		redditRequired[prefix+"id"] = auth.ID
+		redditRequired[prefix+"secret"] = auth.Secret
+		// Application-only authentication doesn't need the credentials of a user.
+		if !conf.Reddit.AppOnly {
+			redditRequired[prefix+"password"] = auth.Password
+			redditRequired[prefix+"username"] = auth.Username
+		}
 	}
 	var redditInvalid []string
 	for name, value := range redditRequired {
@@ -369,6 +527,60 @@ func (conf Configuration) Components() ComponentsConf {
 	return c
 }
 
+func duplicateRedditAccount(accounts []RedditAuth) string {
+	seen := make(map[string]struct{})
+	for _, auth := range accounts {
+		name := strings.ToLower(auth.Name())
+		if _, ok := seen[name]; ok && (auth.Username != "" || auth.ID != "") {
+			return auth.Name()
+		}
+		seen[name] = struct{}{}
+	}
+	return ""
+}
+
+func emptyPassword(users map[string]string) string {
+	for name, password := range users {
+		if password == "" {
+			return name
+		}
+	}
+	return ""
+}
+
+func invalidRedditProxy(accounts []RedditAuth) error {
+	for _, auth := range accounts {
+		if auth.Proxy == "" {
+			continue
+		}
+		if _, err := ParseRedditProxy(auth.Proxy); err != nil {
+			return fmt.Errorf("invalid proxy for reddit account %q: %v", auth.Name(), err)
+		}
+	}
+	return nil
+}
+
+func hasRedditUserCredentials(accounts []RedditAuth) bool {
+	for _, auth := range accounts {
+		if auth.Username != "" || auth.Password != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func invalidRedditURLs(conf RedditAPIConf) error {
+	for name, rawURL := range map[string]string{"access token": conf.AccessTokenURL, "API": conf.APIURL} {
+		parsed, err := url.Parse(rawURL)
+		if err != nil {
+			return fmt.Errorf("invalid URL for reddit's %s: %v", name, err)
+		} else if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("URL for reddit's %s must be an absolute HTTP or HTTPS URL, not %q", name, rawURL)
+		}
+	}
+	return nil
+}
+
 // ComponentConf describes the state of the configuration of a single component.
 type ComponentConf struct {
 	Enabled bool
@@ -6,13 +6,14 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"os"
 	"regexp"
 	"strings"
 	"text/template"
 )
 
 // Version of the application.
-var Version = SemVer{1, 26, 4}
+var Version = SemVer{1, 31, 0}
 
 // DefaultChannelSize is the size of the channels that are used throughout of the application, unless there's a need for a specific size.
 const DefaultChannelSize = 100
@@ -31,10 +32,18 @@ type DownArrowsBot struct {
 	stdOut  io.Writer
 
 	runtimeConf struct {
-		ConfPath string
-		InitDB   bool
-		Report   bool
-		UserAdd  string
+		ConfPath      string
+		Demo          bool
+		ImportArchive string
+		InitDB        bool
+		Merge         string
+		MergeDryRun   bool
+		MergeRules    string
+		Migrations    bool
+		MigrationsDry bool
+		Report        bool
+		Rollback      string
+		UserAdd       string
 	}
 
 	conf Configuration
@@ -49,8 +58,10 @@ type DownArrowsBot struct {
 	components struct {
 		ConfState     ComponentsConf
 		Discord       *DiscordBot
+		RedditReports *RedditReports
 		RedditScanner *RedditScanner
 		RedditUsers   *RedditUsers
+		RedditWiki    *RedditWiki
 		Web           *WebServer
 	}
 }
@@ -80,10 +91,28 @@ func (dab *DownArrowsBot) Run(ctx context.Context, args []string) error {
 
 	// Most of the decisions about what parts of the code
 	// should be enabled is done there.
+	confPath := dab.runtimeConf.ConfPath
+	if dab.runtimeConf.Demo {
+		// The demonstration doesn't need a configuration file.
+		if _, err := os.Stat(confPath); os.IsNotExist(err) {
+			confPath = ""
+		}
+	}
+
 	var conf Configuration
-	if conf, err = NewConfiguration(dab.runtimeConf.ConfPath); err != nil {
+	if conf, err = NewConfiguration(confPath); err != nil {
 		return fmt.Errorf("error when reading configuration for DAB version %s: %v", Version, err)
 	}
+
+	var demo *Demo
+	if dab.runtimeConf.Demo {
+		if demo, err = NewDemo(); err != nil {
+			return err
+		}
+		defer demo.Close()
+		conf = demo.Configure(conf)
+	}
+
 	dab.conf = conf
 	dab.components.ConfState = conf.Components()
 
@@ -109,11 +138,20 @@ func (dab *DownArrowsBot) Run(ctx context.Context, args []string) error {
 		dab.logger.Info(msg)
 	}
 
+	if demo != nil {
+		dab.logger.Infof("running in demonstration mode with %s", demo)
+	}
+
 	dab.logger.Infof("using database %s", dab.conf.Database.Path)
 	db_logger, err := NewStdLevelLogger("db", dab.logOut, conf.Database.LogLevel)
 	if err != nil {
 		return fmt.Errorf("error when setting a logging level for the database: %v", err)
 	}
+
+	if dab.runtimeConf.Migrations || dab.runtimeConf.MigrationsDry || dab.runtimeConf.Rollback != "" {
+		return dab.migrations(ctx, db_logger)
+	}
+
 	storage, conn, err := NewStorage(ctx, db_logger, dab.conf.Database.StorageConf)
 	if err != nil {
 		return err
@@ -126,6 +164,14 @@ func (dab *DownArrowsBot) Run(ctx context.Context, args []string) error {
 		return nil
 	}
 
+	if dab.runtimeConf.ImportArchive != "" {
+		return dab.importArchive(ctx, conn)
+	}
+
+	if dab.runtimeConf.Merge != "" {
+		return dab.merge(ctx, conn)
+	}
+
 	dab.layers.Report = NewReportFactory(dab.conf.Report)
 	if dab.runtimeConf.Report {
 		return dab.report(ctx, conn)
@@ -144,54 +190,142 @@ func (dab *DownArrowsBot) Run(ctx context.Context, args []string) error {
 
 	dab.logger.Info(dab.components.ConfState)
 
-	if dab.components.ConfState.Web.Enabled {
-		web_logger, err := NewStdLevelLogger("web", dab.logOut, dab.conf.Web.LogLevel)
-		if err != nil {
-			return fmt.Errorf("error when setting a logging level for the web server: %v", err)
-		}
-		dab.components.Web = NewWebServer(web_logger, dab.layers.Storage, dab.layers.Report, dab.layers.Compendium, dab.conf.Web.WebConf)
-		tasks.SpawnCtx(dab.components.Web.Run)
-	}
-
 	if dab.layers.Storage.PeriodicCleanupIsEnabled() {
 		tasks.SpawnCtx(dab.layers.Storage.PeriodicCleanup)
 	}
 
+	if dab.layers.Storage.PeriodicBackupIsEnabled() {
+		tasks.SpawnCtx(dab.layers.Storage.PeriodicBackup)
+	}
+
 	if dab.components.ConfState.Reddit.Enabled {
-		redditAPI, err := dab.makeRedditAPI(ctx)
+		redditAPIs, err := dab.makeRedditAPIs(ctx)
 		if err != nil {
 			return err
 		}
+		// Users are managed with a single account, the others are only used to scan.
+		redditAPI := redditAPIs[0]
 
 		reddit_logger, err := NewStdLevelLogger("reddit", dab.logOut, dab.conf.Reddit.LogLevel)
 		if err != nil {
 			return fmt.Errorf("error when setting a logging level for the reddit components: %v", err)
 		}
-		dab.components.RedditScanner = NewRedditScanner(reddit_logger, dab.layers.Storage, redditAPI, dab.conf.Reddit.RedditScannerConf)
-		dab.components.RedditUsers = NewRedditUsers(reddit_logger, redditAPI, dab.conf.Reddit.RedditUsersConf)
+		dab.components.RedditScanner = NewRedditScanner(reddit_logger, dab.layers.Storage, redditAPIs, dab.conf.Reddit.RedditScannerConf)
+		dab.components.RedditUsers = NewRedditUsers(reddit_logger, dab.layers.Storage, redditAPI, dab.conf.Reddit.RedditUsersConf)
+		dab.components.RedditReports = NewRedditReports(reddit_logger, dab.layers.Storage.KV(), redditAPI, dab.layers.Report,
+			dab.conf.Reddit.RedditReportsConf)
+		dab.components.RedditWiki = NewRedditWiki(reddit_logger, redditAPI, dab.layers.Compendium, dab.conf.Reddit.RedditWikiConf)
+		if redditAPI.AppOnly() && (dab.conf.Reddit.ReportSub != "" || dab.conf.Reddit.CompendiumWikiSub != "") {
+			reddit_logger.Errorf("publishing the reports and the compendium on reddit is disabled in application-only mode")
+		}
+
+		for _, api := range redditAPIs {
+			reconnections := api.OpenReconnections()
+			tasks.Spawn(func() {
+				for reconnection := range reconnections {
+					if reconnection.Error != nil {
+						reddit_logger.Errorf("%s", reconnection)
+					} else {
+						reddit_logger.Info(reconnection)
+					}
+				}
+			})
+		}
+		tasks.SpawnCtx(func(ctx context.Context) error {
+			<-ctx.Done()
+			for _, api := range redditAPIs {
+				api.CloseReconnections()
+			}
+			return ctx.Err()
+		})
 
 		retrier := NewRetrier(dab.conf.Reddit.Retry, func(r *Retrier, err error) {
 			dab.logger.Errorf("error in reddit component, restarting (%s): %v", r, err)
 		})
 
 		tasks.SpawnCtx(retrier.Set(func(ctx context.Context) error {
-			dab.logger.Info("attempting to log into reddit")
-			if err := redditAPI.Connect(ctx); err != nil {
-				return err
+			for i, api := range redditAPIs {
+				dab.logger.Infof("attempting to log into reddit with account %q", dab.conf.Reddit.Accounts[i].Name())
+				if err := api.Connect(ctx); err != nil {
+					return err
+				}
 			}
-			dab.logger.Info("successfully logged into reddit")
+			dab.logger.Infof("successfully logged into reddit with %d account(s)", len(redditAPIs))
 
 			return dab.components.RedditScanner.Run(ctx)
 		}).Task)
 	}
 
+	if dab.components.ConfState.Reddit.Enabled && dab.components.RedditUsers.KarmaSamplerEnabled {
+		tasks.SpawnCtx(func(ctx context.Context) error {
+			return dab.layers.Storage.WithConn(ctx, func(conn StorageConn) error {
+				return dab.components.RedditUsers.KarmaSampler(ctx, conn)
+			})
+		})
+	}
+
+	if dab.components.ConfState.Reddit.Enabled && dab.components.RedditReports.Enabled {
+		tasks.SpawnCtx(func(ctx context.Context) error {
+			return dab.layers.Storage.WithConn(ctx, func(conn StorageConn) error {
+				return dab.components.RedditReports.Publisher(ctx, conn)
+			})
+		})
+	}
+
+	if dab.components.ConfState.Reddit.Enabled && dab.components.RedditWiki.Enabled {
+		tasks.SpawnCtx(func(ctx context.Context) error {
+			return dab.layers.Storage.WithConn(ctx, func(conn StorageConn) error {
+				return dab.components.RedditWiki.CompendiumPublisher(ctx, conn)
+			})
+		})
+	}
+
+	if dab.components.ConfState.Reddit.Enabled && dab.components.RedditUsers.DiscoveryEnabled {
+		tasks.SpawnCtx(func(ctx context.Context) error {
+			return dab.layers.Storage.WithConn(ctx, func(conn StorageConn) error {
+				return dab.components.RedditUsers.Discovery(ctx, conn)
+			})
+		})
+	}
+
+	if dab.components.ConfState.Web.Enabled {
+		web_logger, err := NewStdLevelLogger("web", dab.logOut, dab.conf.Web.LogLevel)
+		if err != nil {
+			return fmt.Errorf("error when setting a logging level for the web server: %v", err)
+		}
+		var addUser AddRedditUser
+		if dab.components.ConfState.Reddit.Enabled {
+			addUser = dab.components.RedditUsers.Add
+		}
+		dab.components.Web = NewWebServer(web_logger, dab.layers.Storage, dab.layers.Report, dab.layers.Compendium,
+			addUser, dab.conf.Web.WebConf)
+		tasks.SpawnCtx(dab.components.Web.Run)
+	}
+
+	if dab.components.ConfState.Reddit.Enabled && dab.components.RedditScanner.ScoreRefreshIsEnabled() {
+		tasks.SpawnCtx(func(ctx context.Context) error {
+			return dab.layers.Storage.WithConn(ctx, func(conn StorageConn) error {
+				return dab.components.RedditScanner.RefreshScores(ctx, conn)
+			})
+		})
+	}
+
+	if demo != nil && dab.components.ConfState.Reddit.Enabled {
+		tasks.SpawnCtx(demo.Serve)
+		tasks.SpawnCtx(func(ctx context.Context) error {
+			return dab.layers.Storage.WithConn(ctx, func(conn StorageConn) error {
+				return demo.AddUsers(ctx, conn, dab.components.RedditUsers.Add)
+			})
+		})
+	}
+
 	if dab.components.ConfState.Discord.Enabled {
 		discord_logger, err := NewStdLevelLogger("discord", dab.logOut, dab.conf.Discord.LogLevel)
 		if err != nil {
 			return err
 		}
 		// We're re-using the database's first connection here, don't share it with any other component.
-		dab.components.Discord, err = NewDiscordBot(discord_logger, conn, dab.layers.Storage.KV(),
+		dab.components.Discord, err = NewDiscordBot(discord_logger, conn, dab.layers.Storage.WhoRegistered(),
 			dab.components.RedditUsers.Add, dab.conf.Discord.DiscordBotConf)
 		if err != nil {
 			return err
@@ -240,8 +374,24 @@ func (dab *DownArrowsBot) parseFlags(args []string) error {
 	dab.flagSet.SetOutput(dab.stdOut)
 	dab.flagSet.StringVar(&dab.logLvl, "log", "", "Logging level ("+strings.Join(LevelLoggerLevels, ", ")+").")
 	dab.flagSet.StringVar(&dab.runtimeConf.ConfPath, "config", "./dab.conf.json", "Path to the configuration file.")
+	dab.flagSet.BoolVar(&dab.runtimeConf.Demo, "demo", false,
+		"Run offline with generated users on a fake Reddit and a temporary database, to try out the web interface.")
+	dab.flagSet.StringVar(&dab.runtimeConf.ImportArchive, "import-archive", "",
+		"Import the comments of registered users from a file of JSON comments, one per line, optionally compressed with gzip or zstd, and exit.")
 	dab.flagSet.BoolVar(&dab.runtimeConf.InitDB, "initdb", false, "Initialize the database and exit.")
+	dab.flagSet.StringVar(&dab.runtimeConf.Merge, "merge", "",
+		"Merge the users, comments, and key/value data of another database of the same version, print a summary, and exit.")
+	dab.flagSet.BoolVar(&dab.runtimeConf.MergeDryRun, "merge-dry-run", false, "Print the summary of the merge without saving it.")
+	dab.flagSet.StringVar(&dab.runtimeConf.MergeRules, "merge-rules", DefaultMergeRules.String(),
+		"Comma-separated rules to resolve the conflicts of the merge: "+
+			"hidden=ours|theirs|any, removals=ours|any, scores=newest|ours|theirs|lowest.")
+	dab.flagSet.BoolVar(&dab.runtimeConf.Migrations, "migrations", false,
+		"Print the version that last wrote the database, the pending migrations, and the oldest version it can be rolled back to, and exit.")
+	dab.flagSet.BoolVar(&dab.runtimeConf.MigrationsDry, "migrations-dry-run", false,
+		"Apply the pending migrations to a temporary copy of the database to check that they work, and exit.")
 	dab.flagSet.BoolVar(&dab.runtimeConf.Report, "report", false, "Print the report for the last week and exit (deprecated).")
+	dab.flagSet.StringVar(&dab.runtimeConf.Rollback, "rollback", "",
+		"Revert the migrations of the database down to the given version, after backing it up, and exit.")
 	dab.flagSet.StringVar(&dab.runtimeConf.UserAdd, "useradd", "",
 		"Add one or multiple usernames separated by a white space or a comma to be tracked and exit.")
 
@@ -256,18 +406,22 @@ func (dab *DownArrowsBot) parseFlags(args []string) error {
 	return nil
 }
 
-func (dab *DownArrowsBot) makeRedditAPI(ctx context.Context) (*RedditAPI, error) {
+func (dab *DownArrowsBot) makeRedditAPIs(ctx context.Context) ([]*RedditAPI, error) {
 	userAgent, err := template.New("UserAgent").Parse(dab.conf.Reddit.UserAgent)
 	if err != nil {
 		return nil, err
 	}
 
-	ra, err := NewRedditAPI(ctx, dab.conf.Reddit.RedditAuth, userAgent)
-	if err != nil {
-		return nil, err
+	apis := make([]*RedditAPI, 0, len(dab.conf.Reddit.Accounts))
+	for _, auth := range dab.conf.Reddit.Accounts {
+		ra, err := NewRedditAPI(ctx, auth, userAgent, dab.conf.Reddit.RedditAPIConf)
+		if err != nil {
+			return nil, err
+		}
+		apis = append(apis, ra)
 	}
 
-	return ra, nil
+	return apis, nil
 }
 
 func (dab *DownArrowsBot) report(ctx context.Context, conn StorageConn) error {
@@ -277,24 +431,107 @@ func (dab *DownArrowsBot) report(ctx context.Context, conn StorageConn) error {
 	report, err := dab.layers.Report.ReportWeek(conn, year, week)
 	if err != nil {
 		return err
-	} else if report.Len() == 0 {
+	} else if report.Len() == 0 && report.SubmissionsLen() == 0 {
 		return errors.New("empty report")
 	}
 
 	return MarkdownReport.Execute(dab.stdOut, report)
 }
 
+func (dab *DownArrowsBot) importArchive(ctx context.Context, conn StorageConn) error {
+	path := dab.runtimeConf.ImportArchive
+	dab.logger.Infof("importing comments from %q", path)
+	stats, err := ImportArchive(ctx, dab.logger, conn, path)
+	if err != nil {
+		return fmt.Errorf("error when importing %q (%s): %v", path, stats, err)
+	}
+	dab.logger.Infof("imported %q: %s", path, stats)
+	return nil
+}
+
+func (dab *DownArrowsBot) merge(ctx context.Context, conn StorageConn) error {
+	path := dab.runtimeConf.Merge
+	rules, err := ParseMergeRules(dab.runtimeConf.MergeRules)
+	if err != nil {
+		return err
+	}
+
+	dab.logger.Infof("merging %q with rules %s", path, rules)
+	stats, err := dab.layers.Storage.Merge(ctx, conn, path, rules, dab.runtimeConf.MergeDryRun)
+	if err != nil {
+		return fmt.Errorf("error when merging %q: %v", path, err)
+	}
+
+	if dab.runtimeConf.MergeDryRun {
+		_, err = fmt.Fprintf(dab.stdOut, "dry run of the merge of %q, nothing was saved: %s\n", path, stats)
+	} else {
+		_, err = fmt.Fprintf(dab.stdOut, "merged %q: %s\n", path, stats)
+	}
+	return err
+}
+
+func (dab *DownArrowsBot) migrations(ctx context.Context, logger LevelLogger) error {
+	db, conn, err := OpenStorageForMigrations(ctx, logger, dab.conf.Database.StorageConf)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if dab.runtimeConf.Rollback != "" {
+		to, err := ParseSemVer(dab.runtimeConf.Rollback)
+		if err != nil {
+			return err
+		}
+		from := db.WrittenVersion
+		reverted, err := db.Rollback(ctx, conn, to)
+		if err != nil {
+			return fmt.Errorf("error when rolling back the database from version %s to %s: %v", from, to, err)
+		}
+		_, err = fmt.Fprintf(dab.stdOut, "rolled back %d migration(s) from version %s to %s, the previous database was saved at %q\n",
+			len(reverted), from, to, db.MigrationBackupPath(from))
+		return err
+	}
+
+	if dab.runtimeConf.MigrationsDry {
+		applied, err := db.DryRunMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(dab.stdOut, "dry run of %d migration(s) successful, nothing was saved\n", len(applied))
+		return err
+	}
+
+	pending := db.PendingMigrations()
+	limit := db.RollbackLimit().String()
+	if db.RollbackLimit().Equal(db.WrittenVersion) {
+		limit += " (its last migration can't be reverted)"
+	}
+	lines := []string{
+		fmt.Sprintf("database: %s", db.Path),
+		fmt.Sprintf("written by version: %s", db.WrittenVersion),
+		fmt.Sprintf("current version: %s", db.Version),
+		fmt.Sprintf("oldest version it can be rolled back to: %s", limit),
+		fmt.Sprintf("pending migrations: %d", len(pending)),
+	}
+	for _, migration := range pending {
+		lines = append(lines, fmt.Sprintf(" - from %s to %s", migration.From, migration.To))
+	}
+	_, err = fmt.Fprintln(dab.stdOut, strings.Join(lines, "\n"))
+	return err
+}
+
 func (dab *DownArrowsBot) userAdd(ctx context.Context, conn StorageConn) error {
-	ra, err := dab.makeRedditAPI(ctx)
+	apis, err := dab.makeRedditAPIs(ctx)
 	if err != nil {
 		return err
 	}
+	ra := apis[0]
 
 	if err := ra.Connect(ctx); err != nil {
 		return err
 	}
 
-	ru := NewRedditUsers(dab.logger, ra, dab.conf.Reddit.RedditUsersConf)
+	ru := NewRedditUsers(dab.logger, dab.layers.Storage, ra, dab.conf.Reddit.RedditUsersConf)
 
 	usernames := userAddSeparators.Split(dab.runtimeConf.UserAdd, -1)
 	for _, username := range usernames {
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// Settings of the generated data of the demonstration mode.
+const (
+	DemoNbComments = 200
+	DemoNbUsers    = 30
+	DemoSeed       = 0xdab
+)
+
+// Demo holds what is needed to run the application offline with generated data, so that its interface can be tried out.
+// Reddit is replaced by a FakeReddit populated with users, Discord is disabled, and the database is temporary.
+type Demo struct {
+	dir      string
+	listener net.Listener
+	reddit   *FakeReddit
+	users    []string
+}
+
+// NewDemo creates the temporary directory of the database and the listener of the FakeReddit.
+// Call the Close method to release them.
+func NewDemo() (*Demo, error) {
+	dir, err := ioutil.TempDir("", "dab-demo-")
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	reddit := NewFakeReddit("")
+	users, err := reddit.Populate(DemoSeed, DemoNbUsers, DemoNbComments)
+	if err != nil {
+		listener.Close()
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	return &Demo{
+		dir:      dir,
+		listener: listener,
+		reddit:   reddit,
+		users:    users,
+	}, nil
+}
+
+// String implements Stringer.
+func (d *Demo) String() string {
+	return fmt.Sprintf("%d users on a fake Reddit at %s and a temporary database in %s", len(d.users), d.listener.Addr(), d.dir)
+}
+
+// Configure returns the configuration changed to use the demonstration's database and fake Reddit.
+func (d *Demo) Configure(conf Configuration) Configuration {
+	conf.Database.Path = filepath.Join(d.dir, "dab.db")
+	conf.Database.BackupPath = filepath.Join(d.dir, "dab.db.backup")
+
+	conf.Discord.Token = ""
+
+	auth := RedditAuth{ID: "demo", Password: "demo", Secret: "demo", Username: "DemoBot"}
+	conf.Reddit.RedditAuth = auth
+	conf.Reddit.Accounts = []RedditAuth{auth}
+	conf.Compendium.NbRedditAccounts = 1
+	conf.Reddit.ScanSubmissions = true
+	conf.Compendium.ScanSubmissions = true
+	if conf.Reddit.UserAgent == "" {
+		conf.Reddit.UserAgent = "{{.OS}}:dab-demo:v{{.Version}}"
+	}
+	timeout := conf.Reddit.Timeout
+	conf.Reddit.RedditAPIConf = FakeRedditAPIConf(d.listener.Addr())
+	conf.Reddit.Timeout = timeout
+
+	if conf.Web.Listen == "" {
+		conf.Web.Listen = "localhost:3499"
+	}
+
+	return conf
+}
+
+// Serve is a Task that runs the FakeReddit.
+func (d *Demo) Serve(ctx context.Context) error {
+	return d.reddit.Serve(ctx, d.listener)
+}
+
+// AddUsers registers the generated users.
+func (d *Demo) AddUsers(ctx context.Context, conn StorageConn, add AddRedditUser) error {
+	for _, name := range d.users {
+		if res := add(ctx, conn, name, false, false); res.Error != nil {
+			return res.Error
+		}
+	}
+	return nil
+}
+
+// Close closes the listener of the FakeReddit and deletes the temporary database.
+func (d *Demo) Close() error {
+	d.listener.Close()
+	return os.RemoveAll(d.dir)
+}
@@ -38,6 +38,7 @@ const (
 // Knowledge about Discord
 const (
 	DiscordMessageLengthLimit  = 2000
+	DiscordEmbedMaxFields      = 25
 	DiscordDefaultRoleColor    = 0
 	DiscordPrefixWhoRegistered = "register-from-discord_"
 )
@@ -46,6 +47,8 @@ const (
 	discordInvitesDaysOfValidity = 7
 	discordInvitesMaxUses        = 1
 	discordMessageDeletionWait   = 15 * time.Second
+	discordSearchExcerptLength   = 300
+	discordSearchResults         = 5
 	discordStatus                = "Downvote Counter"
 	discordStatusInterval        = 30 * time.Minute
 )
@@ -205,12 +208,12 @@ type DiscordBot struct {
 	sync.Mutex
 
 	// dependencies
-	addUser AddRedditUser
-	client  *discordgo.Session
-	conn    StorageConn // a single one is enough, it's not heavily used
-	kv      *KeyValueStore
-	logger  LevelLogger
-	tasks   *TaskGroup
+	addUser       AddRedditUser
+	client        *discordgo.Session
+	conn          StorageConn // a single one is enough, it's not heavily used
+	logger        LevelLogger
+	tasks         *TaskGroup
+	whoRegistered WhoRegistered
 
 	// state information
 	ID string
@@ -234,7 +237,7 @@ type DiscordBot struct {
 func NewDiscordBot(
 	logger LevelLogger,
 	conn StorageConn,
-	kv *KeyValueStore,
+	whoRegistered WhoRegistered,
 	addUser AddRedditUser,
 	conf DiscordBotConf,
 ) (*DiscordBot, error) {
@@ -261,11 +264,11 @@ func NewDiscordBot(
 	}
 
 	bot := &DiscordBot{
-		addUser: addUser,
-		client:  session,
-		conn:    conn,
-		kv:      kv,
-		logger:  logger,
+		addUser:       addUser,
+		client:        session,
+		conn:          conn,
+		logger:        logger,
+		whoRegistered: whoRegistered,
 
 		channelsID:     conf.DiscordBotChannelsID,
 		hidePrefix:     conf.HidePrefix,
@@ -615,6 +618,20 @@ func (bot *DiscordBot) getCommandsDescriptors() []DiscordCommand {
 		Callback:   bot.editUsers("reregister", bot.conn.UnDelUser),
 		HasArgs:    true,
 		Privileged: true,
+	}, {
+		Command:    "candidates",
+		Callback:   bot.candidates,
+		Privileged: true,
+	}, {
+		Command:    "accept",
+		Callback:   bot.accept,
+		HasArgs:    true,
+		Privileged: true,
+	}, {
+		Command:    "reject",
+		Callback:   bot.editUsers("reject", bot.conn.RejectCandidate),
+		HasArgs:    true,
+		Privileged: true,
 	}, {
 		Command:  "purge",
 		Callback: bot.simpleReply(fmt.Sprintf("Please use the %sunregister command instead (%sreregister to cancel it).", bot.prefix, bot.prefix)),
@@ -623,6 +640,14 @@ func (bot *DiscordBot) getCommandsDescriptors() []DiscordCommand {
 		Command:  "info",
 		Callback: bot.userInfo,
 		HasArgs:  true,
+	}, {
+		Command:  "search",
+		Callback: bot.search,
+		HasArgs:  true,
+	}, {
+		Command:  "search",
+		Callback: bot.simpleError("Type \"%ssearch words\" to search for the comments that contain all of these words.", bot.prefix),
+		HasArgs:  false,
 	}, {
 		Command:  "hide",
 		Callback: bot.editUsers("hide", bot.conn.HideUser),
@@ -714,11 +739,61 @@ func (bot *DiscordBot) register(msg DiscordMessage) error {
 			} else if !reply.Exists {
 				return errors.New("not found")
 			}
-			return bot.kv.Save(bot.conn, DiscordPrefixWhoRegistered+reply.User.Name, msg.Author.ID)
+			return bot.whoRegistered.Save(bot.conn, reply.User.Name, msg.Author.ID)
+		})
+	})(msg)
+}
+
+func (bot *DiscordBot) accept(msg DiscordMessage) error {
+	if bot.addUser == nil {
+		return bot.channelErrorSend(msg.ChannelID, msg.Author.ID, "registration service is unavailable")
+	}
+	return bot.editUsers("accept", func(name string) error {
+		return bot.conn.WithTx(func() error {
+			hidden := strings.HasPrefix(name, bot.hidePrefix)
+			name = TrimUsername(strings.TrimPrefix(name, bot.hidePrefix))
+			reply := AcceptCandidate(bot.tasks.Context, bot.conn, bot.addUser, name, hidden)
+			if reply.Error != nil {
+				return reply.Error
+			} else if !reply.Exists {
+				return errors.New("not found")
+			}
+			return bot.whoRegistered.Save(bot.conn, reply.User.Name, msg.Author.ID)
 		})
 	})(msg)
 }
 
+func (bot *DiscordBot) candidates(msg DiscordMessage) error {
+	bot.conn.Lock()
+	candidates, err := bot.conn.ListCandidates()
+	bot.conn.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if len(candidates) == 0 {
+		return bot.channelMessageSend(msg.ChannelID, "There is no pending candidate for registration.")
+	}
+
+	embed := &DiscordEmbed{
+		Title: "Candidates for registration",
+		Description: fmt.Sprintf("%d pending, use %saccept or %sreject followed by their names to decide.",
+			len(candidates), bot.prefix, bot.prefix),
+	}
+	if len(candidates) > DiscordEmbedMaxFields {
+		embed.Description += fmt.Sprintf(" Only the %d with the lowest scores are shown.", DiscordEmbedMaxFields)
+		candidates = candidates[:DiscordEmbedMaxFields]
+	}
+	for _, candidate := range candidates {
+		embed.AddField(DiscordEmbedField{
+			Name:  "/u/" + escape(candidate.Name),
+			Value: fmt.Sprintf("[%d in /r/%s](https://www.reddit.com%s)", candidate.Score, escape(candidate.Sub), candidate.Permalink),
+		})
+	}
+
+	return bot.channelEmbedSend(msg.ChannelID, embed)
+}
+
 func (bot *DiscordBot) userInfo(msg DiscordMessage) error {
 	username := TrimUsername(msg.Content)
 
@@ -774,15 +849,56 @@ func (bot *DiscordBot) userInfo(msg DiscordMessage) error {
 		})
 	}
 
-	whoKey := DiscordPrefixWhoRegistered + user.Name
-	if from := bot.kv.Get(whoKey); len(from) == 1 {
+	bot.conn.Lock()
+	history, err := bot.conn.KarmaHistory(user.Name)
+	var measured int64
+	if err == nil {
+		measured, _, err = bot.conn.GetKarma(user.Name)
+	}
+	bot.conn.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Show what Reddit says next to what the application measured, to tell apart users who are negative overall
+	// from those who only have a few bad threads.
+	if trend := NewKarmaTrend(history, KarmaTrendPeriod); trend.Exists() {
+		embed.AddField(DiscordEmbedField{
+			Name:   "Reddit karma",
+			Value:  fmt.Sprintf("%d from comments, %d from submissions", trend.Latest.CommentKarma, trend.Latest.LinkKarma),
+			Inline: true,
+		})
+		embed.AddField(DiscordEmbedField{
+			Name: "Reddit karma trend",
+			Value: fmt.Sprintf("%+d from comments, %+d from submissions since %s",
+				trend.CommentChange, trend.LinkChange, trend.Since.In(bot.timezone).Format("02 Jan 06")),
+			Inline: true,
+		})
+		embed.AddField(DiscordEmbedField{
+			Name:   "Measured karma",
+			Value:  fmt.Sprintf("%d from the saved comments", measured),
+			Inline: true,
+		})
+		if status := trend.Latest.Status(); status != "" {
+			embed.AddField(DiscordEmbedField{
+				Name:   "Account",
+				Value:  status,
+				Inline: true,
+			})
+		}
+	}
+
+	bot.conn.Lock()
+	from, known, err := bot.whoRegistered.Get(bot.conn, user.Name)
+	bot.conn.Unlock()
+	if err != nil {
+		return err
+	} else if known {
 		embed.AddField(DiscordEmbedField{
 			Name:   "Registered by",
-			Value:  fmt.Sprintf("<@%s>", from[0]),
+			Value:  fmt.Sprintf("<@%s>", from),
 			Inline: true,
 		})
-	} else if len(from) > 1 {
-		bot.logger.Fatalf("potential bug, only one value should be associated with %q, found %d: %v", whoKey, len(from), from)
 	}
 
 	return bot.channelEmbedSend(msg.ChannelID, embed)
@@ -829,6 +945,50 @@ func (bot *DiscordBot) karma(msg DiscordMessage) error {
 	return bot.channelEmbedSend(msg.ChannelID, embed)
 }
 
+func (bot *DiscordBot) search(msg DiscordMessage) error {
+	search := NewCommentSearch("")
+	var words []string
+	for _, arg := range msg.Args {
+		if filter := strings.SplitN(arg, ":", 2); len(filter) == 2 && SliceHasString(CommentSearchFilters, filter[0]) {
+			if err := search.SetFilter(filter[0], filter[1], bot.timezone); err != nil {
+				return bot.channelErrorSend(msg.ChannelID, msg.Author.ID, err.Error())
+			}
+		} else {
+			words = append(words, arg)
+		}
+	}
+	search.Query = strings.Join(words, " ")
+	if search.MatchExpression() == "" {
+		return bot.channelErrorSend(msg.ChannelID, msg.Author.ID, "A search needs at least one word.")
+	}
+
+	bot.conn.Lock()
+	comments, err := bot.conn.SearchComments(search, Pagination{Limit: discordSearchResults})
+	bot.conn.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if len(comments) == 0 {
+		return bot.channelMessageSend(msg.ChannelID, "No comment found.")
+	}
+
+	embed := &DiscordEmbed{
+		Title: "Search for " + escape(search.Query),
+		Color: bot.myColor(msg.ChannelID),
+	}
+	for i, comment := range comments {
+		embed.AddField(DiscordEmbedField{
+			Name: fmt.Sprintf("#%d /u/%s, %d in /r/%s", i+1, escape(comment.Author), comment.Score, escape(comment.Sub)),
+			Value: fmt.Sprintf("%s\n[%s](https://www.reddit.com%s)",
+				escape(excerpt(comment.Body, discordSearchExcerptLength)),
+				comment.Created.In(bot.timezone).Format("02 Jan 06 15:04 MST"), comment.Permalink),
+		})
+	}
+
+	return bot.channelEmbedSend(msg.ChannelID, embed)
+}
+
 func (bot *DiscordBot) ban(msg DiscordMessage) error {
 	if len(msg.Args) == 0 {
 		if err := bot.channelErrorSend(msg.ChannelID, msg.Author.ID, "A mention of the user to ban is required."); err != nil {
@@ -925,6 +1085,15 @@ func TrimUsername(username string) string {
 	return strings.TrimPrefix(strings.TrimPrefix(username, "/u/"), "u/")
 }
 
+// excerpt returns the beginning of a text on a single line, with at most max characters.
+func excerpt(text string, max int) string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+	if len(runes) <= max {
+		return string(runes)
+	}
+	return string(runes[:max-1]) + "…"
+}
+
 func rolesHaveRoleID(roles []*discordgo.Role, roleID string) bool {
 	for _, role := range roles {
 		if role.ID == roleID {
@@ -2,34 +2,40 @@ package main
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
 
-// KeyValueStore is a string-based key-value store with in-memory reads and on-disk writes that uses SQLite.
+// HighScoresTTL is how long the comments signaled as high scores are remembered,
+// and thus the maximum age of the comments that can be signaled.
+const HighScoresTTL = 30 * 24 * time.Hour
+
+// Namespaces of the key-value store that aren't defined elsewhere.
+const (
+	KeyValueHighScores = "highscores"
+)
+
+// KeyValueStore is a string-based key-value store that uses SQLite, where any number of values can be associated to a key.
+// The keys are grouped into namespaces, which are prefixes of the keys; each namespace is read from the disk
+// the first time it is used and is then kept in memory, so that the table doesn't have to fit in memory.
 type KeyValueStore struct {
-	sync.RWMutex
-	insertQuery string
-	store       map[string]map[string]struct{}
-	table       string
+	sync.Mutex
+	namespaces map[string]*KeyValueNamespace
+	table      string
 }
 
 // NewKeyValueStore creates a new KeyValueStore with the given SQLite database onto the given table, which it assumes has total control of.
 func NewKeyValueStore(conn SQLiteConn, table string) (*KeyValueStore, error) {
 	kv := &KeyValueStore{
-		insertQuery: fmt.Sprintf("INSERT INTO %s(key, value, created) VALUES (?, ?, ?)", table),
-		store:       make(map[string]map[string]struct{}),
-		table:       table,
+		namespaces: make(map[string]*KeyValueNamespace),
+		table:      table,
 	}
 
 	if err := kv.init(conn); err != nil {
 		return nil, err
 	}
 
-	if err := kv.readAll(conn); err != nil {
-		return nil, err
-	}
-
 	return kv, nil
 }
 
@@ -43,8 +49,90 @@ func (kv *KeyValueStore) init(conn SQLiteConn) error {
 		) WITHOUT ROWID`, kv.table))
 }
 
-func (kv *KeyValueStore) readAll(conn SQLiteConn) error {
-	return conn.Select("SELECT key, value FROM "+kv.table, func(stmt *SQLiteStmt) error {
+// Namespace returns the namespace of the keys that start with prefix, whose values expire after ttl,
+// or never if it is zero. Namespaces must not overlap, and a namespace must always be used with the same TTL.
+func (kv *KeyValueStore) Namespace(prefix string, ttl time.Duration) *KeyValueNamespace {
+	kv.Lock()
+	defer kv.Unlock()
+
+	if ns, ok := kv.namespaces[prefix]; ok {
+		if ns.ttl != ttl {
+			panic(fmt.Sprintf("namespace %q of the key-value store used with the TTLs %s and %s", prefix, ns.ttl, ttl))
+		}
+		return ns
+	}
+
+	if prefix == "" {
+		panic("namespaces of the key-value store can't be empty")
+	}
+	for other := range kv.namespaces {
+		if strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix) {
+			panic(fmt.Sprintf("namespace %q of the key-value store overlaps with %q", prefix, other))
+		}
+	}
+
+	ns := &KeyValueNamespace{
+		prefix: prefix,
+		store:  make(map[string]map[string]time.Time),
+		table:  kv.table,
+		ttl:    ttl,
+	}
+	kv.namespaces[prefix] = ns
+	return ns
+}
+
+// Prune deletes the values that have expired from all the namespaces that have been used, even if they haven't been read yet,
+// and returns how many have been deleted.
+func (kv *KeyValueStore) Prune(conn SQLiteConn, now time.Time) (int, error) {
+	kv.Lock()
+	namespaces := make([]*KeyValueNamespace, 0, len(kv.namespaces))
+	for _, ns := range kv.namespaces {
+		namespaces = append(namespaces, ns)
+	}
+	kv.Unlock()
+
+	var nb int
+	for _, ns := range namespaces {
+		pruned, err := ns.prune(conn, now)
+		if err != nil {
+			return nb, err
+		}
+		nb += pruned
+	}
+	return nb, nil
+}
+
+// Reload forgets what has been read from the disk, so that each namespace is read again the next time it is used.
+// Use it after the table has been modified without the KeyValueStore.
+func (kv *KeyValueStore) Reload() {
+	kv.Lock()
+	defer kv.Unlock()
+	for _, ns := range kv.namespaces {
+		ns.Lock()
+		ns.loaded = false
+		ns.store = make(map[string]map[string]time.Time)
+		ns.Unlock()
+	}
+}
+
+// KeyValueNamespace is the part of a KeyValueStore whose keys start with a prefix, and whose values may expire.
+// Its methods take the keys without the prefix.
+type KeyValueNamespace struct {
+	sync.Mutex
+	loaded bool
+	prefix string
+	store  map[string]map[string]time.Time // Date of creation of the values of each key
+	table  string
+	ttl    time.Duration // Zero if the values never expire
+}
+
+func (ns *KeyValueNamespace) load(conn SQLiteConn) error {
+	if ns.loaded {
+		return nil
+	}
+
+	sql := fmt.Sprintf("SELECT key, value, created FROM %s WHERE key >= ? AND key < ?", ns.table)
+	err := conn.Select(sql, func(stmt *SQLiteStmt) error {
 		key, _, err := stmt.ColumnText(0)
 		if err != nil {
 			return err
@@ -55,48 +143,102 @@ func (kv *KeyValueStore) readAll(conn SQLiteConn) error {
 			return err
 		}
 
-		if _, ok := kv.store[key]; !ok {
-			kv.store[key] = make(map[string]struct{})
+		created, _, err := stmt.ColumnInt64(2)
+		if err != nil {
+			return err
 		}
-		kv.store[key][value] = struct{}{}
 
+		ns.set(strings.TrimPrefix(key, ns.prefix), value, time.Unix(created, 0))
 		return nil
-	})
+	}, ns.prefix, ns.end())
+	if err != nil {
+		ns.store = make(map[string]map[string]time.Time)
+		return err
+	}
+
+	ns.loaded = true
+	return nil
 }
 
-// Save saves a value associated to a key.
-// Any number of values can be associated to a key.
-func (kv *KeyValueStore) Save(conn SQLiteConn, key string, value string) error {
-	return kv.SaveMany(conn, key, []string{value})
+// end returns the lowest key that sorts after all the keys of the namespace.
+func (ns *KeyValueNamespace) end() interface{} {
+	end := []byte(ns.prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	// SQLite sorts blobs after all texts.
+	return []byte{}
 }
 
-// SaveMany saves several values associated with a single key.
-// You have to start the transaction yourself.
-func (kv *KeyValueStore) SaveMany(conn SQLiteConn, key string, values []string) error {
-	todo := make(map[string]struct{})
+func (ns *KeyValueNamespace) set(key, value string, created time.Time) {
+	if _, ok := ns.store[key]; !ok {
+		ns.store[key] = make(map[string]time.Time)
+	}
+	ns.store[key][value] = created
+}
 
-	stmt, err := conn.Prepare(kv.insertQuery)
-	if err != nil {
-		return err
+func (ns *KeyValueNamespace) expired(created, now time.Time) bool {
+	return ns.ttl > 0 && now.Sub(created) >= ns.ttl
+}
+
+func (ns *KeyValueNamespace) prune(conn SQLiteConn, now time.Time) (int, error) {
+	if ns.ttl == 0 {
+		return 0, nil
 	}
-	defer stmt.Close()
 
-	kv.RLock()
-	if _, hasKey := kv.store[key]; !hasKey {
-		for _, value := range values {
-			if _, hasValue := kv.store[key][value]; !hasValue {
-				todo[value] = struct{}{}
+	ns.Lock()
+	defer ns.Unlock()
+
+	sql := fmt.Sprintf("DELETE FROM %s WHERE key >= ? AND key < ? AND created <= ?", ns.table)
+	if err := conn.Exec(sql, ns.prefix, ns.end(), now.Add(-ns.ttl).Unix()); err != nil {
+		return 0, err
+	}
+	nb := conn.Changes()
+
+	for key, values := range ns.store {
+		for value, created := range values {
+			if ns.expired(created, now) {
+				delete(values, value)
 			}
 		}
-	} else {
-		for _, value := range values {
-			todo[value] = struct{}{}
+		if len(values) == 0 {
+			delete(ns.store, key)
 		}
 	}
-	kv.RUnlock()
 
-	for value := range todo {
-		if err := stmt.Exec(key, value, time.Now().Unix()); err != nil {
+	return nb, nil
+}
+
+// Save associates a value to a key, along with those it may already have; saving a value again renews it.
+func (ns *KeyValueNamespace) Save(conn SQLiteConn, key string, value string) error {
+	return ns.SaveMany(conn, key, []string{value})
+}
+
+// SaveMany associates several values to a key, along with those it may already have; saving a value again renews it.
+// You have to start the transaction yourself.
+func (ns *KeyValueNamespace) SaveMany(conn SQLiteConn, key string, values []string) error {
+	ns.Lock()
+	defer ns.Unlock()
+
+	if err := ns.load(conn); err != nil {
+		return err
+	}
+
+	sql := fmt.Sprintf(`
+		INSERT INTO %s(key, value, created) VALUES (?, ?, ?)
+		ON CONFLICT(key, value) DO UPDATE SET created = excluded.created`, ns.table)
+	stmt, err := conn.Prepare(sql)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, value := range values {
+		if err := stmt.Exec(ns.prefix+key, value, now.Unix()); err != nil {
 			return err
 		}
 		if err := stmt.ClearBindings(); err != nil {
@@ -104,49 +246,172 @@ func (kv *KeyValueStore) SaveMany(conn SQLiteConn, key string, values []string)
 		}
 	}
 
-	kv.Lock()
-	if _, ok := kv.store[key]; !ok {
-		kv.store[key] = todo
-	} else {
-		for value := range todo {
-			kv.store[key][value] = struct{}{}
+	for _, value := range values {
+		ns.set(key, value, time.Unix(now.Unix(), 0))
+	}
+
+	return nil
+}
+
+// Delete removes a value from a key, and the key itself if it was the only value.
+func (ns *KeyValueNamespace) Delete(conn SQLiteConn, key, value string) error {
+	ns.Lock()
+	defer ns.Unlock()
+
+	if err := ns.load(conn); err != nil {
+		return err
+	}
+
+	sql := fmt.Sprintf("DELETE FROM %s WHERE key = ? AND value = ?", ns.table)
+	if err := conn.Exec(sql, ns.prefix+key, value); err != nil {
+		return err
+	}
+
+	if values, ok := ns.store[key]; ok {
+		delete(values, value)
+		if len(values) == 0 {
+			delete(ns.store, key)
 		}
 	}
-	kv.Unlock()
+	return nil
+}
+
+// DeleteKey removes a key with all its values.
+func (ns *KeyValueNamespace) DeleteKey(conn SQLiteConn, key string) error {
+	ns.Lock()
+	defer ns.Unlock()
+
+	if err := ns.load(conn); err != nil {
+		return err
+	}
+
+	sql := fmt.Sprintf("DELETE FROM %s WHERE key = ?", ns.table)
+	if err := conn.Exec(sql, ns.prefix+key); err != nil {
+		return err
+	}
+
+	delete(ns.store, key)
+	return nil
+}
+
+// RenameKey moves the values of a key to another key, along with those it may already have.
+// You have to start the transaction yourself.
+func (ns *KeyValueNamespace) RenameKey(conn SQLiteConn, from, to string) error {
+	ns.Lock()
+	defer ns.Unlock()
+
+	if err := ns.load(conn); err != nil {
+		return err
+	}
+
+	sql := fmt.Sprintf("UPDATE OR REPLACE %s SET key = ? WHERE key = ?", ns.table)
+	if err := conn.Exec(sql, ns.prefix+to, ns.prefix+from); err != nil {
+		return err
+	}
 
+	values, ok := ns.store[from]
+	if !ok {
+		return nil
+	}
+	delete(ns.store, from)
+	for value, created := range values {
+		ns.set(to, value, created)
+	}
 	return nil
 }
 
 // Has returns whether the given key has the given value.
-func (kv *KeyValueStore) Has(key, value string) bool {
-	kv.RLock()
-	defer kv.RUnlock()
-	if _, ok := kv.store[key]; !ok {
-		return false
+func (ns *KeyValueNamespace) Has(conn SQLiteConn, key, value string) (bool, error) {
+	ns.Lock()
+	defer ns.Unlock()
+
+	if err := ns.load(conn); err != nil {
+		return false, err
 	}
-	_, ok := kv.store[key][value]
-	return ok
+
+	created, ok := ns.store[key][value]
+	return ok && !ns.expired(created, time.Now()), nil
 }
 
 // HasKey returns whether the given key exists.
-func (kv *KeyValueStore) HasKey(key string) bool {
-	kv.RLock()
-	_, ok := kv.store[key]
-	kv.RUnlock()
-	return ok
+func (ns *KeyValueNamespace) HasKey(conn SQLiteConn, key string) (bool, error) {
+	values, err := ns.Get(conn, key)
+	return len(values) > 0, err
 }
 
 // Get returns the list of values associated with the key, which is empty if the key doesn't exist.
-func (kv *KeyValueStore) Get(key string) []string {
-	kv.RLock()
-	defer kv.RUnlock()
+func (ns *KeyValueNamespace) Get(conn SQLiteConn, key string) ([]string, error) {
+	ns.Lock()
+	defer ns.Unlock()
+
+	if err := ns.load(conn); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
 	var values []string
-	if valuesMap, ok := kv.store[key]; ok {
-		for value := range valuesMap {
+	for value, created := range ns.store[key] {
+		if !ns.expired(created, now) {
 			values = append(values, value)
 		}
 	}
-	return values
+	return values, nil
+}
+
+// HighScores remembers the comments that have been signaled as high scores for HighScoresTTL,
+// so that they are signaled only once.
+type HighScores struct {
+	ns *KeyValueNamespace
+}
+
+// NewHighScores returns the HighScores of a KeyValueStore.
+func NewHighScores(kv *KeyValueStore) HighScores {
+	return HighScores{ns: kv.Namespace(KeyValueHighScores, HighScoresTTL)}
+}
+
+// Has tells whether a comment has already been signaled.
+func (hs HighScores) Has(conn SQLiteConn, id string) (bool, error) {
+	return hs.ns.Has(conn, "", id)
+}
+
+// Save remembers that comments have been signaled.
+// You have to start the transaction yourself.
+func (hs HighScores) Save(conn SQLiteConn, ids []string) error {
+	return hs.ns.SaveMany(conn, "", ids)
+}
+
+// WhoRegistered remembers which Discord user registered each Reddit user.
+type WhoRegistered struct {
+	ns *KeyValueNamespace
+}
+
+// NewWhoRegistered returns the WhoRegistered of a KeyValueStore.
+func NewWhoRegistered(kv *KeyValueStore) WhoRegistered {
+	return WhoRegistered{ns: kv.Namespace(DiscordPrefixWhoRegistered, 0)}
+}
+
+// Get returns the ID of the Discord user who registered a Reddit user (case-sensitive), and whether it is known.
+func (wr WhoRegistered) Get(conn SQLiteConn, username string) (string, bool, error) {
+	ids, err := wr.ns.Get(conn, username)
+	if err != nil || len(ids) == 0 {
+		return "", false, err
+	} else if len(ids) > 1 {
+		return "", false, fmt.Errorf("potential bug, only one Discord user should have registered %q, found %d: %v", username, len(ids), ids)
+	}
+	return ids[0], true, nil
+}
+
+// Save remembers which Discord user registered a Reddit user, in place of the one that may have registered it before.
+// You have to start the transaction yourself.
+func (wr WhoRegistered) Save(conn SQLiteConn, username, discordID string) error {
+	if err := wr.ns.DeleteKey(conn, username); err != nil {
+		return err
+	}
+	return wr.ns.Save(conn, username, discordID)
 }
 
-// TODO cleanup values older than T
+// Rename follows the change of the name of a Reddit user (case-sensitive).
+// You have to start the transaction yourself.
+func (wr WhoRegistered) Rename(conn SQLiteConn, from, to string) error {
+	return wr.ns.RenameKey(conn, from, to)
+}
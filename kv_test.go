@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"testing"
+	"time"
 )
 
 func TestKeyValue(t *testing.T) {
@@ -22,56 +23,172 @@ func TestKeyValue(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	ns := kv.Namespace("test-", 0)
+
+	has := func(t *testing.T, ns *KeyValueNamespace, key, value string) bool {
+		t.Helper()
+		ok, err := ns.Has(conn, key, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
 
 	t.Run("write", func(t *testing.T) {
-		if err := kv.Save(conn, "key1", "value1"); err != nil {
+		if err := ns.Save(conn, "key1", "value1"); err != nil {
 			t.Error(err)
 		}
 	})
 
 	t.Run("has key/value", func(t *testing.T) {
-		if !kv.Has("key1", "value1") {
+		if !has(t, ns, "key1", "value1") {
 			t.Error("'key1/value1' should be in the store")
 		}
 	})
 
 	t.Run("unknown value", func(t *testing.T) {
-		if kv.Has("key1", "unknown") {
+		if has(t, ns, "key1", "unknown") {
 			t.Error("'unknown' should not be tested as present for the key 'key1'")
 		}
 	})
 
 	t.Run("unknown key", func(t *testing.T) {
-		if kv.HasKey("unknown") {
-			t.Error("'unknown' key should be present")
+		if ok, err := ns.HasKey(conn, "unknown"); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Error("'unknown' key shouldn't be present")
 		}
 	})
 
 	t.Run("write many", func(t *testing.T) {
-		err := conn.WithTx(func() error { return kv.SaveMany(conn, "key1", []string{"value2", "value3"}) })
+		err := conn.WithTx(func() error { return ns.SaveMany(conn, "key1", []string{"value1", "value2", "value3"}) })
 		if err != nil {
 			t.Error(err)
 			return
 		}
 
-		if !kv.Has("key1", "value2") {
+		if !has(t, ns, "key1", "value2") {
 			t.Error("'key1/value2' should be in the store")
 		}
 
-		if !kv.Has("key1", "value3") {
+		if !has(t, ns, "key1", "value3") {
 			t.Error("'key1/value3' should be in the store")
 		}
 	})
 
-	t.Run("caching on startup", func(t *testing.T) {
+	t.Run("loading on first use", func(t *testing.T) {
+		kv2, err := NewKeyValueStore(conn, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if values, err := kv2.Namespace("test-", 0).Get(conn, "key1"); err != nil {
+			t.Fatal(err)
+		} else if len(values) != 3 {
+			t.Errorf("new key/value store using the same database connection and table name should read 3 values for 'key1', got %v", values)
+		}
+
+		if has(t, kv2.Namespace("other-", 0), "key1", "value1") {
+			t.Error("namespaces should only contain their own keys")
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		if err := ns.Delete(conn, "key1", "value3"); err != nil {
+			t.Fatal(err)
+		}
+		if has(t, ns, "key1", "value3") || !has(t, ns, "key1", "value2") {
+			t.Error("only 'key1/value3' should have been deleted")
+		}
+
+		if err := ns.Save(conn, "key2", "value1"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ns.DeleteKey(conn, "key2"); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := ns.HasKey(conn, "key2"); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Error("'key2' should have been deleted")
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		if err := ns.RenameKey(conn, "key1", "key3"); err != nil {
+			t.Fatal(err)
+		}
+		if has(t, ns, "key1", "value1") || !has(t, ns, "key3", "value1") {
+			t.Error("the values of 'key1' should have been moved to 'key3'")
+		}
+
+		kv2, err := NewKeyValueStore(conn, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if values, err := kv2.Namespace("test-", 0).Get(conn, "key3"); err != nil {
+			t.Fatal(err)
+		} else if len(values) != 2 {
+			t.Errorf("the renaming should have been saved, got %v", values)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		expiring := kv.Namespace("expiring-", time.Hour)
+		if err := expiring.SaveMany(conn, "key1", []string{"old", "new"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.Exec(`UPDATE test SET created = ? WHERE key = "expiring-key1" AND value = "old"`, time.Now().Add(-2*time.Hour).Unix()); err != nil {
+			t.Fatal(err)
+		}
+
 		kv2, err := NewKeyValueStore(conn, "test")
 		if err != nil {
 			t.Fatal(err)
 		}
+		expiring = kv2.Namespace("expiring-", time.Hour)
+		if has(t, expiring, "key1", "old") || !has(t, expiring, "key1", "new") {
+			t.Error("only 'key1/old' should have expired")
+		}
 
-		if !kv2.Has("key1", "value1") {
-			t.Error("new key/value store using the same database connection and table name should cache 'key1/value1'")
+		nb, err := kv2.Prune(conn, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		} else if nb != 1 {
+			t.Errorf("only 'key1/old' should have been pruned, got %d values", nb)
+		}
+		nb, err = kv2.Prune(conn, time.Now().Add(2*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		} else if nb != 1 {
+			t.Errorf("'key1/new' should have been pruned, got %d values", nb)
+		}
+		if !has(t, kv2.Namespace("test-", 0), "key3", "value1") {
+			t.Error("values of namespaces without TTL should never be pruned")
 		}
 	})
 
+	t.Run("overlapping namespaces", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("overlapping namespaces should be refused")
+			}
+		}()
+		kv.Namespace("test-overlap", 0)
+	})
+
+	t.Run("who registered", func(t *testing.T) {
+		whoRegistered := NewWhoRegistered(kv)
+		if err := whoRegistered.Save(conn, "AGreatUsername", "1234"); err != nil {
+			t.Fatal(err)
+		}
+		if err := whoRegistered.Save(conn, "AGreatUsername", "5678"); err != nil {
+			t.Fatal(err)
+		}
+		if id, known, err := whoRegistered.Get(conn, "AGreatUsername"); err != nil {
+			t.Fatal(err)
+		} else if !known || id != "5678" {
+			t.Errorf("the last Discord user to register the user should be remembered, got %q", id)
+		}
+	})
 }
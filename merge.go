@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MergeSchema is the name under which the merged database is attached.
+const MergeSchema = "merged"
+
+// DefaultMergeRules are the rules used to merge databases, unless they are overridden.
+var DefaultMergeRules = MergeRules{Hidden: "ours", Removals: "any", Scores: "newest"}
+
+var mergeRulesChoices = map[string][]string{
+	"hidden":   {"ours", "theirs", "any"},
+	"removals": {"ours", "any"},
+	"scores":   {"newest", "ours", "theirs", "lowest"},
+}
+
+var errMergeDryRun = errors.New("dry run of the merge")
+
+// MergeRules describes how the conflicts between the data of the database and of the one merged into it are resolved.
+// "ours" always keeps the data of the database, and "theirs" the data of the merged one.
+type MergeRules struct {
+	Hidden   string // Whether users are hidden: "ours", "theirs", or "any" to hide them if they are in either database
+	Removals string // Removals of comments: "ours", or "any" to add the removals only known by the merged database
+	Scores   string // Scores of comments: "newest" observation, "ours", "theirs", or "lowest"
+}
+
+// ParseMergeRules overrides the default rules with a comma-separated list of rules in the format "name=choice".
+func ParseMergeRules(raw string) (MergeRules, error) {
+	rules := DefaultMergeRules
+	for _, rule := range strings.Split(raw, ",") {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(rule, "=", 2)
+		if len(parts) != 2 {
+			return rules, fmt.Errorf("invalid merge rule %q, it must be in the format \"name=choice\"", rule)
+		}
+		name, choice := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+		choices, ok := mergeRulesChoices[name]
+		if !ok {
+			return rules, fmt.Errorf("unknown merge rule %q, it must be \"hidden\", \"removals\", or \"scores\"", name)
+		}
+		valid := false
+		for _, candidate := range choices {
+			valid = valid || candidate == choice
+		}
+		if !valid {
+			return rules, fmt.Errorf("invalid choice %q for the merge rule %q, it must be one of %s", choice, name, strings.Join(choices, ", "))
+		}
+
+		switch name {
+		case "hidden":
+			rules.Hidden = choice
+		case "removals":
+			rules.Removals = choice
+		case "scores":
+			rules.Scores = choice
+		}
+	}
+	return rules, nil
+}
+
+// String implements Stringer.
+func (rules MergeRules) String() string {
+	return fmt.Sprintf("hidden=%s,removals=%s,scores=%s", rules.Hidden, rules.Removals, rules.Scores)
+}
+
+// MergeStats describes what a merge changed.
+type MergeStats struct {
+	UsersAdded       int
+	UsersUpdated     int
+	CommentsAdded    int
+	ScoresUpdated    int
+	RemovalsAdded    int
+	ScoresAdded      int // Samples of the history of the scores of comments
+	RevisionsAdded   int
+	SubmissionsAdded int
+	KarmaAdded       int // Samples of the karma of users
+	KeyValuesAdded   int
+}
+
+// String implements Stringer.
+func (ms MergeStats) String() string {
+	return fmt.Sprintf("%d users added, %d users updated, %d comments added, %d scores of comments updated, "+
+		"%d removals of comments added, %d samples of scores added, %d revisions of comments added, %d submissions added, "+
+		"%d samples of karma added, %d key/value pairs added",
+		ms.UsersAdded, ms.UsersUpdated, ms.CommentsAdded, ms.ScoresUpdated,
+		ms.RemovalsAdded, ms.ScoresAdded, ms.RevisionsAdded, ms.SubmissionsAdded,
+		ms.KarmaAdded, ms.KeyValuesAdded)
+}
+
+// Merge adds to the database the data of another database of the application of the same version,
+// resolving the conflicts with the given rules, in a single transaction that is rolled back if it is a dry run.
+// Users are matched case-insensitively, and keep their name as it is in the database.
+// The state of the scans of known users, the candidates for registration, and the state of the passes of the scanner are kept.
+// Key/value pairs are added, except for keys that already have a single value in the database.
+func (s *Storage) Merge(ctx context.Context, conn StorageConn, path string, rules MergeRules, dryRun bool) (MergeStats, error) {
+	var stats MergeStats
+
+	if err := checkMergedPath(conn, path); err != nil {
+		return stats, err
+	}
+
+	if err := conn.Exec("ATTACH DATABASE ? AS "+MergeSchema, path); err != nil {
+		return stats, err
+	}
+	defer conn.Exec("DETACH DATABASE " + MergeSchema)
+
+	if err := checkMergedDatabase(conn, path); err != nil {
+		return stats, err
+	}
+
+	steps := []struct {
+		count *int
+		sql   string
+	}{
+		{&stats.UsersAdded, `
+			INSERT INTO user_archive SELECT * FROM merged.user_archive AS o
+			WHERE NOT EXISTS (SELECT 1 FROM user_archive WHERE name = o.name COLLATE NOCASE)`},
+		{&stats.UsersUpdated, mergeHiddenQueries[rules.Hidden]},
+		{&stats.CommentsAdded, `
+			INSERT INTO comments (id, author, score, permalink, sub, created, body, removed_by, removed)
+			SELECT o.id, (SELECT name FROM user_archive WHERE name = o.author COLLATE NOCASE),
+				o.score, o.permalink, o.sub, o.created, o.body, o.removed_by, o.removed
+			FROM merged.comments AS o
+			WHERE NOT EXISTS (SELECT 1 FROM comments WHERE id = o.id)`},
+		// Must happen before the history of the scores is merged, as it is compared between both databases.
+		{&stats.ScoresUpdated, mergeScoresQueries[rules.Scores]},
+		{&stats.RemovalsAdded, mergeRemovalsQueries[rules.Removals]},
+		{&stats.ScoresAdded, "INSERT OR IGNORE INTO comment_scores SELECT * FROM merged.comment_scores"},
+		{&stats.RevisionsAdded, "INSERT OR IGNORE INTO comment_revisions SELECT * FROM merged.comment_revisions"},
+		{&stats.SubmissionsAdded, `
+			INSERT INTO submissions (id, author, score, permalink, sub, created, body, title, url)
+			SELECT o.id, (SELECT name FROM user_archive WHERE name = o.author COLLATE NOCASE),
+				o.score, o.permalink, o.sub, o.created, o.body, o.title, o.url
+			FROM merged.submissions AS o
+			WHERE NOT EXISTS (SELECT 1 FROM submissions WHERE id = o.id)`},
+		{&stats.KarmaAdded, `
+			INSERT OR IGNORE INTO user_karma_history
+				(name, link_karma, comment_karma, is_employee, is_mod, verified, observed)
+			SELECT (SELECT name FROM user_archive WHERE name = o.name COLLATE NOCASE),
+				o.link_karma, o.comment_karma, o.is_employee, o.is_mod, o.verified, o.observed
+			FROM merged.user_karma_history AS o`},
+		{nil, fmt.Sprintf(`
+			CREATE TEMPORARY TABLE merge_single_keys AS
+			SELECT key FROM %s GROUP BY key HAVING COUNT(*) = 1`, s.kv.table)},
+		{&stats.KeyValuesAdded, fmt.Sprintf(`
+			INSERT OR IGNORE INTO %[1]s SELECT * FROM merged.%[1]s
+			WHERE key NOT IN (SELECT key FROM temp.merge_single_keys)`, s.kv.table)},
+		{nil, "DROP TABLE temp.merge_single_keys"},
+	}
+
+	err := conn.WithTx(func() error {
+		for _, step := range steps {
+			if err := ctx.Err(); err != nil {
+				return err
+			} else if step.sql == "" {
+				continue
+			} else if err := conn.Exec(step.sql); err != nil {
+				return err
+			}
+			if step.count != nil {
+				*step.count = conn.Changes()
+			}
+		}
+		if dryRun {
+			return errMergeDryRun
+		}
+		return nil
+	})
+	if err == errMergeDryRun {
+		return stats, nil
+	} else if err != nil {
+		return stats, err
+	}
+	s.kv.Reload()
+	return stats, nil
+}
+
+// The rules that keep the data of the database have no query.
+var (
+	mergeHiddenQueries = map[string]string{
+		"theirs": `
+			UPDATE user_archive SET hidden = NOT hidden
+			WHERE EXISTS (SELECT 1 FROM merged.user_archive AS o
+				WHERE o.name = user_archive.name COLLATE NOCASE AND o.hidden != user_archive.hidden)`,
+		"any": `
+			UPDATE user_archive SET hidden = TRUE
+			WHERE hidden IS FALSE AND EXISTS (SELECT 1 FROM merged.user_archive AS o
+				WHERE o.name = user_archive.name COLLATE NOCASE AND o.hidden IS TRUE)`,
+	}
+
+	mergeScoresQueries = map[string]string{
+		"newest": `
+			UPDATE comments SET score = (SELECT score FROM merged.comments AS o WHERE o.id = comments.id)
+			WHERE EXISTS (SELECT 1 FROM merged.comments AS o WHERE o.id = comments.id AND o.score != comments.score
+				AND (SELECT MAX(observed) FROM merged.comment_scores WHERE id = o.id) >
+					COALESCE((SELECT MAX(observed) FROM comment_scores WHERE id = comments.id), 0))`,
+		"theirs": `
+			UPDATE comments SET score = (SELECT score FROM merged.comments AS o WHERE o.id = comments.id)
+			WHERE EXISTS (SELECT 1 FROM merged.comments AS o WHERE o.id = comments.id AND o.score != comments.score)`,
+		"lowest": `
+			UPDATE comments SET score = (SELECT score FROM merged.comments AS o WHERE o.id = comments.id)
+			WHERE EXISTS (SELECT 1 FROM merged.comments AS o WHERE o.id = comments.id AND o.score < comments.score)`,
+	}
+
+	mergeRemovalsQueries = map[string]string{
+		"any": `
+			UPDATE comments SET (removed_by, removed) = (SELECT removed_by, removed FROM merged.comments AS o WHERE o.id = comments.id)
+			WHERE removed_by = "" AND EXISTS (SELECT 1 FROM merged.comments AS o WHERE o.id = comments.id AND o.removed_by != "")`,
+	}
+)
+
+func checkMergedPath(conn StorageConn, path string) error {
+	stat, err := os.Stat(path)
+	if err != nil {
+		// Attaching a database that doesn't exist would create it.
+		return err
+	} else if stat.IsDir() {
+		return fmt.Errorf("cannot merge %q, it is a directory", path)
+	}
+	if ownStat, err := os.Stat(conn.Path()); err == nil && os.SameFile(stat, ownStat) {
+		return fmt.Errorf("cannot merge %q into itself", path)
+	}
+	return nil
+}
+
+// checkMergedDatabase checks the application ID, the version, and the integrity of the attached database,
+// like SQLiteDatabase does when it opens a database.
+func checkMergedDatabase(conn StorageConn, path string) error {
+	var appID, intVersion int
+	err := conn.Select("PRAGMA "+MergeSchema+".application_id", func(stmt *SQLiteStmt) error {
+		var err error
+		appID, _, err = stmt.ColumnInt(0)
+		return err
+	})
+	if err != nil {
+		return err
+	} else if appID != ApplicationFileID {
+		return fmt.Errorf("database %q is from another application: found application ID 0x%x instead of 0x%x", path, appID, ApplicationFileID)
+	}
+
+	err = conn.Select("PRAGMA "+MergeSchema+".user_version", func(stmt *SQLiteStmt) error {
+		var err error
+		intVersion, _, err = stmt.ColumnInt(0)
+		return err
+	})
+	if err != nil {
+		return err
+	} else if version := SemVerFromInt(intVersion); !version.Equal(Version) {
+		return fmt.Errorf("database %q was last written by version %s instead of the current version %s; "+
+			"run the current version once on it to migrate it", path, version, Version)
+	}
+
+	var errs []string
+	err = conn.Select("PRAGMA "+MergeSchema+".quick_check", func(stmt *SQLiteStmt) error {
+		if msg, _, err := stmt.ColumnText(0); err != nil {
+			return err
+		} else if msg != "ok" {
+			errs = append(errs, msg)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	} else if len(errs) > 0 {
+		return fmt.Errorf("integrity check error(s) in database %q: %v", path, errs)
+	}
+	return nil
+}
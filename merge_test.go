@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+	mergedPath := filepath.Join(dir, "merged.db")
+	now := time.Now().Round(time.Second)
+
+	comment := func(id, author string, score int64) []interface{} {
+		return []interface{}{id, author, score, "/r/test/comments/a/_/" + id, "test", now.Add(-time.Hour).Unix(), "text"}
+	}
+	populate := func(conn StorageConn, queries []SQLQuery) {
+		t.Helper()
+		for _, query := range queries {
+			if err := conn.Exec(query.SQL, query.Args...); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	insertComment := "INSERT INTO comments (id, author, score, permalink, sub, created, body) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	insertScore := "INSERT INTO comment_scores VALUES (?, ?, ?)"
+
+	merged, mergedConn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: mergedPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mergedConn.AddUser("alice", true, now); err != nil {
+		t.Fatal(err)
+	} else if err := mergedConn.AddUser("Carol", false, now); err != nil {
+		t.Fatal(err)
+	}
+	populate(mergedConn, []SQLQuery{
+		{SQL: insertComment, Args: comment("newer", "alice", -50)},
+		{SQL: insertScore, Args: []interface{}{"newer", -50, now.Unix()}},
+		{SQL: insertComment, Args: comment("older", "alice", -50)},
+		{SQL: insertScore, Args: []interface{}{"older", -50, now.Add(-time.Hour).Unix()}},
+		{SQL: insertComment, Args: comment("carol", "Carol", -5)},
+		{SQL: `UPDATE comments SET removed_by = "moderator", removed = ? WHERE id = "older"`, Args: []interface{}{now.Unix()}},
+	})
+	if err := merged.WhoRegistered().Save(mergedConn, "Alice", "5678"); err != nil {
+		t.Fatal(err)
+	} else if err := merged.HighScores().Save(mergedConn, []string{"newer", "older"}); err != nil {
+		t.Fatal(err)
+	}
+	mergedConn.Close()
+
+	newStorage := func(t *testing.T) (*Storage, StorageConn) {
+		t.Helper()
+		storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: filepath.Join(t.TempDir(), "dab.db")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := conn.AddUser("Alice", false, now); err != nil {
+			t.Fatal(err)
+		}
+		populate(conn, []SQLQuery{
+			{SQL: insertComment, Args: comment("newer", "Alice", -10)},
+			{SQL: insertScore, Args: []interface{}{"newer", -10, now.Add(-30 * time.Minute).Unix()}},
+			{SQL: insertComment, Args: comment("older", "Alice", -10)},
+			{SQL: insertScore, Args: []interface{}{"older", -10, now.Add(-30 * time.Minute).Unix()}},
+		})
+		if err := storage.WhoRegistered().Save(conn, "Alice", "1234"); err != nil {
+			t.Fatal(err)
+		} else if err := storage.HighScores().Save(conn, []string{"newer", "other"}); err != nil {
+			t.Fatal(err)
+		}
+		return storage, conn
+	}
+
+	getComment := func(t *testing.T, conn StorageConn, id string) Comment {
+		t.Helper()
+		comment, exists, err := conn.GetComment(id)
+		if err != nil {
+			t.Fatal(err)
+		} else if !exists {
+			t.Fatalf("comment %q should exist", id)
+		}
+		return comment
+	}
+
+	t.Run("default rules", func(t *testing.T) {
+		storage, conn := newStorage(t)
+		defer conn.Close()
+
+		stats, err := storage.Merge(ctx, conn, mergedPath, DefaultMergeRules, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := MergeStats{UsersAdded: 1, CommentsAdded: 1, ScoresUpdated: 1, RemovalsAdded: 1, ScoresAdded: 2, KeyValuesAdded: 1}
+		if stats != expected {
+			t.Errorf("expected %s, got %s", expected, stats)
+		}
+
+		if query := conn.GetUser("Alice"); query.Error != nil {
+			t.Fatal(query.Error)
+		} else if query.User.Name != "Alice" || query.User.Hidden {
+			t.Errorf("the user should keep its name and not be hidden, got %+v", query.User)
+		}
+		if carol := getComment(t, conn, "carol"); carol.Author != "Carol" {
+			t.Errorf("the comment of the new user should have been added, got %+v", carol)
+		}
+		if newer := getComment(t, conn, "newer"); newer.Score != -50 || newer.Author != "Alice" {
+			t.Errorf("the most recently observed score should be kept, got %+v", newer)
+		}
+		if older := getComment(t, conn, "older"); older.Score != -10 || older.RemovedBy != RemovedByModerator {
+			t.Errorf("the score should be kept and the removal added, got %+v", older)
+		}
+
+		if id, _, err := storage.WhoRegistered().Get(conn, "Alice"); err != nil {
+			t.Fatal(err)
+		} else if id != "1234" {
+			t.Errorf("keys with a single value should be kept, got %q", id)
+		}
+		if known, err := storage.HighScores().Has(conn, "older"); err != nil {
+			t.Fatal(err)
+		} else if !known {
+			t.Error("the values of keys with several values should be added")
+		}
+	})
+
+	t.Run("other rules", func(t *testing.T) {
+		storage, conn := newStorage(t)
+		defer conn.Close()
+
+		rules, err := ParseMergeRules("hidden=any, removals=ours,scores=lowest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats, err := storage.Merge(ctx, conn, mergedPath, rules, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.UsersUpdated != 1 || stats.ScoresUpdated != 2 || stats.RemovalsAdded != 0 {
+			t.Errorf("unexpected statistics %s", stats)
+		}
+		if query := conn.GetUser("Alice"); query.Error != nil {
+			t.Fatal(query.Error)
+		} else if !query.User.Hidden {
+			t.Error("the user should be hidden")
+		}
+		if older := getComment(t, conn, "older"); older.Score != -50 || older.RemovedBy != "" {
+			t.Errorf("the lowest score should be kept without the removal, got %+v", older)
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		storage, conn := newStorage(t)
+		defer conn.Close()
+
+		stats, err := storage.Merge(ctx, conn, mergedPath, DefaultMergeRules, true)
+		if err != nil {
+			t.Fatal(err)
+		} else if stats.CommentsAdded != 1 {
+			t.Errorf("the statistics should be computed, got %s", stats)
+		}
+		if _, exists, err := conn.GetComment("carol"); err != nil {
+			t.Fatal(err)
+		} else if exists {
+			t.Error("nothing should be saved during a dry run")
+		}
+
+		if _, err := storage.Merge(ctx, conn, mergedPath, DefaultMergeRules, false); err != nil {
+			t.Errorf("the database should be mergeable after a dry run: %v", err)
+		}
+	})
+
+	t.Run("checks", func(t *testing.T) {
+		storage, conn := newStorage(t)
+		defer conn.Close()
+
+		if _, err := storage.Merge(ctx, conn, filepath.Join(dir, "missing.db"), DefaultMergeRules, false); err == nil {
+			t.Error("merging a database that doesn't exist should fail")
+		}
+		if _, err := storage.Merge(ctx, conn, conn.Path(), DefaultMergeRules, false); err == nil {
+			t.Error("merging a database into itself should fail")
+		}
+
+		other := filepath.Join(dir, "other.db")
+		_, otherConn, err := NewSQLiteDatabase(ctx, NewTestLevelLogger(t), SQLiteDatabaseOptions{Path: other, AppID: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		otherConn.Close()
+		if _, err := storage.Merge(ctx, conn, other, DefaultMergeRules, false); err == nil {
+			t.Error("merging a database from another application should fail")
+		}
+
+		if _, err := ParseMergeRules("scores=highest"); err == nil {
+			t.Error("invalid choices of rules should be refused")
+		}
+	})
+}
@@ -7,6 +7,9 @@ import (
 // StorageMigrations defines the migrations for the whole application.
 // Keep the migrations sorted from the lowest to highest version, the migration logic doesn't check.
 // The connection given to the migrations is always the same one, and hasn't started a transaction.
+// Down reverts a migration, so that the database can be used by the previous version; like Exec,
+// it only has to drop the views, indexes, and triggers that are in the way, as that version creates them again.
+// Several migrations can be applied or reverted in a row without them being created again in between, hence "IF EXISTS".
 var StorageMigrations = []SQLiteMigration{
 	{
 		From: SemVer{1, 10, 1},
@@ -110,5 +113,199 @@ var StorageMigrations = []SQLiteMigration{
 				)
 				DELETE FROM key_value WHERE (key_value.key, key_value.created) IN todo`)
 		},
+	}, {
+		From: SemVer{1, 26, 4},
+		To:   SemVer{1, 27, 0},
+		Exec: func(conn SQLiteConn) error {
+			// The view, the index, and the trigger are created again with the other tables.
+			return conn.MultiExecWithTx([]SQLQuery{
+				{SQL: "DROP VIEW IF EXISTS users"},
+				{SQL: "DROP INDEX IF EXISTS user_archive_idx"},
+				{SQL: "DROP TRIGGER IF EXISTS purge_user"},
+				{SQL: "ALTER TABLE user_archive ADD COLUMN submissions_batch_size INTEGER DEFAULT " + strconv.Itoa(MaxRedditListingLength) + " NOT NULL"},
+				{SQL: "ALTER TABLE user_archive ADD COLUMN submissions_new BOOLEAN DEFAULT TRUE NOT NULL"},
+				{SQL: `ALTER TABLE user_archive ADD COLUMN submissions_position TEXT DEFAULT "" NOT NULL`},
+			})
+		},
+		Down: func(conn SQLiteConn) error {
+			// The submissions are kept, the previous version ignores them.
+			// The version of SQLite doesn't support dropping columns, so the table is rebuilt without them.
+			if err := conn.Exec("PRAGMA foreign_keys = OFF"); err != nil {
+				return err
+			}
+			return conn.MultiExecWithTx([]SQLQuery{
+				{SQL: "DROP VIEW IF EXISTS users"},
+				{SQL: "DROP INDEX IF EXISTS user_archive_idx"},
+				{SQL: "DROP TRIGGER IF EXISTS purge_user"},
+				{SQL: `CREATE TABLE new_user_archive (
+					name TEXT PRIMARY KEY,
+					created INTEGER NOT NULL,
+					not_found BOOLEAN DEFAULT FALSE NOT NULL,
+					suspended BOOLEAN DEFAULT FALSE NOT NULL,
+					added INTEGER NOT NULL,
+					batch_size INTEGER DEFAULT ` + strconv.Itoa(MaxRedditListingLength) + ` NOT NULL,
+					deleted BOOLEAN DEFAULT FALSE NOT NULL,
+					hidden BOOLEAN NOT NULL,
+					inactive BOOLEAN DEFAULT FALSE NOT NULL,
+					last_scan INTEGER DEFAULT FALSE NOT NULL,
+					new BOOLEAN DEFAULT TRUE NOT NULL,
+					position TEXT DEFAULT "" NOT NULL
+				) WITHOUT ROWID`},
+				{SQL: `INSERT INTO new_user_archive SELECT
+					name, created, not_found, suspended, added, batch_size, deleted, hidden, inactive, last_scan, new, position
+				FROM user_archive`},
+				{SQL: "DROP TABLE user_archive"},
+				{SQL: "ALTER TABLE new_user_archive RENAME TO user_archive"},
+				{SQL: "PRAGMA foreign_keys = ON"},
+			})
+		},
+	}, {
+		From: SemVer{1, 27, 0},
+		To:   SemVer{1, 28, 0},
+		Exec: func(conn SQLiteConn) error {
+			return conn.MultiExecWithTx([]SQLQuery{
+				{SQL: "DROP VIEW IF EXISTS users"},
+				{SQL: "DROP INDEX IF EXISTS user_archive_idx"},
+				{SQL: `ALTER TABLE user_archive ADD COLUMN backfill_sort TEXT DEFAULT "" NOT NULL`},
+				{SQL: `ALTER TABLE user_archive ADD COLUMN backfill_position TEXT DEFAULT "" NOT NULL`},
+			})
+		},
+		Down: func(conn SQLiteConn) error {
+			// The version of SQLite doesn't support dropping columns, so the table is rebuilt without them.
+			if err := conn.Exec("PRAGMA foreign_keys = OFF"); err != nil {
+				return err
+			}
+			return conn.MultiExecWithTx([]SQLQuery{
+				{SQL: "DROP VIEW IF EXISTS users"},
+				{SQL: "DROP INDEX IF EXISTS user_archive_idx"},
+				{SQL: `CREATE TABLE new_user_archive (
+					name TEXT PRIMARY KEY,
+					created INTEGER NOT NULL,
+					not_found BOOLEAN DEFAULT FALSE NOT NULL,
+					suspended BOOLEAN DEFAULT FALSE NOT NULL,
+					added INTEGER NOT NULL,
+					batch_size INTEGER DEFAULT ` + strconv.Itoa(MaxRedditListingLength) + ` NOT NULL,
+					deleted BOOLEAN DEFAULT FALSE NOT NULL,
+					hidden BOOLEAN NOT NULL,
+					inactive BOOLEAN DEFAULT FALSE NOT NULL,
+					last_scan INTEGER DEFAULT FALSE NOT NULL,
+					new BOOLEAN DEFAULT TRUE NOT NULL,
+					position TEXT DEFAULT "" NOT NULL,
+					submissions_batch_size INTEGER DEFAULT ` + strconv.Itoa(MaxRedditListingLength) + ` NOT NULL,
+					submissions_new BOOLEAN DEFAULT TRUE NOT NULL,
+					submissions_position TEXT DEFAULT "" NOT NULL
+				) WITHOUT ROWID`},
+				{SQL: `INSERT INTO new_user_archive SELECT
+					name, created, not_found, suspended, added, batch_size, deleted, hidden, inactive, last_scan, new, position,
+					submissions_batch_size, submissions_new, submissions_position
+				FROM user_archive`},
+				{SQL: "DROP TABLE user_archive"},
+				{SQL: "ALTER TABLE new_user_archive RENAME TO user_archive"},
+				{SQL: "PRAGMA foreign_keys = ON"},
+			})
+		},
+	}, {
+		From: SemVer{1, 28, 0},
+		To:   SemVer{1, 29, 0},
+		Exec: func(conn SQLiteConn) error {
+			return conn.MultiExecWithTx([]SQLQuery{
+				{SQL: `ALTER TABLE comments ADD COLUMN removed_by TEXT DEFAULT "" NOT NULL`},
+				{SQL: "ALTER TABLE comments ADD COLUMN removed INTEGER DEFAULT 0 NOT NULL"},
+			})
+		},
+		Down: func(conn SQLiteConn) error {
+			// The version of SQLite doesn't support dropping columns, so the table is rebuilt without them.
+			if err := conn.Exec("PRAGMA foreign_keys = OFF"); err != nil {
+				return err
+			}
+			return conn.MultiExecWithTx([]SQLQuery{
+				{SQL: "DROP INDEX IF EXISTS comments_idx"},
+				{SQL: "DROP INDEX IF EXISTS comments_removals_idx"},
+				{SQL: "DROP TRIGGER IF EXISTS purge_user"},
+				{SQL: `CREATE TABLE new_comments (
+					id TEXT PRIMARY KEY,
+					author TEXT NOT NULL,
+					score INTEGER NOT NULL,
+					permalink TEXT NOT NULL,
+					sub TEXT NOT NULL,
+					created INTEGER NOT NULL,
+					body TEXT NOT NULL,
+					FOREIGN KEY (author) REFERENCES user_archive(name)
+				) WITHOUT ROWID`},
+				{SQL: "INSERT INTO new_comments SELECT id, author, score, permalink, sub, created, body FROM comments"},
+				{SQL: "DROP TABLE comments"},
+				{SQL: "ALTER TABLE new_comments RENAME TO comments"},
+				{SQL: "PRAGMA foreign_keys = ON"},
+			})
+		},
+	}, {
+		From: SemVer{1, 29, 0},
+		To:   SemVer{1, 30, 0},
+		Exec: func(conn SQLiteConn) error {
+			// Every user is due for a scan right after the migration, which then schedules the next one.
+			return conn.MultiExecWithTx([]SQLQuery{
+				{SQL: "DROP VIEW IF EXISTS users"},
+				{SQL: "DROP INDEX IF EXISTS user_archive_idx"},
+				{SQL: "ALTER TABLE user_archive ADD COLUMN next_scan INTEGER DEFAULT 0 NOT NULL"},
+			})
+		},
+		Down: func(conn SQLiteConn) error {
+			// The version of SQLite doesn't support dropping columns, so the table is rebuilt without it.
+			if err := conn.Exec("PRAGMA foreign_keys = OFF"); err != nil {
+				return err
+			}
+			return conn.MultiExecWithTx([]SQLQuery{
+				{SQL: "DROP VIEW IF EXISTS users"},
+				{SQL: "DROP INDEX IF EXISTS user_archive_idx"},
+				{SQL: `CREATE TABLE new_user_archive (
+					name TEXT PRIMARY KEY,
+					created INTEGER NOT NULL,
+					not_found BOOLEAN DEFAULT FALSE NOT NULL,
+					suspended BOOLEAN DEFAULT FALSE NOT NULL,
+					added INTEGER NOT NULL,
+					batch_size INTEGER DEFAULT ` + strconv.Itoa(MaxRedditListingLength) + ` NOT NULL,
+					deleted BOOLEAN DEFAULT FALSE NOT NULL,
+					hidden BOOLEAN NOT NULL,
+					inactive BOOLEAN DEFAULT FALSE NOT NULL,
+					last_scan INTEGER DEFAULT FALSE NOT NULL,
+					new BOOLEAN DEFAULT TRUE NOT NULL,
+					position TEXT DEFAULT "" NOT NULL,
+					submissions_batch_size INTEGER DEFAULT ` + strconv.Itoa(MaxRedditListingLength) + ` NOT NULL,
+					submissions_new BOOLEAN DEFAULT TRUE NOT NULL,
+					submissions_position TEXT DEFAULT "" NOT NULL,
+					backfill_sort TEXT DEFAULT "" NOT NULL,
+					backfill_position TEXT DEFAULT "" NOT NULL
+				) WITHOUT ROWID`},
+				{SQL: `INSERT INTO new_user_archive SELECT
+					name, created, not_found, suspended, added, batch_size, deleted, hidden, inactive, last_scan, new, position,
+					submissions_batch_size, submissions_new, submissions_position, backfill_sort, backfill_position
+				FROM user_archive`},
+				{SQL: "DROP TABLE user_archive"},
+				{SQL: "ALTER TABLE new_user_archive RENAME TO user_archive"},
+				{SQL: "PRAGMA foreign_keys = ON"},
+			})
+		},
+	}, {
+		From: SemVer{1, 30, 0},
+		To:   SemVer{1, 31, 0},
+		Exec: func(conn SQLiteConn) error {
+			// The triggers keep the index up to date from now on, so only the comments already saved have to be indexed.
+			queries := Comment{}.searchInitializationQueries()
+			queries = append(queries,
+				SQLQuery{SQL: "INSERT INTO comment_search_ids (id) SELECT id FROM comments"},
+				SQLQuery{SQL: "INSERT INTO comment_search (comment_search) VALUES ('rebuild')"},
+			)
+			return conn.MultiExecWithTx(queries)
+		},
+		Down: func(conn SQLiteConn) error {
+			return conn.MultiExecWithTx([]SQLQuery{
+				{SQL: "DROP TRIGGER IF EXISTS comment_search_insert"},
+				{SQL: "DROP TRIGGER IF EXISTS comment_search_update"},
+				{SQL: "DROP TRIGGER IF EXISTS comment_search_delete"},
+				{SQL: "DROP TABLE comment_search"},
+				{SQL: "DROP VIEW IF EXISTS comment_search_content"},
+				{SQL: "DROP TABLE comment_search_ids"},
+			})
+		},
 	},
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrations(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conf := StorageConf{Path: filepath.Join(t.TempDir(), "dab.db")}
+	previous := SemVer{1, 30, 0}
+	oldest := SemVer{1, 26, 4}
+
+	_, conn, err := NewStorage(ctx, NewTestLevelLogger(t), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.AddUser("username", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	err = conn.Exec("INSERT INTO comments (id, author, score, permalink, sub, created, body) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		"comment", "username", -10, "/r/test/comments/a/_/comment", "test", time.Now().Unix(), "migrated unicorns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	t.Run("status", func(t *testing.T) {
+		db, conn, err := OpenStorageForMigrations(ctx, NewTestLevelLogger(t), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		if !db.WrittenVersion.Equal(Version) {
+			t.Errorf("the database should have been written by version %s, not %s", Version, db.WrittenVersion)
+		}
+		if pending := db.PendingMigrations(); len(pending) != 0 {
+			t.Errorf("no migration should be pending, got %v", pending)
+		}
+		if limit := db.RollbackLimit(); !limit.Equal(oldest) {
+			t.Errorf("the database should be able to be rolled back to %s, not %s", oldest, limit)
+		}
+	})
+
+	t.Run("rollback refused", func(t *testing.T) {
+		db, conn, err := OpenStorageForMigrations(ctx, NewTestLevelLogger(t), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		// 1.30.5 is in the middle of the migration from 1.30.0 to 1.31.0, which would then be skipped,
+		// and the migration from 1.26.3 to 1.26.4 can't be reverted.
+		for _, to := range []SemVer{{1, 26, 3}, {1, 30, 5}, Version, {1, 32, 0}} {
+			if _, err := db.Rollback(ctx, conn, to); err == nil {
+				t.Errorf("the rollback to %s should have been refused", to)
+			}
+		}
+		if err := db.getWrittenVersion(conn); err != nil {
+			t.Fatal(err)
+		} else if !db.WrittenVersion.Equal(Version) {
+			t.Errorf("a refused rollback shouldn't change the version, got %s", db.WrittenVersion)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		db, conn, err := OpenStorageForMigrations(ctx, NewTestLevelLogger(t), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		reverted, err := db.Rollback(ctx, conn, previous)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(reverted) != 1 {
+			t.Errorf("one migration should have been reverted, got %d", len(reverted))
+		}
+		if _, err := os.Stat(db.MigrationBackupPath(Version)); err != nil {
+			t.Errorf("the database should have been backed up before the rollback: %v", err)
+		}
+		if err := conn.Exec("SELECT * FROM comment_search"); err == nil {
+			t.Error("the tables of the migration should have been dropped")
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		db, conn, err := OpenStorageForMigrations(ctx, NewTestLevelLogger(t), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		if !db.WrittenVersion.Equal(previous) {
+			t.Fatalf("the database should have been rolled back to %s, not %s", previous, db.WrittenVersion)
+		}
+		if applied, err := db.DryRunMigrations(ctx, conn); err != nil {
+			t.Fatal(err)
+		} else if len(applied) != 1 {
+			t.Errorf("one migration should have been tried, got %d", len(applied))
+		}
+		if err := db.getWrittenVersion(conn); err != nil {
+			t.Fatal(err)
+		} else if !db.WrittenVersion.Equal(previous) {
+			t.Errorf("a dry run shouldn't change the version, got %s", db.WrittenVersion)
+		}
+	})
+
+	t.Run("migrate again", func(t *testing.T) {
+		_, conn, err := NewStorage(ctx, NewTestLevelLogger(t), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		if _, err := os.Stat(conf.Path + "." + previous.String() + ".backup"); err != nil {
+			t.Errorf("the database should have been backed up before the migration: %v", err)
+		}
+		if users, err := conn.ListUsers(); err != nil {
+			t.Fatal(err)
+		} else if len(users) != 1 {
+			t.Errorf("the user should have been kept, got %v", users)
+		}
+		if comments, err := conn.SearchComments(NewCommentSearch("unicorns"), Pagination{Limit: 10}); err != nil {
+			t.Fatal(err)
+		} else if len(comments) != 1 {
+			t.Errorf("the comments should have been indexed again by the migration, got %v", comments)
+		}
+	})
+
+	t.Run("rollback to the oldest version", func(t *testing.T) {
+		db, conn, err := OpenStorageForMigrations(ctx, NewTestLevelLogger(t), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reverted, err := db.Rollback(ctx, conn, db.RollbackLimit()); err != nil {
+			t.Fatal(err)
+		} else if len(reverted) != 5 {
+			t.Errorf("five migrations should have been reverted, got %d", len(reverted))
+		}
+		conn.Close()
+
+		// Open it like the previous version would.
+		opts := storageDatabaseOptions(conf)
+		opts.InitHook = nil
+		opts.Migrations = nil
+		opts.Version = oldest
+		_, conn, err = NewSQLiteDatabase(ctx, NewTestLevelLogger(t), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, column := range [][2]string{
+			{"user_archive", "submissions_new"}, {"user_archive", "backfill_sort"}, {"user_archive", "next_scan"}, {"comments", "removed_by"},
+		} {
+			if err := conn.Exec("SELECT " + column[1] + " FROM " + column[0]); err == nil {
+				t.Errorf("the column %s.%s added by the migrations should have been dropped", column[0], column[1])
+			}
+		}
+		conn.Close()
+	})
+
+	t.Run("migrate from the oldest version", func(t *testing.T) {
+		_, conn, err := NewStorage(ctx, NewTestLevelLogger(t), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		if users, err := conn.ListUsers(); err != nil {
+			t.Fatal(err)
+		} else if len(users) != 1 {
+			t.Errorf("the user should have been kept, got %v", users)
+		}
+		if comment, exists, err := conn.GetComment("comment"); err != nil {
+			t.Fatal(err)
+		} else if !exists || comment.Body != "migrated unicorns" {
+			t.Errorf("the comment should have been kept, got %+v", comment)
+		}
+	})
+}
+
+func TestParseSemVer(t *testing.T) {
+	t.Parallel()
+
+	if version, err := ParseSemVer("v1.31.0"); err != nil {
+		t.Error(err)
+	} else if !version.Equal(SemVer{1, 31, 0}) {
+		t.Errorf("expected 1.31.0, got %s", version)
+	}
+	for _, raw := range []string{"", "1.31", "1.31.0.1", "1.256.0", "1.a.0"} {
+		if _, err := ParseSemVer(raw); err == nil {
+			t.Errorf("%q should be an invalid version", raw)
+		}
+	}
+}
@@ -7,6 +7,13 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+)
+
+// Who removed a comment.
+const (
+	RemovedByAuthor    = "author"
+	RemovedByModerator = "moderator"
 )
 
 // Comment is a Reddit comment.
@@ -18,11 +25,13 @@ type Comment struct {
 	Sub       string    // Name of the subreddit
 	Created   time.Time // Date of creation (doesn't account for edits)
 	Body      string    // Markdown content with HTML escaping
+	RemovedBy string    // RemovedByAuthor or RemovedByModerator if the comment has been removed, else empty
+	Removed   time.Time // Date when the removal was first seen, zero if the comment hasn't been removed
 }
 
 // InitializationQueries returns SQL queries to store Comments.
 func (c Comment) InitializationQueries() []SQLQuery {
-	return []SQLQuery{
+	queries := []SQLQuery{
 		{SQL: `CREATE TABLE IF NOT EXISTS comments (
 			id TEXT PRIMARY KEY,
 			author TEXT NOT NULL,
@@ -31,19 +40,78 @@ func (c Comment) InitializationQueries() []SQLQuery {
 			sub TEXT NOT NULL,
 			created INTEGER NOT NULL,
 			body TEXT NOT NULL,
+			removed_by TEXT DEFAULT "" NOT NULL,
+			removed INTEGER DEFAULT 0 NOT NULL,
 			FOREIGN KEY (author) REFERENCES user_archive(name)
 		) WITHOUT ROWID`},
 		{SQL: "CREATE INDEX IF NOT EXISTS comments_idx ON comments (author, score ASC, sub, created DESC)"},
+		{SQL: `CREATE INDEX IF NOT EXISTS comments_removals_idx ON comments (sub, removed DESC) WHERE removed_by != ""`},
 		{SQL: fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS purge_user BEFORE DELETE ON user_archive
 			BEGIN
 				DELETE FROM comments WHERE author = OLD.name COLLATE NOCASE;
+				DELETE FROM submissions WHERE author = OLD.name COLLATE NOCASE;
 				DELETE FROM key_value WHERE key = %q || OLD.name COLLATE NOCASE;
 			END`, DiscordPrefixWhoRegistered)},
 	}
+	return append(queries, c.searchInitializationQueries()...)
+}
+
+// searchInitializationQueries returns SQL queries to index the bodies of the comments for full-text search.
+// FTS5 needs integer row IDs while comments have none, so comment_search_ids gives one to each comment,
+// and the index reads the bodies through a view instead of duplicating them.
+func (c Comment) searchInitializationQueries() []SQLQuery {
+	return []SQLQuery{
+		{SQL: `CREATE TABLE IF NOT EXISTS comment_search_ids (
+			search_id INTEGER PRIMARY KEY,
+			id TEXT UNIQUE NOT NULL
+		)`},
+		{SQL: `CREATE VIEW IF NOT EXISTS comment_search_content AS
+			SELECT comment_search_ids.search_id AS search_id, comments.body AS body
+			FROM comment_search_ids JOIN comments ON comments.id = comment_search_ids.id`},
+		{SQL: `CREATE VIRTUAL TABLE IF NOT EXISTS comment_search USING fts5(
+			body,
+			content = comment_search_content,
+			content_rowid = search_id
+		)`},
+		{SQL: `CREATE TRIGGER IF NOT EXISTS comment_search_insert AFTER INSERT ON comments
+			BEGIN
+				INSERT INTO comment_search_ids (id) VALUES (NEW.id);
+				INSERT INTO comment_search (rowid, body)
+					SELECT search_id, NEW.body FROM comment_search_ids WHERE id = NEW.id;
+			END`},
+		{SQL: `CREATE TRIGGER IF NOT EXISTS comment_search_update AFTER UPDATE OF body ON comments
+			WHEN OLD.body != NEW.body
+			BEGIN
+				INSERT INTO comment_search (comment_search, rowid, body)
+					SELECT 'delete', search_id, OLD.body FROM comment_search_ids WHERE id = OLD.id;
+				INSERT INTO comment_search (rowid, body)
+					SELECT search_id, NEW.body FROM comment_search_ids WHERE id = NEW.id;
+			END`},
+		{SQL: `CREATE TRIGGER IF NOT EXISTS comment_search_delete AFTER DELETE ON comments
+			BEGIN
+				INSERT INTO comment_search (comment_search, rowid, body)
+					SELECT 'delete', search_id, OLD.body FROM comment_search_ids WHERE id = OLD.id;
+				DELETE FROM comment_search_ids WHERE id = OLD.id;
+			END`},
+	}
 }
 
 // ToDB returns arguments in the correct order to register a Comment.
 func (c Comment) ToDB() []interface{} {
+	return append(c.contentToDB(), c.removalToDB()...)
+}
+
+// removalToDB returns the arguments for the columns that describe the removal of a comment.
+func (c Comment) removalToDB() []interface{} {
+	var removed int64
+	if c.RemovedBy != "" {
+		removed = c.Removed.Unix()
+	}
+	return []interface{}{c.RemovedBy, removed}
+}
+
+// contentToDB returns the arguments for the columns that comments and submissions have in common.
+func (c Comment) contentToDB() []interface{} {
 	return []interface{}{c.ID, c.Author, c.Score, c.Permalink, c.Sub, c.Created.Unix(), c.Body}
 }
 
@@ -51,6 +119,29 @@ func (c Comment) ToDB() []interface{} {
 func (c *Comment) FromDB(stmt *SQLiteStmt) error {
 	var err error
 
+	if err = c.contentFromDB(stmt); err != nil {
+		return err
+	}
+
+	if c.RemovedBy, _, err = stmt.ColumnText(7); err != nil {
+		return err
+	}
+
+	var timestamp int64
+	if timestamp, _, err = stmt.ColumnInt64(8); err != nil {
+		return err
+	}
+	if c.RemovedBy != "" {
+		c.Removed = time.Unix(timestamp, 0)
+	}
+
+	return nil
+}
+
+// contentFromDB reads the columns that comments and submissions have in common.
+func (c *Comment) contentFromDB(stmt *SQLiteStmt) error {
+	var err error
+
 	if c.ID, _, err = stmt.ColumnText(0); err != nil {
 		return err
 	}
@@ -94,6 +185,7 @@ func (c Comment) ToView(n uint64, timezone *time.Location, cbc CommentBodyConver
 	}
 	view.Comment = c
 	view.Created = view.Created.In(timezone)
+	view.Removed = view.Removed.In(timezone)
 	return view
 }
 
@@ -105,6 +197,18 @@ type CommentView struct {
 	Comment
 	BodyConverter CommentBodyConverter
 	Number        uint64
+	Revised       bool // True if the body has been edited since the comment was first saved
+}
+
+// Removal describes who removed the comment, or is empty if it hasn't been removed.
+func (cv CommentView) Removal() string {
+	switch cv.RemovedBy {
+	case RemovedByAuthor:
+		return "deleted by the author"
+	case RemovedByModerator:
+		return "removed by a moderator"
+	}
+	return ""
 }
 
 // BodyLines returns the lines in the comment of a Comment.
@@ -120,6 +224,154 @@ func (cv CommentView) BodyConvert() (interface{}, error) {
 	return cv.Body, nil
 }
 
+// Submission is a Reddit submission, which has the same properties as a Comment plus a title and a link.
+type Submission struct {
+	Comment        // The body is the text of the submission, empty if it's only a link
+	Title   string // Title of the submission
+	URL     string // Link of the submission, which for text-only submissions is the submission itself
+}
+
+// InitializationQueries returns SQL queries to store Submissions.
+func (s Submission) InitializationQueries() []SQLQuery {
+	return []SQLQuery{
+		{SQL: `CREATE TABLE IF NOT EXISTS submissions (
+			id TEXT PRIMARY KEY,
+			author TEXT NOT NULL,
+			score INTEGER NOT NULL,
+			permalink TEXT NOT NULL,
+			sub TEXT NOT NULL,
+			created INTEGER NOT NULL,
+			body TEXT NOT NULL,
+			title TEXT NOT NULL,
+			url TEXT NOT NULL,
+			FOREIGN KEY (author) REFERENCES user_archive(name)
+		) WITHOUT ROWID`},
+		{SQL: "CREATE INDEX IF NOT EXISTS submissions_idx ON submissions (author, score ASC, sub, created DESC)"},
+	}
+}
+
+// ToDB returns arguments in the correct order to register a Submission.
+func (s Submission) ToDB() []interface{} {
+	return append(s.Comment.contentToDB(), s.Title, s.URL)
+}
+
+// FromDB reads a submission from a database.
+func (s *Submission) FromDB(stmt *SQLiteStmt) error {
+	var err error
+
+	if err = s.Comment.contentFromDB(stmt); err != nil {
+		return err
+	}
+
+	if s.Title, _, err = stmt.ColumnText(7); err != nil {
+		return err
+	}
+
+	s.URL, _, err = stmt.ColumnText(8)
+
+	return err
+}
+
+// ToView converts the submission to a data structure suitable for use in a template.
+func (s Submission) ToView(n uint64, timezone *time.Location, cbc CommentBodyConverter) SubmissionView {
+	return SubmissionView{
+		CommentView: s.Comment.ToView(n, timezone, cbc),
+		Title:       s.Title,
+		URL:         s.URL,
+	}
+}
+
+// SubmissionView is a data structure describing a Submission such as it is suitable for use in a template.
+type SubmissionView struct {
+	CommentView
+	Title string
+	URL   string
+}
+
+// CommentScore is the score of a comment at a given time.
+type CommentScore struct {
+	ID       string    // Identifier of the comment
+	Score    int64     // Score of the comment when it was observed
+	Observed time.Time // Date when the score was seen
+}
+
+// InitializationQueries returns SQL queries to store the history of the scores of comments.
+func (cs CommentScore) InitializationQueries() []SQLQuery {
+	return []SQLQuery{
+		{SQL: `CREATE TABLE IF NOT EXISTS comment_scores (
+			id TEXT NOT NULL,
+			score INTEGER NOT NULL,
+			observed INTEGER NOT NULL,
+			PRIMARY KEY (id, observed),
+			FOREIGN KEY (id) REFERENCES comments(id) ON DELETE CASCADE
+		) WITHOUT ROWID`},
+	}
+}
+
+// FromDB reads the score of a comment from a database.
+func (cs *CommentScore) FromDB(stmt *SQLiteStmt) error {
+	var err error
+
+	if cs.ID, _, err = stmt.ColumnText(0); err != nil {
+		return err
+	}
+
+	if cs.Score, _, err = stmt.ColumnInt64(1); err != nil {
+		return err
+	}
+
+	var timestamp int64
+	if timestamp, _, err = stmt.ColumnInt64(2); err != nil {
+		return err
+	}
+	cs.Observed = time.Unix(timestamp, 0)
+
+	return nil
+}
+
+// CommentRevision is a previous version of the body of a comment.
+type CommentRevision struct {
+	ID       string    // Identifier of the comment
+	Body     string    // Body of the comment before it was edited
+	Replaced time.Time // Date when the edited body was seen
+}
+
+// InitializationQueries returns SQL queries to store the previous versions of the bodies of comments.
+func (cr CommentRevision) InitializationQueries() []SQLQuery {
+	return []SQLQuery{
+		{SQL: `CREATE TABLE IF NOT EXISTS comment_revisions (
+			id TEXT NOT NULL,
+			body TEXT NOT NULL,
+			replaced INTEGER NOT NULL,
+			PRIMARY KEY (id, replaced),
+			FOREIGN KEY (id) REFERENCES comments(id) ON DELETE CASCADE
+		) WITHOUT ROWID`},
+	}
+}
+
+// FromDB reads a previous version of the body of a comment from a database.
+func (cr *CommentRevision) FromDB(stmt *SQLiteStmt) error {
+	var err error
+
+	if cr.ID, _, err = stmt.ColumnText(0); err != nil {
+		return err
+	}
+
+	var body string
+	if body, _, err = stmt.ColumnText(1); err != nil {
+		return err
+	}
+	cr.Body = html.UnescapeString(body)
+
+	var timestamp int64
+	if timestamp, _, err = stmt.ColumnInt64(2); err != nil {
+		return err
+	}
+	cr.Replaced = time.Unix(timestamp, 0)
+
+	return nil
+}
+
 // User describes a Reddit user.
 type User struct {
 	Name      string
@@ -135,6 +387,15 @@ type User struct {
 	LastScan  time.Time // Date when this user was last scanned
 	New       bool      // True if this user hasn't been fully scanned yet
 	Position  string    // Last position ID returned by Reddit during a scan (used to request successive batches of comments)
+
+	SubmissionsBatchSize uint   // Same as BatchSize but for submissions
+	SubmissionsNew       bool   // True if the submissions of this user haven't been fully scanned yet
+	SubmissionsPosition  string // Same as Position but for submissions
+
+	BackfillSort     string // Sort of the listing being backfilled, empty if there's none to backfill
+	BackfillPosition string // Same as Position but for the listing being backfilled
+
+	NextScan time.Time // Date from which this user is due to be scanned again
 }
 
 // InitializationQueries retuns the SQL queries to create a table to save the User data structure.
@@ -152,13 +413,21 @@ func (u User) InitializationQueries() []SQLQuery {
 			inactive BOOLEAN DEFAULT FALSE NOT NULL,
 			last_scan INTEGER DEFAULT FALSE NOT NULL,
 			new BOOLEAN DEFAULT TRUE NOT NULL,
-			position TEXT DEFAULT "" NOT NULL
+			position TEXT DEFAULT "" NOT NULL,
+			submissions_batch_size INTEGER DEFAULT ` + strconv.Itoa(MaxRedditListingLength) + ` NOT NULL,
+			submissions_new BOOLEAN DEFAULT TRUE NOT NULL,
+			submissions_position TEXT DEFAULT "" NOT NULL,
+			backfill_sort TEXT DEFAULT "" NOT NULL,
+			backfill_position TEXT DEFAULT "" NOT NULL,
+			next_scan INTEGER DEFAULT 0 NOT NULL
 		) WITHOUT ROWID`},
 		// Yes, this index has a lot of columns, but it's the only way to get a covering index in queries for that table.
 		{SQL: `CREATE INDEX IF NOT EXISTS user_archive_idx ON user_archive
-			(name, created ASC, not_found, suspended, added ASC, batch_size, deleted, hidden, inactive, last_scan DESC, new, position)`},
+			(name, created ASC, not_found, suspended, added ASC, batch_size, deleted, hidden, inactive, last_scan DESC, new, position,
+			submissions_batch_size, submissions_new, submissions_position, backfill_sort, backfill_position, next_scan ASC)`},
 		{SQL: `CREATE VIEW IF NOT EXISTS
-			users(name, created, not_found, suspended, added, batch_size, deleted, hidden, inactive, last_scan, new, position)
+			users(name, created, not_found, suspended, added, batch_size, deleted, hidden, inactive, last_scan, new, position,
+				submissions_batch_size, submissions_new, submissions_position, backfill_sort, backfill_position, next_scan)
 		AS SELECT * FROM user_archive WHERE deleted IS FALSE`},
 	}
 }
@@ -166,7 +435,8 @@ func (u User) InitializationQueries() []SQLQuery {
 // ToDB returns well-ordered arguments to save a User.
 func (u User) ToDB() []interface{} {
 	return []interface{}{u.Name, u.Created.Unix(), u.NotFound, u.Suspended, u.Added.Unix(),
-		int(u.BatchSize), u.Hidden, u.Inactive, u.LastScan.Unix(), u.New, u.Position}
+		int(u.BatchSize), u.Hidden, u.Inactive, u.LastScan.Unix(), u.New, u.Position,
+		int(u.SubmissionsBatchSize), u.SubmissionsNew, u.SubmissionsPosition, u.BackfillSort, u.BackfillPosition, u.NextScan.Unix()}
 }
 
 // InTimezone converts the User's dates to the given time zone.
@@ -174,6 +444,7 @@ func (u User) InTimezone(timezone *time.Location) User {
 	u.Created = u.Created.In(timezone)
 	u.Added = u.Added.In(timezone)
 	u.LastScan = u.LastScan.In(timezone)
+	u.NextScan = u.NextScan.In(timezone)
 	return u
 }
 
@@ -238,14 +509,350 @@ func (u *User) FromDB(stmt *SQLiteStmt) error {
 	}
 	u.New = (boolean == 1)
 
-	u.Position, _, err = stmt.ColumnText(11)
+	if u.Position, _, err = stmt.ColumnText(11); err != nil {
+		return err
+	}
 
-	return err
+	if size, _, err = stmt.ColumnInt(12); err != nil {
+		return err
+	}
+	u.SubmissionsBatchSize = uint(size)
+
+	if boolean, _, err = stmt.ColumnInt(13); err != nil {
+		return err
+	}
+	u.SubmissionsNew = (boolean == 1)
+
+	if u.SubmissionsPosition, _, err = stmt.ColumnText(14); err != nil {
+		return err
+	}
+
+	if u.BackfillSort, _, err = stmt.ColumnText(15); err != nil {
+		return err
+	}
+
+	if u.BackfillPosition, _, err = stmt.ColumnText(16); err != nil {
+		return err
+	}
+
+	if timestamp, _, err = stmt.ColumnInt64(17); err != nil {
+		return err
+	}
+	u.NextScan = time.Unix(timestamp, 0)
+
+	return nil
+}
+
+// UserKarma is a sample of the data about a Reddit account as a whole, such as Reddit gives it.
+type UserKarma struct {
+	Name         string    // Name of the user
+	LinkKarma    int64     // Karma of the submissions according to Reddit
+	CommentKarma int64     // Karma of the comments according to Reddit
+	Employee     bool      // True if the account belongs to an employee of Reddit
+	Moderator    bool      // True if the account moderates at least one subreddit
+	Verified     bool      // True if the account has a verified email address
+	Observed     time.Time // Date when the sample was taken
+}
+
+// InitializationQueries returns SQL queries to store the history of the karma of users.
+func (uk UserKarma) InitializationQueries() []SQLQuery {
+	return []SQLQuery{
+		{SQL: `CREATE TABLE IF NOT EXISTS user_karma_history (
+			name TEXT NOT NULL,
+			link_karma INTEGER NOT NULL,
+			comment_karma INTEGER NOT NULL,
+			is_employee BOOLEAN NOT NULL,
+			is_mod BOOLEAN NOT NULL,
+			verified BOOLEAN NOT NULL,
+			observed INTEGER NOT NULL,
+			PRIMARY KEY (name, observed),
+			FOREIGN KEY (name) REFERENCES user_archive(name) ON DELETE CASCADE
+		) WITHOUT ROWID`},
+	}
+}
+
+// ToDB returns arguments in the correct order to save a sample of the karma of a user.
+func (uk UserKarma) ToDB() []interface{} {
+	return []interface{}{uk.Name, uk.LinkKarma, uk.CommentKarma, uk.Employee, uk.Moderator, uk.Verified, uk.Observed.Unix()}
+}
+
+// FromDB reads a sample of the karma of a user from a database.
+func (uk *UserKarma) FromDB(stmt *SQLiteStmt) error {
+	var err error
+	var boolean int
+
+	if uk.Name, _, err = stmt.ColumnText(0); err != nil {
+		return err
+	}
+
+	if uk.LinkKarma, _, err = stmt.ColumnInt64(1); err != nil {
+		return err
+	}
+
+	if uk.CommentKarma, _, err = stmt.ColumnInt64(2); err != nil {
+		return err
+	}
+
+	if boolean, _, err = stmt.ColumnInt(3); err != nil {
+		return err
+	}
+	uk.Employee = (boolean == 1)
+
+	if boolean, _, err = stmt.ColumnInt(4); err != nil {
+		return err
+	}
+	uk.Moderator = (boolean == 1)
+
+	if boolean, _, err = stmt.ColumnInt(5); err != nil {
+		return err
+	}
+	uk.Verified = (boolean == 1)
+
+	var timestamp int64
+	if timestamp, _, err = stmt.ColumnInt64(6); err != nil {
+		return err
+	}
+	uk.Observed = time.Unix(timestamp, 0)
+
+	return nil
+}
+
+// Status describes the status of the account, or is empty if there's nothing special about it.
+func (uk UserKarma) Status() string {
+	var status []string
+	if uk.Employee {
+		status = append(status, "Reddit employee")
+	}
+	if uk.Moderator {
+		status = append(status, "moderator")
+	}
+	if uk.Verified {
+		status = append(status, "verified email")
+	}
+	return strings.Join(status, ", ")
+}
+
+// KarmaTrendPeriod is the period over which the trend of the karma of accounts is computed.
+const KarmaTrendPeriod = 30 * 24 * time.Hour
+
+// KarmaTrend summarizes how the karma of a Reddit account changed over a period.
+type KarmaTrend struct {
+	Latest        UserKarma // Most recent sample
+	Since         time.Time // Date of the sample the changes are computed from
+	CommentChange int64     // Change of the karma of the comments since then
+	LinkChange    int64     // Change of the karma of the submissions since then
+}
+
+// NewKarmaTrend computes the trend of the karma over the given period from samples ordered by date.
+// If the samples don't go back that far, the trend starts at the oldest sample.
+func NewKarmaTrend(history []UserKarma, period time.Duration) KarmaTrend {
+	var trend KarmaTrend
+	if len(history) == 0 {
+		return trend
+	}
+
+	trend.Latest = history[len(history)-1]
+	reference := history[0]
+	start := trend.Latest.Observed.Add(-period)
+	for _, sample := range history {
+		if sample.Observed.After(start) {
+			break
+		}
+		reference = sample
+	}
+
+	trend.Since = reference.Observed
+	trend.CommentChange = trend.Latest.CommentKarma - reference.CommentKarma
+	trend.LinkChange = trend.Latest.LinkKarma - reference.LinkKarma
+	return trend
+}
+
+// Exists tells if there is any sample to compute the trend from.
+func (kt KarmaTrend) Exists() bool {
+	return !kt.Latest.Observed.IsZero()
+}
+
+// InTimezone converts the KarmaTrend's dates to the given time zone.
+func (kt KarmaTrend) InTimezone(timezone *time.Location) KarmaTrend {
+	kt.Latest.Observed = kt.Latest.Observed.In(timezone)
+	kt.Since = kt.Since.In(timezone)
+	return kt
+}
+
+// Candidate is a user found commenting in a watched subreddit with a low enough score to be suggested for registration.
+type Candidate struct {
+	Name      string    // Name of the user
+	Sub       string    // Subreddit of the comment with the lowest score
+	Score     int64     // Lowest score seen among the comments of the user
+	Permalink string    // Permanent path to the comment with the lowest score
+	Found     time.Time // Date when the user was first suggested
+	Rejected  time.Time // Date when the candidate was rejected, zero if it is still pending
+}
+
+// InitializationQueries returns SQL queries to store the candidates for registration.
+func (c Candidate) InitializationQueries() []SQLQuery {
+	return []SQLQuery{
+		{SQL: `CREATE TABLE IF NOT EXISTS user_candidates (
+			name TEXT PRIMARY KEY COLLATE NOCASE,
+			sub TEXT NOT NULL,
+			score INTEGER NOT NULL,
+			permalink TEXT NOT NULL,
+			found INTEGER NOT NULL,
+			rejected INTEGER DEFAULT 0 NOT NULL
+		) WITHOUT ROWID`},
+	}
+}
+
+// FromDB reads a candidate for registration from a database.
+func (c *Candidate) FromDB(stmt *SQLiteStmt) error {
+	var err error
+
+	if c.Name, _, err = stmt.ColumnText(0); err != nil {
+		return err
+	}
+
+	if c.Sub, _, err = stmt.ColumnText(1); err != nil {
+		return err
+	}
+
+	if c.Score, _, err = stmt.ColumnInt64(2); err != nil {
+		return err
+	}
+
+	if c.Permalink, _, err = stmt.ColumnText(3); err != nil {
+		return err
+	}
+
+	var timestamp int64
+	if timestamp, _, err = stmt.ColumnInt64(4); err != nil {
+		return err
+	}
+	c.Found = time.Unix(timestamp, 0)
+
+	if timestamp, _, err = stmt.ColumnInt64(5); err != nil {
+		return err
+	}
+	if timestamp != 0 {
+		c.Rejected = time.Unix(timestamp, 0)
+	}
+
+	return nil
+}
+
+// InTimezone converts the Candidate's dates to the given time zone.
+func (c Candidate) InTimezone(timezone *time.Location) Candidate {
+	c.Found = c.Found.In(timezone)
+	c.Rejected = c.Rejected.In(timezone)
+	return c
+}
+
+// Kinds of ScanPass.
+const (
+	ScanPassDue  = "due"  // Scan of the users that are due
+	ScanPassFull = "full" // Same, followed by the update of the inactive status of the users
+)
+
+// ScanPass describes the state of the passes of the scanner of one kind, so that they can be resumed after a restart.
+type ScanPass struct {
+	Kind      string    // Kind of the pass, ScanPassDue or ScanPassFull
+	Started   time.Time // Date at which the current pass started, zero if none is in progress
+	Completed time.Time // Date at which the last pass was completed, zero if none ever was
+}
+
+// InitializationQueries returns SQL queries to store the state of the passes of the scanner.
+func (sp ScanPass) InitializationQueries() []SQLQuery {
+	return []SQLQuery{
+		{SQL: `CREATE TABLE IF NOT EXISTS scan_passes (
+			kind TEXT PRIMARY KEY,
+			started INTEGER DEFAULT 0 NOT NULL,
+			completed INTEGER DEFAULT 0 NOT NULL
+		) WITHOUT ROWID`},
+	}
+}
+
+// ToDB returns well-ordered arguments to save a ScanPass.
+func (sp ScanPass) ToDB() []interface{} {
+	var started, completed int64
+	if !sp.Started.IsZero() {
+		started = sp.Started.Unix()
+	}
+	if !sp.Completed.IsZero() {
+		completed = sp.Completed.Unix()
+	}
+	return []interface{}{sp.Kind, started, completed}
+}
+
+// FromDB reads a ScanPass from a database.
+func (sp *ScanPass) FromDB(stmt *SQLiteStmt) error {
+	var err error
+	var timestamp int64
+
+	if sp.Kind, _, err = stmt.ColumnText(0); err != nil {
+		return err
+	}
+
+	if timestamp, _, err = stmt.ColumnInt64(1); err != nil {
+		return err
+	}
+	if timestamp != 0 {
+		sp.Started = time.Unix(timestamp, 0)
+	}
+
+	if timestamp, _, err = stmt.ColumnInt64(2); err != nil {
+		return err
+	}
+	if timestamp != 0 {
+		sp.Completed = time.Unix(timestamp, 0)
+	}
+
+	return nil
+}
+
+// InProgress returns whether a pass was started and not completed.
+func (sp ScanPass) InProgress() bool {
+	return !sp.Started.IsZero()
+}
+
+// RemovalStats describes the removals of comments in a subreddit.
+type RemovalStats struct {
+	Sub         string    // Name of the subreddit
+	ByAuthor    uint64    // Number of comments deleted by their authors
+	ByModerator uint64    // Number of comments removed by moderators
+	Latest      time.Time // Date of the latest removal
+}
+
+// FromDB reads the statistics about the removals in a subreddit from a database.
+func (rs *RemovalStats) FromDB(stmt *SQLiteStmt) error {
+	var err error
+
+	if rs.Sub, _, err = stmt.ColumnText(0); err != nil {
+		return err
+	}
+
+	var count int64
+	if count, _, err = stmt.ColumnInt64(1); err != nil {
+		return err
+	}
+	rs.ByAuthor = uint64(count)
+
+	if count, _, err = stmt.ColumnInt64(2); err != nil {
+		return err
+	}
+	rs.ByModerator = uint64(count)
+
+	var timestamp int64
+	if timestamp, _, err = stmt.ColumnInt64(3); err != nil {
+		return err
+	}
+	rs.Latest = time.Unix(timestamp, 0)
+
+	return nil
 }
 
 // UserQuery describes a query to register or read a User.
 type UserQuery struct {
 	User   User
+	Karma  UserKarma // Only set when the data comes from Reddit and the account isn't suspended
 	Exists bool
 	Error  error
 }
@@ -428,6 +1035,111 @@ type Pagination struct {
 	Offset uint // Offset in the collection of items.
 }
 
+// CommentSearch describes a full-text search in the bodies of the comments, with optional filters.
+type CommentSearch struct {
+	Query    string    // Words, "quoted phrases", and prefixes ending with "*" that must all be in the body
+	Author   string    // Only the comments of that user if not empty (case-insensitive)
+	Sub      string    // Only the comments in that subreddit if not empty (case-insensitive)
+	MinScore int64     // Lowest score of the comments
+	MaxScore int64     // Highest score of the comments
+	Since    time.Time // Only the comments made at or after that date if not zero
+	Until    time.Time // Only the comments made before that date if not zero
+}
+
+// NewCommentSearch returns a CommentSearch without filters.
+func NewCommentSearch(query string) CommentSearch {
+	return CommentSearch{
+		Query:    query,
+		MinScore: math.MinInt64,
+		MaxScore: math.MaxInt64,
+	}
+}
+
+// CommentSearchFilters are the names of the filters of a CommentSearch, see CommentSearch.SetFilter.
+var CommentSearchFilters = []string{"author", "sub", "min_score", "max_score", "since", "until"}
+
+// SetFilter sets a filter from its name and its value as typed by a user;
+// dates are in the format YYYY-MM-DD in the given time zone, and "until" includes the whole day.
+func (cs *CommentSearch) SetFilter(name, value string, timezone *time.Location) error {
+	var err error
+	switch name {
+	case "author":
+		cs.Author = TrimUsername(value)
+	case "sub":
+		cs.Sub = strings.TrimPrefix(strings.TrimPrefix(value, "/r/"), "r/")
+	case "min_score":
+		if cs.MinScore, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("invalid minimum score %q", value)
+		}
+	case "max_score":
+		if cs.MaxScore, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("invalid maximum score %q", value)
+		}
+	case "since":
+		if cs.Since, err = time.ParseInLocation("2006-01-02", value, timezone); err != nil {
+			return fmt.Errorf("invalid date %q, the format is YYYY-MM-DD", value)
+		}
+	case "until":
+		if cs.Until, err = time.ParseInLocation("2006-01-02", value, timezone); err != nil {
+			return fmt.Errorf("invalid date %q, the format is YYYY-MM-DD", value)
+		}
+		cs.Until = cs.Until.AddDate(0, 0, 1)
+	default:
+		return fmt.Errorf("unknown filter %q", name)
+	}
+	return nil
+}
+
+// MatchExpression converts the query to an FTS5 expression where every term is quoted,
+// so that the syntax of FTS5 can't cause errors. It is empty if the query has no term.
+func (cs CommentSearch) MatchExpression() string {
+	var terms []string
+	query := strings.TrimSpace(cs.Query)
+	for query != "" {
+		var term string
+		var prefix bool
+		if query[0] == '"' {
+			if end := strings.IndexByte(query[1:], '"'); end < 0 {
+				term, query = query[1:], ""
+			} else {
+				term, query = query[1:end+1], query[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(query, unicode.IsSpace)
+			if end < 0 {
+				end = len(query)
+			}
+			term, query = query[:end], query[end:]
+			prefix = strings.HasSuffix(term, "*")
+			term = strings.TrimRight(term, "*")
+		}
+		query = strings.TrimSpace(query)
+
+		// The tokenizer ignores everything else, and FTS5 refuses empty phrases in some contexts.
+		if strings.IndexFunc(term, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }) < 0 {
+			continue
+		}
+		term = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix {
+			term += " *"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(terms, " ")
+}
+
+// filtersToDB returns the arguments of the filters in the order of StorageConn.SearchComments.
+func (cs CommentSearch) filtersToDB() []interface{} {
+	var since, until int64 = 0, math.MaxInt64
+	if !cs.Since.IsZero() {
+		since = cs.Since.Unix()
+	}
+	if !cs.Until.IsZero() {
+		until = cs.Until.Unix()
+	}
+	return []interface{}{cs.Author, cs.Author, cs.Sub, cs.Sub, cs.MinScore, cs.MaxScore, since, until}
+}
+
 // SQLiteForeignKeyCheck describes a foreign key error in a single row.
 type SQLiteForeignKeyCheck struct {
 	ValidRowID   bool   // RowID can be NULL, contrarily to the rest
@@ -3,13 +3,17 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
@@ -18,20 +22,42 @@ import (
 
 // Reddit-related knowledge.
 const (
-	MaxRedditListingLength = 100
-	RedditAPIRequestWait   = time.Second
+	MaxRedditListingLength  = 100
+	RedditAPIRequestWait    = time.Second
+	RedditMaxSelfTextLength = 40000
+	RedditMaxWikiPageLength = 524288
 )
 
-// MatchValidRedditUsername checks if a string is a valid username on Reddit.
-var MatchValidRedditUsername = regexp.MustCompile("^[[:word:]-]+$")
+const (
+	redditAPIDefaultRetryAfter   = 10 * time.Second
+	redditAPIMaxReconnections    = 2
+	redditAPIMaxThrottledRetries = 3
+	redditAPIMinRequestWait      = 100 * time.Millisecond
+	redditAPIRequestBurst        = 5
+	redditAPITokenRefreshMargin  = 5 * time.Minute
+)
+
+// RedditProxySchemes lists the schemes of the URLs of the proxies RedditAPI can go through.
+var RedditProxySchemes = []string{"http", "https", "socks5"}
 
-const accessTokenRawURL = "https://www.reddit.com/api/v1/access_token"
+// Bodies that Reddit puts in place of the bodies of removed comments.
+const (
+	RedditDeletedBody = "[deleted]"
+	RedditRemovedBody = "[removed]"
+)
 
-var requestBaseURL = &url.URL{
-	Scheme: "https",
-	Host:   "oauth.reddit.com",
+// RedditBackfillSorts lists in order the sorts of the listings of comments that are walked through to backfill
+// the history of users beyond the newest comments, as "<sort>:<period>".
+// Reddit caps the length of each listing and can't list them in reverse, so each sort is walked over
+// several periods, whose listings each have their own cap, to reach further into the history of heavy commenters.
+var RedditBackfillSorts = []string{
+	"controversial:all", "controversial:year", "controversial:month", "controversial:week",
+	"top:all", "top:year", "top:month", "top:week",
 }
 
+// MatchValidRedditUsername checks if a string is a valid username on Reddit.
+var MatchValidRedditUsername = regexp.MustCompile("^[[:word:]-]+$")
+
 type oAuthResponse struct {
 	Token   string `json:"access_token"`
 	Refresh string `json:"refresh_token"`
@@ -65,37 +91,356 @@ type commentListing struct {
 	}
 }
 
+func (cl commentListing) Comments() []Comment {
+	now := time.Now()
+	comments := make([]Comment, 0, len(cl.Data.Children))
+	for _, child := range cl.Data.Children {
+		comment := Comment{
+			ID:        child.Data.ID,
+			Author:    child.Data.Author,
+			Score:     child.Data.Score,
+			Permalink: child.Data.Permalink,
+			Sub:       child.Data.Subreddit,
+			Created:   time.Unix(int64(child.Data.CreatedUTC), 0),
+			Body:      child.Data.Body,
+		}
+		switch comment.Body {
+		case RedditDeletedBody:
+			comment.RemovedBy = RemovedByAuthor
+		case RedditRemovedBody:
+			comment.RemovedBy = RemovedByModerator
+		}
+		if comment.RemovedBy != "" {
+			comment.Removed = now
+		}
+		comments = append(comments, comment)
+	}
+	return comments
+}
+
+func (cl commentListing) after() string {
+	return cl.Data.After
+}
+
+type submissionListing struct {
+	Data struct {
+		Children []struct {
+			Data struct {
+				ID         string
+				Author     string
+				Score      int64
+				Permalink  string
+				Subreddit  string
+				CreatedUTC float64 `json:"created_utc"`
+				Selftext   string
+				Title      string
+				URL        string
+			}
+		}
+		After string
+	}
+}
+
+func (sl submissionListing) Submissions() []Submission {
+	submissions := make([]Submission, 0, len(sl.Data.Children))
+	for _, child := range sl.Data.Children {
+		submission := Submission{
+			Comment: Comment{
+				ID:        child.Data.ID,
+				Author:    child.Data.Author,
+				Score:     child.Data.Score,
+				Permalink: child.Data.Permalink,
+				Sub:       child.Data.Subreddit,
+				Created:   time.Unix(int64(child.Data.CreatedUTC), 0),
+				Body:      child.Data.Selftext,
+			},
+			Title: child.Data.Title,
+			URL:   child.Data.URL,
+		}
+		submissions = append(submissions, submission)
+	}
+	return submissions
+}
+
+func (sl submissionListing) after() string {
+	return sl.Data.After
+}
+
+// redditListing is a page of a listing returned by Reddit.
+type redditListing interface {
+	after() string // Position of the next page
+}
+
+// redditPostResponse is the response of the endpoints of the API that change something, when asked to respond in JSON.
+type redditPostResponse struct {
+	JSON struct {
+		Errors [][]interface{}
+		Data   struct {
+			ID   string
+			Name string
+			URL  string
+		}
+	}
+}
+
+// Err returns the errors reported by Reddit, each made of a code, a message, and the field of the form that caused it.
+func (rpr redditPostResponse) Err() error {
+	if len(rpr.JSON.Errors) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(rpr.JSON.Errors))
+	for _, fields := range rpr.JSON.Errors {
+		parts := make([]string, 0, len(fields))
+		for _, field := range fields {
+			parts = append(parts, fmt.Sprint(field))
+		}
+		msgs = append(msgs, strings.Join(parts, " "))
+	}
+	return fmt.Errorf("error(s) from reddit: %s", strings.Join(msgs, "; "))
+}
+
 type aboutUser struct {
 	Data struct {
-		Name        string
-		CreatedUTC  float64 `json:"created_utc"`
-		IsSuspended bool    `json:"is_suspended"`
+		Name         string
+		CreatedUTC   float64 `json:"created_utc"`
+		IsSuspended  bool    `json:"is_suspended"`
+		LinkKarma    int64   `json:"link_karma"`
+		CommentKarma int64   `json:"comment_karma"`
+		IsEmployee   bool    `json:"is_employee"`
+		IsMod        bool    `json:"is_mod"`
+		Verified     bool
 	}
 }
 
+// RedditReconnection describes an attempt of a RedditAPI to get a new access token after the first one.
+type RedditReconnection struct {
+	Account string    // Name of the account that reconnected
+	Error   error     // Error if the reconnection failed
+	Reason  string    // Why the access token had to be renewed
+	Time    time.Time // When the reconnection ended
+}
+
+// String implements Stringer.
+func (rr RedditReconnection) String() string {
+	if rr.Error != nil {
+		return fmt.Sprintf("failed to reconnect account %q to reddit (%s): %v", rr.Account, rr.Reason, rr.Error)
+	}
+	return fmt.Sprintf("reconnected account %q to reddit (%s)", rr.Account, rr.Reason)
+}
+
 type redditResponse struct {
 	Data   []byte
 	Status int
 	Error  error
 }
 
+// redditRateLimiter is a token bucket whose rate is adapted to what Reddit says about the state of its quota.
+type redditRateLimiter struct {
+	sync.Mutex
+	burst     float64       // Maximum number of tokens
+	interval  time.Duration // Time for a new token to be added to the bucket
+	last      time.Time     // Last time tokens were added to the bucket
+	resume    time.Time     // No request can be made before that date
+	tokens    float64       // Number of requests that can be made immediately
+	used      int           // Number of requests used in the current period according to Reddit
+	windowEnd time.Time     // End of the current period according to Reddit
+}
+
+func newRedditRateLimiter(interval time.Duration, burst uint) *redditRateLimiter {
+	return &redditRateLimiter{
+		burst:    float64(burst),
+		interval: interval,
+		last:     time.Now(),
+		tokens:   1,
+	}
+}
+
+// wait blocks until a request can be made, or returns an error if the context is cancelled.
+func (rl *redditRateLimiter) wait(ctx context.Context) error {
+	for {
+		var sleep time.Duration
+
+		rl.Lock()
+		now := time.Now()
+		rl.refill(now)
+		if now.Before(rl.resume) {
+			sleep = rl.resume.Sub(now)
+		} else if rl.tokens >= 1 {
+			rl.tokens--
+			rl.Unlock()
+			return nil
+		} else {
+			sleep = time.Duration((1 - rl.tokens) * float64(rl.interval))
+		}
+		rl.Unlock()
+
+		if !SleepCtx(ctx, sleep) {
+			return ctx.Err()
+		}
+	}
+}
+
+// update adapts the rate to the headers Reddit sends with each response to describe the state of the quota.
+// Responses without those headers are ignored.
+func (rl *redditRateLimiter) update(header http.Header) {
+	remaining, err := strconv.ParseFloat(header.Get("X-Ratelimit-Remaining"), 64)
+	if err != nil {
+		return
+	}
+	used, err := strconv.Atoi(header.Get("X-Ratelimit-Used"))
+	if err != nil {
+		return
+	}
+	reset, err := strconv.Atoi(header.Get("X-Ratelimit-Reset"))
+	if err != nil {
+		return
+	}
+
+	rl.Lock()
+	defer rl.Unlock()
+
+	now := time.Now()
+	resetIn := time.Duration(reset) * time.Second
+	windowEnd := now.Add(resetIn)
+
+	// Responses to concurrent requests can arrive out of order,
+	// so ignore those that describe an older state of the same period.
+	if used < rl.used && !windowEnd.After(rl.windowEnd.Add(time.Second)) {
+		return
+	}
+	rl.used = used
+	rl.windowEnd = windowEnd
+
+	// Tokens must be counted with the previous rate before changing it.
+	rl.refill(now)
+
+	if remaining < 1 {
+		rl.tokens = 0
+		if windowEnd.After(rl.resume) {
+			rl.resume = windowEnd
+		}
+		return
+	}
+
+	rl.interval = time.Duration(float64(resetIn) / remaining)
+	if rl.interval < redditAPIMinRequestWait {
+		rl.interval = redditAPIMinRequestWait
+	}
+	rl.tokens = math.Min(rl.tokens, remaining)
+}
+
+// throttle prevents any request for the given duration.
+func (rl *redditRateLimiter) throttle(duration time.Duration) {
+	rl.Lock()
+	defer rl.Unlock()
+	rl.tokens = 0
+	if resume := time.Now().Add(duration); resume.After(rl.resume) {
+		rl.resume = resume
+	}
+}
+
+func (rl *redditRateLimiter) refill(now time.Time) {
+	if elapsed := now.Sub(rl.last); elapsed > 0 {
+		rl.tokens = math.Min(rl.burst, rl.tokens+float64(elapsed)/float64(rl.interval))
+		rl.last = now
+	}
+}
+
+// ParseRedditProxy parses the URL of a proxy and checks its scheme is supported.
+func ParseRedditProxy(rawURL string) (*url.URL, error) {
+	proxy, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	for _, scheme := range RedditProxySchemes {
+		if proxy.Scheme == scheme {
+			if proxy.Host == "" {
+				return nil, fmt.Errorf("proxy URL %q has no host", proxy.Redacted())
+			}
+			return proxy, nil
+		}
+	}
+	return nil, fmt.Errorf("proxy URL %q must have one of the schemes %s", proxy.Redacted(), strings.Join(RedditProxySchemes, ", "))
+}
+
+// ParseBackfillSort splits an element of RedditBackfillSorts into the sort and the period of its listing.
+// Sorts without a period, saved by previous versions, are over all time.
+func ParseBackfillSort(backfillSort string) (string, string) {
+	parts := strings.SplitN(backfillSort, ":", 2)
+	if len(parts) < 2 {
+		return parts[0], "all"
+	}
+	return parts[0], parts[1]
+}
+
+// newRedditHTTPClient returns an HTTP client with its own transport, which goes through the proxy if there is one.
+// If not, proxies from the environment variables are used, like the default client.
+func newRedditHTTPClient(rawProxy string, timeout time.Duration) (*http.Client, error) {
+	proxy := http.ProxyFromEnvironment
+	if rawProxy != "" {
+		proxyURL, err := ParseRedditProxy(rawProxy)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		ExpectContinueTimeout: time.Second,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          10,
+		Proxy:                 proxy,
+		ResponseHeaderTimeout: timeout,
+		TLSHandshakeTimeout:   timeout,
+	}
+
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
+
+// parseRetryAfter reads the value of the Retry-After HTTP header, which can either be a number of seconds or a date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
 // RedditAPI provides methods to interact with Reddit.
-// Note that all exported methods automatically retry once if they got a 403 response.
+// The access token is renewed shortly before it expires, and all exported methods
+// automatically reconnect a couple of times if they got a 401 response.
+// Requests are rate-limited according to the quota Reddit describes in its responses,
+// and are retried a few times if Reddit responds that too many requests have been made.
+// In application-only mode, it connects without the credentials of a user and can only read public data.
 type RedditAPI struct {
 	sync.Mutex
-	auth      RedditAuth
-	client    *http.Client
-	oAuth     oAuthResponse
-	ticker    *time.Ticker
-	userAgent string
+	accessTokenURL string
+	appOnly        bool
+	auth           RedditAuth
+	baseURL        *url.URL
+	client         *http.Client
+	connecting     sync.Mutex // Serializes connections
+	limiter        *redditRateLimiter
+	oAuth          oAuthResponse
+	reconnections  chan RedditReconnection
+	tokenExpiry    time.Time
+	userAgent      string
 }
 
 // NewRedditAPI creates a data structure to interact with Reddit.
 // userAgent is a template for the user agent that will be used in requests.
 // It receives a map with the keys "Version", which is the SemVer version of the application,
 // and "OS", which is the name of the type of platform (eg. "linux").
+// Requests go through the proxy of the account if it has one, and are sent to the URLs from the configuration.
 // Before use, run the Connect method.
-func NewRedditAPI(ctx context.Context, auth RedditAuth, userAgent *template.Template) (*RedditAPI, error) {
+func NewRedditAPI(ctx context.Context, auth RedditAuth, userAgent *template.Template, conf RedditAPIConf) (*RedditAPI, error) {
 	var ua strings.Builder
 	data := map[string]interface{}{
 		"Version": Version,
@@ -105,30 +450,78 @@ func NewRedditAPI(ctx context.Context, auth RedditAuth, userAgent *template.Temp
 		return nil, err
 	}
 
-	client := &http.Client{}
+	baseURL, err := url.Parse(conf.APIURL)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newRedditHTTPClient(auth.Proxy, conf.Timeout.Value)
+	if err != nil {
+		return nil, err
+	}
+
 	ra := &RedditAPI{
-		auth:      auth,
-		client:    client,
-		ticker:    time.NewTicker(RedditAPIRequestWait),
-		userAgent: ua.String(),
+		accessTokenURL: conf.AccessTokenURL,
+		appOnly:        conf.AppOnly,
+		auth:           auth,
+		baseURL:        baseURL,
+		client:         client,
+		limiter:        newRedditRateLimiter(RedditAPIRequestWait, redditAPIRequestBurst),
+		userAgent:      ua.String(),
 	}
 
 	return ra, nil
 }
 
+// AppOnly tells whether the API is used without the credentials of a user,
+// in which case the methods that write on Reddit always fail.
+func (ra *RedditAPI) AppOnly() bool {
+	return ra.appOnly
+}
+
 // Connect gets a token from Reddit's API which will be used for all requests.
 func (ra *RedditAPI) Connect(ctx context.Context) error {
-	authConf := url.Values{
-		"grant_type": {"password"},
-		"username":   {ra.auth.Username},
-		"password":   {ra.auth.Password},
+	ra.connecting.Lock()
+	defer ra.connecting.Unlock()
+	return ra.connect(ctx)
+}
+
+// OpenReconnections creates, sets, and returns a channel that sends the reconnections happening after the first connection.
+// Reconnections are dropped if the channel is full.
+func (ra *RedditAPI) OpenReconnections() <-chan RedditReconnection {
+	ra.Lock()
+	defer ra.Unlock()
+	if ra.reconnections == nil {
+		ra.reconnections = make(chan RedditReconnection, DefaultChannelSize)
+	}
+	return ra.reconnections
+}
+
+// CloseReconnections closes and unsets the channel that sends reconnections.
+func (ra *RedditAPI) CloseReconnections() {
+	ra.Lock()
+	defer ra.Unlock()
+	if ra.reconnections != nil {
+		close(ra.reconnections)
+		ra.reconnections = nil
+	}
+}
+
+func (ra *RedditAPI) connect(ctx context.Context) error {
+	authConf := url.Values{"grant_type": {"client_credentials"}}
+	if !ra.appOnly {
+		authConf = url.Values{
+			"grant_type": {"password"},
+			"username":   {ra.auth.Username},
+			"password":   {ra.auth.Password},
+		}
 	}
 	authForm := strings.NewReader(authConf.Encode())
 
 	// This might be called from RedditAPI.rawRequest,
-	// so we can't use it to make our request (deadlock),
+	// so we can't use it to make our request (infinite recursion),
 	// and we have different needs here anyway.
-	req, err := http.NewRequest("POST", accessTokenRawURL, authForm)
+	req, err := http.NewRequest("POST", ra.accessTokenURL, authForm)
 	if err != nil {
 		return err
 	}
@@ -146,43 +539,148 @@ func (ra *RedditAPI) Connect(ctx context.Context) error {
 	}
 
 	body, readErr := ioutil.ReadAll(res.Body)
+	res.Body.Close()
 	if readErr != nil {
 		return readErr
 	}
 
+	oAuth := oAuthResponse{}
+	if err := json.Unmarshal(body, &oAuth); err != nil {
+		return err
+	}
+
+	if oAuth.Error != "" {
+		return fmt.Errorf("error when logging into Reddit: %q", oAuth.Error)
+	}
+
 	ra.Lock()
 	defer ra.Unlock()
-	if err := json.Unmarshal(body, &ra.oAuth); err != nil {
+	ra.oAuth = oAuth
+	ra.tokenExpiry = time.Time{}
+	if oAuth.Timeout > 0 {
+		ra.tokenExpiry = time.Now().Add(time.Duration(oAuth.Timeout) * time.Second)
+	}
+
+	return nil
+}
+
+// reconnect gets a new token unless another goroutine already replaced the stale one while waiting.
+func (ra *RedditAPI) reconnect(ctx context.Context, staleToken, reason string) error {
+	ra.connecting.Lock()
+	defer ra.connecting.Unlock()
+
+	if ra.token() != staleToken {
+		return nil
+	}
+
+	err := ra.connect(ctx)
+	if IsCancellation(err) {
 		return err
 	}
 
-	if ra.oAuth.Error != "" {
-		return fmt.Errorf("error when logging into Reddit: %q", ra.oAuth.Error)
+	ra.Lock()
+	defer ra.Unlock()
+	if ra.reconnections != nil {
+		reconnection := RedditReconnection{Account: ra.auth.Name(), Error: err, Reason: reason, Time: time.Now()}
+		select {
+		case ra.reconnections <- reconnection:
+		default:
+		}
+	}
+
+	return err
+}
+
+// validToken returns the current token, after renewing it if it is about to expire.
+// If the renewal fails, the current token is used until it has actually expired.
+func (ra *RedditAPI) validToken(ctx context.Context) (string, error) {
+	ra.Lock()
+	token := ra.oAuth.Token
+	expiry := ra.tokenExpiry
+	ra.Unlock()
+
+	if expiry.IsZero() || time.Until(expiry) > redditAPITokenRefreshMargin {
+		return token, nil
+	}
+
+	if err := ra.reconnect(ctx, token, "access token about to expire"); err != nil {
+		if IsCancellation(err) || !time.Now().Before(expiry) {
+			return "", err
+		}
+		return token, nil
 	}
 
-	return nil
+	return ra.token(), nil
+}
+
+func (ra *RedditAPI) token() string {
+	ra.Lock()
+	defer ra.Unlock()
+	return ra.oAuth.Token
 }
 
 // UserComments fetches nb comments for a User, and returns a slice of Comment and an updated User.
 func (ra *RedditAPI) UserComments(ctx context.Context, user User, nb uint) ([]Comment, User, error) {
-	comments, position, status, err := ra.getListing(ctx, "/u/"+user.Name+"/comments", user.Position, nb)
+	parsed := &commentListing{}
+	position, status, err := ra.getListing(ctx, "/u/"+user.Name+"/comments", "new", "", user.Position, nb, parsed)
 	if err != nil {
 		return nil, user, err
 	}
 	user.Position = position
 
-	// Fetching the comments of a user that's been suspended can return 403,
+	user, err = ra.checkUserStatus(ctx, user, status)
+	return parsed.Comments(), user, err
+}
+
+// UserSubmissions fetches nb submissions for a User, and returns a slice of Submission and an updated User.
+func (ra *RedditAPI) UserSubmissions(ctx context.Context, user User, nb uint) ([]Submission, User, error) {
+	parsed := &submissionListing{}
+	position, status, err := ra.getListing(ctx, "/u/"+user.Name+"/submitted", "new", "", user.SubmissionsPosition, nb, parsed)
+	if err != nil {
+		return nil, user, err
+	}
+	user.SubmissionsPosition = position
+
+	user, err = ra.checkUserStatus(ctx, user, status)
+	return parsed.Submissions(), user, err
+}
+
+// UserCommentsBackfill fetches nb comments for a User from the listing described by User.BackfillSort
+// (see RedditBackfillSorts), and returns a slice of Comment and an updated User.
+func (ra *RedditAPI) UserCommentsBackfill(ctx context.Context, user User, nb uint) ([]Comment, User, error) {
+	parsed := &commentListing{}
+	sort, period := ParseBackfillSort(user.BackfillSort)
+	position, status, err := ra.getListing(ctx, "/u/"+user.Name+"/comments", sort, period, user.BackfillPosition, nb, parsed)
+	if err != nil {
+		return nil, user, err
+	}
+	user.BackfillPosition = position
+
+	user, err = ra.checkUserStatus(ctx, user, status)
+	return parsed.Comments(), user, err
+}
+
+// SubComments fetches the nb newest comments posted in a subreddit.
+func (ra *RedditAPI) SubComments(ctx context.Context, sub string, nb uint) ([]Comment, error) {
+	parsed := &commentListing{}
+	if _, _, err := ra.getListing(ctx, "/r/"+sub+"/comments", "new", "", "", nb, parsed); err != nil {
+		return nil, err
+	}
+	return parsed.Comments(), nil
+}
+
+func (ra *RedditAPI) checkUserStatus(ctx context.Context, user User, status int) (User, error) {
+	// Fetching the listings of a user that's been suspended can return 403,
 	// so the status doesn't really give enough information.
 	if status == 403 || status == 404 {
 		about := ra.AboutUser(ctx, user.Name)
 		if about.Error != nil {
-			return nil, user, about.Error
+			return user, about.Error
 		}
 		user.Suspended = about.User.Suspended
 		user.NotFound = !about.Exists
 	}
-
-	return comments, user, nil
+	return user, nil
 }
 
 // AboutUser returns reddit-only data about the user:
@@ -190,6 +688,7 @@ func (ra *RedditAPI) UserComments(ctx context.Context, user User, nb uint) ([]Co
 //  - whether it is suspended
 //  - its name with the correct capitalization
 //  - when it was created
+//  - its karma and the status of the account, unless it is suspended
 // It returns an error without making a request if username contains characters that are forbidden by Reddit.
 func (ra *RedditAPI) AboutUser(ctx context.Context, username string) UserQuery {
 	query := UserQuery{User: User{Name: username}}
@@ -223,9 +722,75 @@ func (ra *RedditAPI) AboutUser(ctx context.Context, username string) UserQuery {
 	query.User.Name = about.Data.Name
 	query.User.Created = time.Unix(int64(about.Data.CreatedUTC), 0)
 	query.User.Suspended = about.Data.IsSuspended
+	if !query.User.Suspended {
+		query.Karma = UserKarma{
+			Name:         about.Data.Name,
+			LinkKarma:    about.Data.LinkKarma,
+			CommentKarma: about.Data.CommentKarma,
+			Employee:     about.Data.IsEmployee,
+			Moderator:    about.Data.IsMod,
+			Verified:     about.Data.Verified,
+			Observed:     time.Now(),
+		}
+	}
 	return query
 }
 
+// CommentsInfo fetches up to MaxRedditListingLength comments from their IDs, to get their current state.
+// Comments that don't exist anymore are left out.
+func (ra *RedditAPI) CommentsInfo(ctx context.Context, ids []string) ([]Comment, error) {
+	if len(ids) > MaxRedditListingLength {
+		return nil, fmt.Errorf("can't fetch the data of more than %d comments at once, not %d", MaxRedditListingLength, len(ids))
+	}
+
+	fullIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		fullIDs = append(fullIDs, "t1_"+id)
+	}
+	query := url.Values{}
+	query.Set("id", strings.Join(fullIDs, ","))
+
+	res := ra.request(ctx, "GET", &url.URL{Path: "/api/info", RawQuery: query.Encode()}, nil)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.Status != 200 {
+		return nil, fmt.Errorf("bad response status when fetching data about %d comments: %d", len(ids), res.Status)
+	}
+
+	parsed := &commentListing{}
+	if err := json.Unmarshal(res.Data, parsed); err != nil {
+		return nil, err
+	}
+
+	return parsed.Comments(), nil
+}
+
+// SubmissionInfo fetches a submission from its ID to get its current state, and returns whether it still exists.
+func (ra *RedditAPI) SubmissionInfo(ctx context.Context, id string) (Submission, bool, error) {
+	query := url.Values{}
+	query.Set("id", "t3_"+id)
+
+	res := ra.request(ctx, "GET", &url.URL{Path: "/api/info", RawQuery: query.Encode()}, nil)
+	if res.Error != nil {
+		return Submission{}, false, res.Error
+	}
+	if res.Status != 200 {
+		return Submission{}, false, fmt.Errorf("bad response status when fetching data about the submission %q: %d", id, res.Status)
+	}
+
+	parsed := &submissionListing{}
+	if err := json.Unmarshal(res.Data, parsed); err != nil {
+		return Submission{}, false, err
+	}
+
+	submissions := parsed.Submissions()
+	if len(submissions) == 0 {
+		return Submission{}, false, nil
+	}
+	return submissions[0], true, nil
+}
+
 // WikiPage returns the content of the page of the wiki of a subreddit.
 func (ra *RedditAPI) WikiPage(ctx context.Context, sub, page string) (string, error) {
 	relativeURL := &url.URL{Path: "/r/" + sub + "/wiki/" + page}
@@ -245,9 +810,88 @@ func (ra *RedditAPI) WikiPage(ctx context.Context, sub, page string) (string, er
 	return parsed.Data.ContentMD, nil
 }
 
-func (ra *RedditAPI) getListing(ctx context.Context, path, position string, nb uint) ([]Comment, string, int, error) {
+// Submit posts a text submission to a subreddit and returns its ID.
+// The text can't be longer than RedditMaxSelfTextLength.
+func (ra *RedditAPI) Submit(ctx context.Context, sub, title, text string) (string, error) {
+	form := url.Values{
+		"api_type":    {"json"},
+		"kind":        {"self"},
+		"resubmit":    {"true"},
+		"sendreplies": {"false"},
+		"sr":          {sub},
+		"text":        {text},
+		"title":       {title},
+	}
+	parsed, err := ra.post(ctx, "/api/submit", form)
+	if err != nil {
+		return "", err
+	}
+	return parsed.JSON.Data.ID, nil
+}
+
+// EditSubmission replaces the text of a text submission made by the account, from its ID.
+func (ra *RedditAPI) EditSubmission(ctx context.Context, id, text string) error {
+	form := url.Values{
+		"api_type": {"json"},
+		"text":     {text},
+		"thing_id": {"t3_" + id},
+	}
+	_, err := ra.post(ctx, "/api/editusertext", form)
+	return err
+}
+
+// EditWikiPage replaces the content of the page of the wiki of a subreddit, or creates the page,
+// with a reason that is shown in the history of its revisions.
+// The content can't be longer than RedditMaxWikiPageLength.
+func (ra *RedditAPI) EditWikiPage(ctx context.Context, sub, page, content, reason string) error {
+	form := url.Values{
+		"content": {content},
+		"page":    {page},
+		"reason":  {reason},
+	}
+	_, err := ra.post(ctx, "/r/"+sub+"/api/wiki/edit", form)
+	return err
+}
+
+func (ra *RedditAPI) post(ctx context.Context, path string, form url.Values) (redditPostResponse, error) {
+	var parsed redditPostResponse
+
+	if ra.appOnly {
+		return parsed, fmt.Errorf("can't post to %s in application-only mode", path)
+	}
+
+	// The body of the request is consumed by each attempt, so it is created every time.
+	makeReq := func() (*http.Request, error) {
+		fullURL := ra.baseURL.ResolveReference(&url.URL{Path: path, RawQuery: "raw_json=1"})
+		req, err := http.NewRequest("POST", fullURL.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	}
+
+	res := ra.rawRequest(ctx, makeReq)
+	if res.Error != nil {
+		return parsed, res.Error
+	}
+	if res.Status != 200 {
+		return parsed, fmt.Errorf("bad response status when posting to %s: %d", path, res.Status)
+	}
+
+	if err := json.Unmarshal(res.Data, &parsed); err != nil {
+		return parsed, err
+	}
+
+	return parsed, parsed.Err()
+}
+
+func (ra *RedditAPI) getListing(ctx context.Context, path, sort, period, position string, nb uint, parsed redditListing) (string, int, error) {
 	query := url.Values{}
-	query.Set("sort", "new")
+	query.Set("sort", sort)
+	if period != "" {
+		query.Set("t", period)
+	}
 	query.Set("limit", fmt.Sprintf("%d", nb))
 	if position != "" {
 		query.Set("after", position)
@@ -255,39 +899,23 @@ func (ra *RedditAPI) getListing(ctx context.Context, path, position string, nb u
 
 	res := ra.request(ctx, "GET", &url.URL{Path: path, RawQuery: query.Encode()}, nil)
 	if res.Error != nil {
-		return nil, position, res.Status, res.Error
+		return position, res.Status, res.Error
 	}
 
 	if (res.Status == 403 || res.Status == 404) && strings.HasPrefix(path, "/u/") {
-		return nil, position, res.Status, res.Error
+		return position, res.Status, res.Error
 	}
 
 	if res.Status != 200 {
 		err := fmt.Errorf("bad response status when fetching the listing %s: %d", path, res.Status)
-		return nil, position, res.Status, err
+		return position, res.Status, err
 	}
 
-	parsed := &commentListing{}
 	if err := json.Unmarshal(res.Data, parsed); err != nil {
-		return nil, position, res.Status, err
-	}
-
-	comments := make([]Comment, 0, len(parsed.Data.Children))
-	for _, child := range parsed.Data.Children {
-		comment := Comment{
-			ID:        child.Data.ID,
-			Author:    child.Data.Author,
-			Score:     child.Data.Score,
-			Permalink: child.Data.Permalink,
-			Sub:       child.Data.Subreddit,
-			Created:   time.Unix(int64(child.Data.CreatedUTC), 0),
-			Body:      child.Data.Body,
-		}
-		comments = append(comments, comment)
+		return position, res.Status, err
 	}
 
-	newPosition := parsed.Data.After
-	return comments, newPosition, res.Status, nil
+	return parsed.after(), res.Status, nil
 }
 
 // Never pass nil as the URL, it can't deal with it. The data argument can be nil though.
@@ -299,7 +927,7 @@ func (ra *RedditAPI) request(ctx context.Context, verb string, relativeURL *url.
 		}
 		query.Set("raw_json", "1")
 		relativeURL.RawQuery = query.Encode()
-		fullURL := requestBaseURL.ResolveReference(relativeURL)
+		fullURL := ra.baseURL.ResolveReference(relativeURL)
 		return http.NewRequest(verb, fullURL.String(), data)
 	}
 	return ra.rawRequest(ctx, makeReq)
@@ -307,13 +935,44 @@ func (ra *RedditAPI) request(ctx context.Context, verb string, relativeURL *url.
 
 // Why take a closure instead of a request object directly?
 // Request objects are single use, and this method will automatically retry if
-// we are not authenticated anymore.
+// we are not authenticated anymore or if Reddit throttled us.
 func (ra *RedditAPI) rawRequest(ctx context.Context, makeReq func() (*http.Request, error)) redditResponse {
-	select {
-	case <-ra.ticker.C:
-		break
-	case <-ctx.Done():
-		return redditResponse{Error: ctx.Err()}
+	for reconnections := 0; ; reconnections++ {
+		token, err := ra.validToken(ctx)
+		if err != nil {
+			return redditResponse{Error: err}
+		}
+
+		res := ra.limitedRequest(ctx, makeReq, token)
+		for retries := 0; res.Error == nil && res.Status == 429 && retries < redditAPIMaxThrottledRetries; retries++ {
+			// The rate limiter already knows how long to wait.
+			res = ra.limitedRequest(ctx, makeReq, token)
+		}
+
+		if res.Error == nil && res.Status == 429 {
+			res.Error = fmt.Errorf("still throttled by Reddit after %d retries", redditAPIMaxThrottledRetries)
+			return res
+		}
+
+		if res.Error != nil || res.Status != 401 {
+			return res
+		}
+
+		if reconnections == redditAPIMaxReconnections {
+			res.Error = fmt.Errorf("still unauthorized by Reddit after %d reconnections", redditAPIMaxReconnections)
+			return res
+		}
+
+		if err := ra.reconnect(ctx, token, "unauthorized"); err != nil {
+			res.Error = err
+			return res
+		}
+	}
+}
+
+func (ra *RedditAPI) limitedRequest(ctx context.Context, makeReq func() (*http.Request, error), token string) redditResponse {
+	if err := ra.limiter.wait(ctx); err != nil {
+		return redditResponse{Error: err}
 	}
 
 	req, err := makeReq()
@@ -321,8 +980,8 @@ func (ra *RedditAPI) rawRequest(ctx context.Context, makeReq func() (*http.Reque
 		return redditResponse{Error: err}
 	}
 
-	ra.prepareRequest(ctx, req)
-	rawRes, err := ra.client.Do(req)
+	req = ra.prepareRequest(ctx, req, token)
+	rawRes, err := ra.do(req)
 	if err != nil {
 		if ctx.Err() != nil {
 			err = ctx.Err()
@@ -334,29 +993,42 @@ func (ra *RedditAPI) rawRequest(ctx context.Context, makeReq func() (*http.Reque
 	rawData, err := ioutil.ReadAll(rawRes.Body)
 	rawRes.Body.Close()
 
-	res := redditResponse{
+	ra.limiter.update(rawRes.Header)
+	if rawRes.StatusCode == 429 {
+		retryAfter, ok := parseRetryAfter(rawRes.Header.Get("Retry-After"))
+		if !ok {
+			retryAfter = redditAPIDefaultRetryAfter
+		}
+		ra.limiter.throttle(retryAfter)
+	}
+
+	return redditResponse{
 		Status: rawRes.StatusCode,
 		Data:   rawData,
 		Error:  err,
 	}
-
-	if res.Status == 401 {
-		if err := ra.Connect(ctx); err != nil {
-			res.Error = err
-			return res
-		}
-		return ra.rawRequest(ctx, makeReq)
-	}
-
-	return res
 }
 
-func (ra *RedditAPI) prepareRequest(ctx context.Context, req *http.Request) *http.Request {
+func (ra *RedditAPI) prepareRequest(ctx context.Context, req *http.Request, token string) *http.Request {
 	req.Header.Set("User-Agent", ra.userAgent)
-	req.Header.Set("Authorization", "bearer "+ra.oAuth.Token)
+	req.Header.Set("Authorization", "bearer "+token)
 	return req.WithContext(ctx)
 }
 
 func (ra *RedditAPI) do(req *http.Request) (*http.Response, error) {
-	return ra.client.Do(req)
+	res, err := ra.client.Do(req)
+	if err != nil && ra.auth.Proxy != "" && isProxyError(err) {
+		// Don't leak the proxy's credentials in the logs.
+		proxy, _ := url.Parse(ra.auth.Proxy)
+		return nil, fmt.Errorf("proxy %q of account %q failed: %v", proxy.Redacted(), ra.auth.Name(), err)
+	}
+	return res, err
+}
+
+func isProxyError(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	return opErr.Op == "proxyconnect" || strings.HasPrefix(opErr.Op, "socks")
 }
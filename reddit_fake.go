@@ -0,0 +1,893 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fakeRedditListingCap      = 1000
+	fakeRedditRateLimit       = 100000
+	fakeRedditRateLimitPeriod = 10 * time.Minute
+	fakeRedditTimeout         = 10 * time.Second
+	fakeRedditTokenTimeout    = time.Hour
+)
+
+// Lengths of the periods the listings sorted by score can be restricted to; the others are over all time.
+var fakeRedditPeriods = map[string]time.Duration{
+	"hour":  time.Hour,
+	"day":   24 * time.Hour,
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+}
+
+// FakeReddit is an HTTP server that imitates the parts of Reddit's API that the application uses,
+// so that it can be tested end-to-end or be run without a connection to Reddit.
+// It implements the endpoints to get an access token for a user or for the application only, to get data about a user and their comments and submissions,
+// to get data about comments and submissions from their IDs, to get the newest comments of a subreddit,
+// to read pages of the wiki of a subreddit from a directory or from their edits, to edit them,
+// and to submit and edit text submissions.
+// Use FakeRedditAPIConf to make a RedditAPI use it.
+type FakeReddit struct {
+	sync.Mutex
+	accounts     map[string]string // Name of the account of each token
+	listingCap   int               // Maximum number of items that can be reached in a listing
+	nbTokens     uint
+	posts        []Submission // Submissions made through the API, from the oldest to the newest
+	rejectTokens bool
+	requests     map[string]int // Number of requests received for each path
+	tokenTimeout time.Duration
+	tokens       map[string]time.Time // Expiry of each token
+	used         int
+	users        map[string]*fakeRedditUser
+	wikiDir      string
+	wikiEdits    map[string][]fakeRedditWikiRevision // Revisions of the pages edited through the API, by <sub>/<page>
+	windowEnd    time.Time
+}
+
+type fakeRedditWikiRevision struct {
+	content string
+	date    time.Time
+	reason  string
+}
+
+type fakeRedditUser struct {
+	comments    []Comment // From newest to oldest
+	created     time.Time
+	name        string
+	submissions []Submission // From newest to oldest
+	suspended   bool
+}
+
+// NewFakeReddit creates a FakeReddit without any user.
+// Wiki pages are read from wikiDir, at <sub>/<page>.md; leave empty to not serve any wiki page.
+// Pages edited through the API are kept in memory and take precedence over those files.
+func NewFakeReddit(wikiDir string) *FakeReddit {
+	return &FakeReddit{
+		accounts:     make(map[string]string),
+		listingCap:   fakeRedditListingCap,
+		requests:     make(map[string]int),
+		tokenTimeout: fakeRedditTokenTimeout,
+		tokens:       make(map[string]time.Time),
+		users:        make(map[string]*fakeRedditUser),
+		wikiDir:      wikiDir,
+		wikiEdits:    make(map[string][]fakeRedditWikiRevision),
+	}
+}
+
+// FakeRedditAPIConf returns the configuration for a RedditAPI to use a FakeReddit listening at the given address.
+func FakeRedditAPIConf(addr net.Addr) RedditAPIConf {
+	return RedditAPIConf{
+		AccessTokenURL: "http://" + addr.String() + "/api/v1/access_token",
+		APIURL:         "http://" + addr.String(),
+		Timeout:        Duration{Value: fakeRedditTimeout},
+	}
+}
+
+// Serve is a Task that answers requests made to the listener until it is cancelled.
+// It can be called several times with different listeners, even concurrently.
+func (fr *FakeReddit) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{Handler: fr}
+	tasks := NewTaskGroup(ctx)
+	tasks.SpawnCtx(func(_ context.Context) error {
+		err := server.Serve(listener)
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return err
+	})
+	tasks.SpawnCtx(func(ctx context.Context) error {
+		<-ctx.Done()
+		return server.Shutdown(context.Background())
+	})
+	return tasks.Wait().ToError()
+}
+
+// SetTokenTimeout changes the lifetime of the access tokens that are created from now on.
+func (fr *FakeReddit) SetTokenTimeout(timeout time.Duration) {
+	fr.Lock()
+	defer fr.Unlock()
+	fr.tokenTimeout = timeout
+}
+
+// SetListingCap changes the maximum number of items that can be reached by going through a listing,
+// which is 1000 on Reddit.
+func (fr *FakeReddit) SetListingCap(listingCap int) {
+	fr.Lock()
+	defer fr.Unlock()
+	fr.listingCap = listingCap
+}
+
+// RejectTokens makes the FakeReddit answer all requests with a 401 status even with a valid access token,
+// like Reddit sometimes does during outages.
+func (fr *FakeReddit) RejectTokens(reject bool) {
+	fr.Lock()
+	defer fr.Unlock()
+	fr.rejectTokens = reject
+}
+
+// Requests returns the number of requests received for a path, including those that were rejected.
+func (fr *FakeReddit) Requests(path string) int {
+	fr.Lock()
+	defer fr.Unlock()
+	return fr.requests[path]
+}
+
+// AddUser creates a user, or resets its status if it already exists.
+func (fr *FakeReddit) AddUser(name string, created time.Time) {
+	fr.Lock()
+	defer fr.Unlock()
+	if user, ok := fr.users[strings.ToLower(name)]; ok {
+		user.suspended = false
+		return
+	}
+	fr.users[strings.ToLower(name)] = &fakeRedditUser{created: created, name: name}
+}
+
+// DeleteUser deletes a user and its comments, as if the account had been deleted.
+func (fr *FakeReddit) DeleteUser(name string) {
+	fr.Lock()
+	defer fr.Unlock()
+	delete(fr.users, strings.ToLower(name))
+}
+
+// SuspendUser changes whether a user is suspended.
+func (fr *FakeReddit) SuspendUser(name string, suspended bool) {
+	fr.Lock()
+	defer fr.Unlock()
+	if user, ok := fr.users[strings.ToLower(name)]; ok {
+		user.suspended = suspended
+	}
+}
+
+// RenameUser changes the capitalization of the name of a user, and of the author of its comments and submissions.
+func (fr *FakeReddit) RenameUser(name string) {
+	fr.Lock()
+	defer fr.Unlock()
+	user, ok := fr.users[strings.ToLower(name)]
+	if !ok {
+		return
+	}
+	user.name = name
+	for i := range user.comments {
+		user.comments[i].Author = name
+	}
+	for i := range user.submissions {
+		user.submissions[i].Author = name
+	}
+}
+
+// AddComments adds comments to their authors, which must have been added first.
+// Comments with an ID that already exists replace the previous version.
+func (fr *FakeReddit) AddComments(comments ...Comment) error {
+	fr.Lock()
+	defer fr.Unlock()
+	for _, comment := range comments {
+		user, ok := fr.users[strings.ToLower(comment.Author)]
+		if !ok {
+			return fmt.Errorf("author %q of comment %q doesn't exist on the fake Reddit", comment.Author, comment.ID)
+		}
+		replaced := false
+		for i := range user.comments {
+			if user.comments[i].ID == comment.ID {
+				user.comments[i] = comment
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			user.comments = append(user.comments, comment)
+		}
+		sort.SliceStable(user.comments, func(i, j int) bool {
+			return user.comments[i].Created.After(user.comments[j].Created)
+		})
+	}
+	return nil
+}
+
+// AddSubmissions adds submissions to their authors, which must have been added first.
+// Submissions with an ID that already exists replace the previous version.
+func (fr *FakeReddit) AddSubmissions(submissions ...Submission) error {
+	fr.Lock()
+	defer fr.Unlock()
+	for _, submission := range submissions {
+		user, ok := fr.users[strings.ToLower(submission.Author)]
+		if !ok {
+			return fmt.Errorf("author %q of submission %q doesn't exist on the fake Reddit", submission.Author, submission.ID)
+		}
+		replaced := false
+		for i := range user.submissions {
+			if user.submissions[i].ID == submission.ID {
+				user.submissions[i] = submission
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			user.submissions = append(user.submissions, submission)
+		}
+		sort.SliceStable(user.submissions, func(i, j int) bool {
+			return user.submissions[i].Created.After(user.submissions[j].Created)
+		})
+	}
+	return nil
+}
+
+// Posts returns the submissions made through the API, from the oldest to the newest.
+func (fr *FakeReddit) Posts() []Submission {
+	fr.Lock()
+	defer fr.Unlock()
+	return append([]Submission(nil), fr.posts...)
+}
+
+// WikiRevisions returns the contents of the page of the wiki of a subreddit edited through the API,
+// and the reasons given for them, from the oldest to the newest revision.
+func (fr *FakeReddit) WikiRevisions(sub, page string) ([]string, []string) {
+	fr.Lock()
+	defer fr.Unlock()
+	revisions := fr.wikiEdits[sub+"/"+page]
+	contents := make([]string, 0, len(revisions))
+	reasons := make([]string, 0, len(revisions))
+	for _, revision := range revisions {
+		contents = append(contents, revision.content)
+		reasons = append(reasons, revision.reason)
+	}
+	return contents, reasons
+}
+
+// Populate adds nbUsers users with about nbComments comments each and a tenth as many submissions,
+// deterministically generated from seed.
+// It returns the names of the users.
+func (fr *FakeReddit) Populate(seed int64, nbUsers, nbComments uint) ([]string, error) {
+	random := rand.New(rand.NewSource(seed))
+	subs := []string{"AskReddit", "europe", "news", "pics", "politics", "science", "worldnews"}
+	bodies := []string{
+		"I don't think that's how any of this works.",
+		"Source?",
+		"Actually, **you** are the one who is wrong here.",
+		"This is the worst take I've read all week.",
+		"Nice try, but I'm not falling for that.",
+		"Imagine believing that in this day and age.",
+		"> citation needed\n\nI'll just leave this here.",
+	}
+	titles := []string{
+		"Unpopular opinion: everyone else is wrong",
+		"Why does nobody talk about this?",
+		"I made this",
+		"Change my mind",
+		"This sub has gone downhill",
+	}
+
+	now := time.Now()
+	names := make([]string, 0, nbUsers)
+	id := int64(36 * 36 * 36 * 36)
+	for i := uint(0); i < nbUsers; i++ {
+		name := fmt.Sprintf("DemoUser%02d", i+1)
+		created := now.Add(-time.Duration(random.Intn(10*365*24)+24*30) * time.Hour)
+		fr.AddUser(name, created)
+		names = append(names, name)
+
+		// Some users downvoted much more than others.
+		scale := random.Intn(1000) + 10
+		comments := make([]Comment, 0, nbComments)
+		for j := uint(0); j < nbComments; j++ {
+			id++
+			sub := subs[random.Intn(len(subs))]
+			comment := Comment{
+				ID:      strconv.FormatInt(id, 36),
+				Author:  name,
+				Score:   int64(random.Intn(scale+50) - scale),
+				Sub:     sub,
+				Created: now.Add(-time.Duration(random.Intn(60*24*60)) * time.Minute).Round(time.Second),
+				Body:    bodies[random.Intn(len(bodies))],
+			}
+			comment.Permalink = fmt.Sprintf("/r/%s/comments/%s/demo/%s/", sub, strconv.FormatInt(id/10, 36), comment.ID)
+			comments = append(comments, comment)
+		}
+		if err := fr.AddComments(comments...); err != nil {
+			return nil, err
+		}
+
+		submissions := make([]Submission, 0, nbComments/10)
+		for j := uint(0); j < nbComments/10; j++ {
+			id++
+			sub := subs[random.Intn(len(subs))]
+			submission := Submission{
+				Comment: Comment{
+					ID:      strconv.FormatInt(id, 36),
+					Author:  name,
+					Score:   int64(random.Intn(2*scale+50) - 2*scale),
+					Sub:     sub,
+					Created: now.Add(-time.Duration(random.Intn(60*24*60)) * time.Minute).Round(time.Second),
+				},
+				Title: titles[random.Intn(len(titles))],
+			}
+			submission.Permalink = fmt.Sprintf("/r/%s/comments/%s/demo/", sub, submission.ID)
+			if random.Intn(2) == 0 {
+				submission.Body = bodies[random.Intn(len(bodies))]
+				submission.URL = "https://www.reddit.com" + submission.Permalink
+			} else {
+				submission.URL = fmt.Sprintf("https://example.org/%s", submission.ID)
+			}
+			submissions = append(submissions, submission)
+		}
+		if err := fr.AddSubmissions(submissions...); err != nil {
+			return nil, err
+		}
+	}
+
+	return names, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (fr *FakeReddit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fr.Lock()
+	defer fr.Unlock()
+
+	fr.requests[r.URL.Path]++
+	fr.setRateLimitHeaders(w.Header())
+
+	if r.URL.Path == "/api/v1/access_token" {
+		fr.accessToken(w, r)
+		return
+	}
+
+	account, ok := fr.authorize(r)
+	if !ok {
+		fr.error(w, http.StatusUnauthorized)
+		return
+	}
+
+	// Tokens of the application-only mode aren't associated with an account and can't be used to write.
+	if r.Method == "POST" && account == "" {
+		fr.error(w, http.StatusForbidden)
+		return
+	} else if r.Method == "POST" && r.URL.Path == "/api/submit" {
+		fr.submit(w, r, account)
+		return
+	} else if r.Method == "POST" && r.URL.Path == "/api/editusertext" {
+		fr.editUserText(w, r, account)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if r.Method == "POST" && len(parts) >= 5 && parts[0] == "r" && parts[2] == "api" && parts[3] == "wiki" && parts[4] == "edit" {
+		fr.editWikiPage(w, r, parts[1])
+		return
+	} else if r.Method != "GET" {
+		fr.error(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if r.URL.Path == "/api/info" {
+		fr.info(w, r)
+	} else if len(parts) == 3 && (parts[0] == "u" || parts[0] == "user") && parts[2] == "about" {
+		fr.about(w, parts[1])
+	} else if len(parts) == 3 && (parts[0] == "u" || parts[0] == "user") && parts[2] == "comments" {
+		fr.userListing(w, r, parts[1], false)
+	} else if len(parts) == 3 && (parts[0] == "u" || parts[0] == "user") && parts[2] == "submitted" {
+		fr.userListing(w, r, parts[1], true)
+	} else if len(parts) == 3 && parts[0] == "r" && parts[2] == "comments" {
+		fr.subListing(w, r, parts[1])
+	} else if len(parts) >= 4 && parts[0] == "r" && parts[2] == "wiki" {
+		fr.wikiPage(w, parts[1], strings.Join(parts[3:], "/"))
+	} else {
+		fr.error(w, http.StatusNotFound)
+	}
+}
+
+func (fr *FakeReddit) accessToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		fr.error(w, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if id, secret, ok := r.BasicAuth(); !ok || id == "" || secret == "" {
+		fr.error(w, http.StatusUnauthorized)
+		return
+	}
+
+	form, err := fr.readForm(r)
+	if err != nil {
+		fr.error(w, http.StatusBadRequest)
+		return
+	}
+
+	grantType := form.Get("grant_type")
+	if grantType == "password" && (form.Get("username") == "" || form.Get("password") == "") {
+		fr.writeJSON(w, http.StatusOK, map[string]interface{}{"error": "invalid_grant"})
+		return
+	} else if grantType != "password" && grantType != "client_credentials" {
+		fr.writeJSON(w, http.StatusOK, map[string]interface{}{"error": "unsupported_grant_type"})
+		return
+	}
+
+	fr.nbTokens++
+	token := fmt.Sprintf("fake-token-%d", fr.nbTokens)
+	fr.tokens[token] = time.Now().Add(fr.tokenTimeout)
+	fr.accounts[token] = form.Get("username")
+	fr.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": token,
+		"expires_in":   int(fr.tokenTimeout.Seconds()),
+		"scope":        "*",
+		"token_type":   "bearer",
+	})
+}
+
+// authorize returns the name of the account that made the request, and whether its token is valid.
+func (fr *FakeReddit) authorize(r *http.Request) (string, bool) {
+	fields := strings.Fields(r.Header.Get("Authorization"))
+	if len(fields) != 2 || strings.ToLower(fields[0]) != "bearer" {
+		return "", false
+	}
+	expiry, ok := fr.tokens[fields[1]]
+	return fr.accounts[fields[1]], ok && !fr.rejectTokens && time.Now().Before(expiry)
+}
+
+// Like Reddit, don't require the content type of forms to be set.
+func (fr *FakeReddit) readForm(r *http.Request) (url.Values, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return url.ParseQuery(string(body))
+}
+
+func (fr *FakeReddit) about(w http.ResponseWriter, name string) {
+	user, ok := fr.users[strings.ToLower(name)]
+	if !ok {
+		fr.error(w, http.StatusNotFound)
+		return
+	}
+
+	// Reddit doesn't give anything other than the name of suspended users.
+	data := map[string]interface{}{"name": user.name, "is_suspended": user.suspended}
+	if !user.suspended {
+		// The karma of an account isn't exactly the sum of its scores on Reddit, but it's close enough.
+		var commentKarma, linkKarma int64
+		for _, comment := range user.comments {
+			commentKarma += comment.Score
+		}
+		for _, submission := range user.submissions {
+			linkKarma += submission.Score
+		}
+		data["created_utc"] = float64(user.created.Unix())
+		data["comment_karma"] = commentKarma
+		data["link_karma"] = linkKarma
+		data["is_employee"] = false
+		data["is_mod"] = false
+		data["verified"] = true
+	}
+	fr.writeJSON(w, http.StatusOK, map[string]interface{}{"kind": "t2", "data": data})
+}
+
+func (fr *FakeReddit) userListing(w http.ResponseWriter, r *http.Request, name string, submissions bool) {
+	user, ok := fr.users[strings.ToLower(name)]
+	if !ok {
+		fr.error(w, http.StatusNotFound)
+		return
+	} else if user.suspended {
+		fr.error(w, http.StatusForbidden)
+		return
+	}
+
+	order := r.URL.Query().Get("sort")
+	period, hasPeriod := fakeRedditPeriods[r.URL.Query().Get("t")]
+	hasPeriod = hasPeriod && (order == "top" || order == "controversial")
+
+	// There are no votes, so the controversial items are approximated by the lowest scores.
+	var things []fakeRedditThing
+	var scores []int64
+	if submissions {
+		for _, submission := range user.submissions {
+			if hasPeriod && time.Since(submission.Created) > period {
+				continue
+			}
+			things = append(things, fakeSubmissionThing(submission))
+			scores = append(scores, submission.Score)
+		}
+	} else {
+		for _, comment := range user.comments {
+			if hasPeriod && time.Since(comment.Created) > period {
+				continue
+			}
+			things = append(things, fakeCommentThing(comment))
+			scores = append(scores, comment.Score)
+		}
+	}
+	if order == "top" || order == "controversial" {
+		indexes := make([]int, len(things))
+		for i := range indexes {
+			indexes[i] = i
+		}
+		sort.SliceStable(indexes, func(i, j int) bool {
+			if order == "top" {
+				return scores[indexes[i]] > scores[indexes[j]]
+			}
+			return scores[indexes[i]] < scores[indexes[j]]
+		})
+		sorted := make([]fakeRedditThing, 0, len(things))
+		for _, i := range indexes {
+			sorted = append(sorted, things[i])
+		}
+		things = sorted
+	}
+	fr.writePagedListing(w, r, things)
+}
+
+func (fr *FakeReddit) subListing(w http.ResponseWriter, r *http.Request, sub string) {
+	var comments []Comment
+	for _, user := range fr.users {
+		for _, comment := range user.comments {
+			if strings.EqualFold(comment.Sub, sub) {
+				comments = append(comments, comment)
+			}
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		if comments[i].Created.Equal(comments[j].Created) {
+			return comments[i].ID > comments[j].ID
+		}
+		return comments[i].Created.After(comments[j].Created)
+	})
+
+	things := make([]fakeRedditThing, 0, len(comments))
+	for _, comment := range comments {
+		things = append(things, fakeCommentThing(comment))
+	}
+	fr.writePagedListing(w, r, things)
+}
+
+// writePagedListing writes the page of a listing selected by the parameters "limit" and "after" of the request.
+func (fr *FakeReddit) writePagedListing(w http.ResponseWriter, r *http.Request, things []fakeRedditThing) {
+	if len(things) > fr.listingCap {
+		things = things[:fr.listingCap]
+	}
+
+	limit := 25
+	if value, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && value > 0 {
+		limit = value
+	}
+	if limit > MaxRedditListingLength {
+		limit = MaxRedditListingLength
+	}
+
+	start := 0
+	if after := r.URL.Query().Get("after"); after != "" {
+		start = len(things)
+		for i, thing := range things {
+			if thing.name() == after {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	end := start + limit
+	if end > len(things) {
+		end = len(things)
+	}
+
+	var after string
+	if end < len(things) && end > start {
+		after = things[end-1].name()
+	}
+
+	fr.writeListing(w, things[start:end], after)
+}
+
+func (fr *FakeReddit) info(w http.ResponseWriter, r *http.Request) {
+	fullIDs := strings.Split(r.URL.Query().Get("id"), ",")
+	if len(fullIDs) > MaxRedditListingLength {
+		fr.error(w, http.StatusBadRequest)
+		return
+	}
+
+	var things []fakeRedditThing
+	for _, fullID := range fullIDs {
+		if strings.HasPrefix(fullID, "t1_") {
+			if comment, ok := fr.findComment(strings.TrimPrefix(fullID, "t1_")); ok {
+				things = append(things, fakeCommentThing(comment))
+			}
+		} else if strings.HasPrefix(fullID, "t3_") {
+			if submission, ok := fr.findSubmission(strings.TrimPrefix(fullID, "t3_")); ok {
+				things = append(things, fakeSubmissionThing(submission))
+			}
+		}
+	}
+
+	fr.writeListing(w, things, "")
+}
+
+func (fr *FakeReddit) findComment(id string) (Comment, bool) {
+	for _, user := range fr.users {
+		for _, comment := range user.comments {
+			if comment.ID == id {
+				return comment, true
+			}
+		}
+	}
+	return Comment{}, false
+}
+
+func (fr *FakeReddit) findSubmission(id string) (Submission, bool) {
+	for _, post := range fr.posts {
+		if post.ID == id {
+			return post, true
+		}
+	}
+	for _, user := range fr.users {
+		for _, submission := range user.submissions {
+			if submission.ID == id {
+				return submission, true
+			}
+		}
+	}
+	return Submission{}, false
+}
+
+func (fr *FakeReddit) writeListing(w http.ResponseWriter, things []fakeRedditThing, after string) {
+	if things == nil {
+		things = []fakeRedditThing{}
+	}
+
+	// Reddit uses null instead of an empty string.
+	var rawAfter interface{}
+	if after != "" {
+		rawAfter = after
+	}
+
+	fr.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"kind": "Listing",
+		"data": map[string]interface{}{"after": rawAfter, "children": things},
+	})
+}
+
+func (fr *FakeReddit) wikiPage(w http.ResponseWriter, sub, page string) {
+	if revisions := fr.wikiEdits[sub+"/"+page]; len(revisions) > 0 {
+		last := revisions[len(revisions)-1]
+		fr.writeJSON(w, http.StatusOK, map[string]interface{}{
+			"kind": "wikipage",
+			"data": map[string]interface{}{
+				"content_md":    last.content,
+				"revision_date": float64(last.date.Unix()),
+			},
+		})
+		return
+	}
+
+	// Make sure the path can't go outside of the wiki's directory.
+	cleaned := path.Clean("/" + sub + "/" + page)
+	if fr.wikiDir == "" || cleaned != "/"+sub+"/"+page {
+		fr.error(w, http.StatusNotFound)
+		return
+	}
+
+	filePath := filepath.Join(fr.wikiDir, filepath.FromSlash(cleaned)+".md")
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		fr.error(w, http.StatusNotFound)
+		return
+	}
+
+	content, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		fr.error(w, http.StatusInternalServerError)
+		return
+	}
+
+	fr.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"kind": "wikipage",
+		"data": map[string]interface{}{
+			"content_md":    string(content),
+			"revision_date": float64(stat.ModTime().Unix()),
+		},
+	})
+}
+
+func (fr *FakeReddit) editWikiPage(w http.ResponseWriter, r *http.Request, sub string) {
+	form, err := fr.readForm(r)
+	if err != nil {
+		fr.error(w, http.StatusBadRequest)
+		return
+	}
+
+	page := form.Get("page")
+	if page == "" || path.Clean("/"+page) != "/"+page {
+		fr.writeJSON(w, http.StatusBadRequest, map[string]interface{}{"reason": "INVALID_PAGE_NAME"})
+		return
+	} else if len(form.Get("content")) > RedditMaxWikiPageLength {
+		fr.writeJSON(w, http.StatusRequestEntityTooLarge, map[string]interface{}{"reason": "PAGE_TOO_LARGE"})
+		return
+	}
+
+	key := sub + "/" + page
+	fr.wikiEdits[key] = append(fr.wikiEdits[key], fakeRedditWikiRevision{
+		content: form.Get("content"),
+		date:    time.Now(),
+		reason:  form.Get("reason"),
+	})
+	fr.writeJSON(w, http.StatusOK, map[string]interface{}{})
+}
+
+func (fr *FakeReddit) submit(w http.ResponseWriter, r *http.Request, account string) {
+	form, err := fr.readForm(r)
+	if err != nil {
+		fr.error(w, http.StatusBadRequest)
+		return
+	}
+
+	sub, title, text := form.Get("sr"), form.Get("title"), form.Get("text")
+	if form.Get("kind") != "self" {
+		fr.postErrors(w, "INVALID_OPTION", "only text submissions are supported", "kind")
+		return
+	} else if sub == "" {
+		fr.postErrors(w, "SUBREDDIT_REQUIRED", "you must specify a subreddit", "sr")
+		return
+	} else if title == "" {
+		fr.postErrors(w, "NO_TEXT", "we need something here", "title")
+		return
+	} else if len(text) > RedditMaxSelfTextLength {
+		fr.postErrors(w, "TOO_LONG", fmt.Sprintf("this is too long (max: %d)", RedditMaxSelfTextLength), "text")
+		return
+	}
+
+	id := fmt.Sprintf("post%d", len(fr.posts)+1)
+	permalink := fmt.Sprintf("/r/%s/comments/%s/_/", sub, id)
+	fr.posts = append(fr.posts, Submission{
+		Comment: Comment{
+			ID:        id,
+			Author:    account,
+			Permalink: permalink,
+			Sub:       sub,
+			Created:   time.Now(),
+			Body:      text,
+		},
+		Title: title,
+		URL:   "https://www.reddit.com" + permalink,
+	})
+
+	fr.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"json": map[string]interface{}{
+			"errors": []interface{}{},
+			"data":   map[string]interface{}{"id": id, "name": "t3_" + id, "url": "https://www.reddit.com" + permalink},
+		},
+	})
+}
+
+func (fr *FakeReddit) editUserText(w http.ResponseWriter, r *http.Request, account string) {
+	form, err := fr.readForm(r)
+	if err != nil {
+		fr.error(w, http.StatusBadRequest)
+		return
+	}
+
+	text := form.Get("text")
+	id := strings.TrimPrefix(form.Get("thing_id"), "t3_")
+	for i := range fr.posts {
+		if fr.posts[i].ID != id {
+			continue
+		}
+		if fr.posts[i].Author != account {
+			fr.error(w, http.StatusForbidden)
+		} else if len(text) > RedditMaxSelfTextLength {
+			fr.postErrors(w, "TOO_LONG", fmt.Sprintf("this is too long (max: %d)", RedditMaxSelfTextLength), "text")
+		} else {
+			fr.posts[i].Body = text
+			fr.writeJSON(w, http.StatusOK, map[string]interface{}{
+				"json": map[string]interface{}{
+					"errors": []interface{}{},
+					"data":   map[string]interface{}{"things": []interface{}{fakeSubmissionThing(fr.posts[i])}},
+				},
+			})
+		}
+		return
+	}
+	fr.postErrors(w, "NO_THING_ID", "can't find the thing to edit", "thing_id")
+}
+
+// Imitate how Reddit reports errors in the forms sent to its API, with a code, a message, and the field that caused it.
+func (fr *FakeReddit) postErrors(w http.ResponseWriter, code, message, field string) {
+	fr.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"json": map[string]interface{}{"errors": [][]string{{code, message, field}}},
+	})
+}
+
+// Imitate Reddit's headers about the rate limit, with a quota generous enough to not slow down tests.
+func (fr *FakeReddit) setRateLimitHeaders(header http.Header) {
+	now := time.Now()
+	if !now.Before(fr.windowEnd) {
+		fr.windowEnd = now.Add(fakeRedditRateLimitPeriod)
+		fr.used = 0
+	}
+	fr.used++
+	header.Set("X-Ratelimit-Used", strconv.Itoa(fr.used))
+	header.Set("X-Ratelimit-Remaining", fmt.Sprintf("%.1f", float64(fakeRedditRateLimit-fr.used)))
+	header.Set("X-Ratelimit-Reset", strconv.Itoa(int(fr.windowEnd.Sub(now).Seconds())))
+}
+
+func (fr *FakeReddit) error(w http.ResponseWriter, status int) {
+	fr.writeJSON(w, status, map[string]interface{}{"message": http.StatusText(status), "error": status})
+}
+
+func (fr *FakeReddit) writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// fakeRedditThing is an item of a listing, as encoded by Reddit.
+type fakeRedditThing map[string]interface{}
+
+func (thing fakeRedditThing) name() string {
+	return thing["data"].(map[string]interface{})["name"].(string)
+}
+
+func fakeCommentThing(comment Comment) fakeRedditThing {
+	return fakeRedditThing{
+		"kind": "t1",
+		"data": map[string]interface{}{
+			"author":      comment.Author,
+			"body":        comment.Body,
+			"created_utc": float64(comment.Created.Unix()),
+			"id":          comment.ID,
+			"name":        "t1_" + comment.ID,
+			"permalink":   comment.Permalink,
+			"score":       comment.Score,
+			"subreddit":   comment.Sub,
+		},
+	}
+}
+
+func fakeSubmissionThing(submission Submission) fakeRedditThing {
+	return fakeRedditThing{
+		"kind": "t3",
+		"data": map[string]interface{}{
+			"author":      submission.Author,
+			"created_utc": float64(submission.Created.Unix()),
+			"id":          submission.ID,
+			"name":        "t3_" + submission.ID,
+			"permalink":   submission.Permalink,
+			"score":       submission.Score,
+			"selftext":    submission.Body,
+			"subreddit":   submission.Sub,
+			"title":       submission.Title,
+			"url":         submission.URL,
+		},
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// newTestRedditAPI serves the FakeReddit until the end of the test and returns a RedditAPI connected to it.
+// newTestRedditAPI connects to the FakeReddit as the given user, or in application-only mode if username is empty.
+func newTestRedditAPI(t *testing.T, fr *FakeReddit, username string) *RedditAPI {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- fr.Serve(ctx, listener) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+
+	auth := RedditAuth{ID: "id", Password: "password", Secret: "secret", Username: username}
+	conf := FakeRedditAPIConf(listener.Addr())
+	if username == "" {
+		auth.Password = ""
+		conf.AppOnly = true
+	}
+	userAgent := template.Must(template.New("UserAgent").Parse("{{.OS}}:dab-test:v{{.Version}}"))
+	ra, err := NewRedditAPI(ctx, auth, userAgent, conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ra.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return ra
+}
+
+func TestFakeReddit(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fr := NewFakeReddit("testdata/wiki")
+	names, err := fr.Populate(1, 2, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr.AddUser("Suspended", time.Now())
+	fr.SuspendUser("Suspended", true)
+	ra := newTestRedditAPI(t, fr, "TestBot")
+
+	t.Run("about", func(t *testing.T) {
+		query := ra.AboutUser(ctx, strings.ToLower(names[0]))
+		if query.Error != nil {
+			t.Fatal(query.Error)
+		}
+		if !query.Exists || query.User.Name != names[0] {
+			t.Errorf("user %q should exist with its original capitalization, got %+v", names[0], query)
+		}
+
+		if query := ra.AboutUser(ctx, "Suspended"); query.Error != nil || !query.User.Suspended {
+			t.Errorf("user should be suspended, got %+v", query)
+		}
+
+		if query := ra.AboutUser(ctx, "NotAUser"); query.Error != nil || query.Exists {
+			t.Errorf("user shouldn't exist, got %+v", query)
+		}
+	})
+
+	t.Run("comments paging", func(t *testing.T) {
+		user := User{Name: names[1]}
+		seen := make(map[string]bool)
+		for i := 0; i < 3; i++ {
+			var comments []Comment
+			comments, user, err = ra.UserComments(ctx, user, MaxRedditListingLength)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, comment := range comments {
+				if seen[comment.ID] {
+					t.Errorf("comment %q has been returned twice", comment.ID)
+				}
+				seen[comment.ID] = true
+			}
+			if user.Position == "" {
+				break
+			}
+		}
+		if len(seen) != 150 {
+			t.Errorf("paging should have returned 150 comments, not %d", len(seen))
+		}
+		if user.Position != "" {
+			t.Errorf("the end of the listing should have been reached, but position is %q", user.Position)
+		}
+	})
+
+	t.Run("suspended comments", func(t *testing.T) {
+		_, user, err := ra.UserComments(ctx, User{Name: "Suspended"}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !user.Suspended {
+			t.Errorf("user should be detected as suspended, got %+v", user)
+		}
+	})
+
+	t.Run("wiki page", func(t *testing.T) {
+		content, err := ra.WikiPage(ctx, "downvoting", "compendium")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(content, "/u/AGreatUsername") {
+			t.Errorf("unexpected content of the wiki page: %q", content)
+		}
+
+		if _, err := ra.WikiPage(ctx, "downvoting", "../downvoting/compendium"); err == nil {
+			t.Error("wiki pages outside of the directory of the sub shouldn't be served")
+		}
+	})
+
+	t.Run("submit and edit", func(t *testing.T) {
+		id, err := ra.Submit(ctx, "test", "A title", "some text")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ra.EditSubmission(ctx, id, "edited text"); err != nil {
+			t.Fatal(err)
+		}
+
+		posts := fr.Posts()
+		if len(posts) != 1 || posts[0].ID != id || posts[0].Author != "TestBot" || posts[0].Body != "edited text" {
+			t.Errorf("unexpected submissions %+v", posts)
+		}
+
+		if err := ra.EditSubmission(ctx, "unknown", "text"); err == nil {
+			t.Error("editing a submission that doesn't exist should fail")
+		}
+		if _, err := ra.Submit(ctx, "test", "", "text"); err == nil {
+			t.Error("submitting without a title should fail")
+		}
+	})
+
+	t.Run("edit wiki page", func(t *testing.T) {
+		if err := ra.EditWikiPage(ctx, "test", "some/page", "new content", "a reason"); err != nil {
+			t.Fatal(err)
+		}
+		if content, err := ra.WikiPage(ctx, "test", "some/page"); err != nil {
+			t.Fatal(err)
+		} else if content != "new content" {
+			t.Errorf("the edited page should contain %q, got %q", "new content", content)
+		}
+		if contents, reasons := fr.WikiRevisions("test", "some/page"); len(contents) != 1 || reasons[0] != "a reason" {
+			t.Errorf("unexpected revisions %v with reasons %v", contents, reasons)
+		}
+
+		if err := ra.EditWikiPage(ctx, "test", "../page", "content", "a reason"); err == nil {
+			t.Error("editing a page with an invalid name should fail")
+		}
+	})
+
+	t.Run("application-only", func(t *testing.T) {
+		appOnly := newTestRedditAPI(t, fr, "")
+		if !appOnly.AppOnly() {
+			t.Fatal("the API should be in application-only mode")
+		}
+		if query := appOnly.AboutUser(ctx, names[0]); query.Error != nil || !query.Exists {
+			t.Errorf("the user should have been found without the credentials of a user, got %+v", query)
+		}
+		if _, err := appOnly.Submit(ctx, "test", "A title", "some text"); err == nil {
+			t.Error("submitting in application-only mode should fail")
+		}
+		if err := appOnly.EditWikiPage(ctx, "test", "page", "content", "a reason"); err == nil {
+			t.Error("editing a wiki page in application-only mode should fail")
+		}
+	})
+
+	t.Run("reconnection", func(t *testing.T) {
+		ra.Lock()
+		ra.oAuth.Token = "expired"
+		ra.Unlock()
+		if query := ra.AboutUser(ctx, names[0]); query.Error != nil || !query.Exists {
+			t.Errorf("the API should have reconnected and found the user, got %+v", query)
+		}
+	})
+}
+
+func TestRedditAPIReconnections(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fr := NewFakeReddit("")
+	fr.AddUser("AGreatUsername", time.Now())
+
+	t.Run("token refresh", func(t *testing.T) {
+		fr.SetTokenTimeout(redditAPITokenRefreshMargin / 2)
+		ra := newTestRedditAPI(t, fr, "TestBot1")
+		reconnections := ra.OpenReconnections()
+		defer ra.CloseReconnections()
+
+		if query := ra.AboutUser(ctx, "AGreatUsername"); query.Error != nil || !query.Exists {
+			t.Fatalf("user should have been found, got %+v", query)
+		}
+
+		select {
+		case reconnection := <-reconnections:
+			if reconnection.Error != nil || reconnection.Reason != "access token about to expire" {
+				t.Errorf("the access token should have been refreshed before expiring, got %s", reconnection)
+			}
+		default:
+			t.Error("the refresh of the access token should have been signaled")
+		}
+	})
+
+	t.Run("bounded retries", func(t *testing.T) {
+		fr.SetTokenTimeout(fakeRedditTokenTimeout)
+		ra := newTestRedditAPI(t, fr, "TestBot2")
+		reconnections := ra.OpenReconnections()
+		defer ra.CloseReconnections()
+
+		fr.RejectTokens(true)
+		defer fr.RejectTokens(false)
+
+		if query := ra.AboutUser(ctx, "AGreatUsername"); query.Error == nil {
+			t.Errorf("requests should fail while Reddit rejects tokens, got %+v", query)
+		}
+		if nb := len(reconnections); nb != redditAPIMaxReconnections {
+			t.Errorf("there should have been %d reconnections, not %d", redditAPIMaxReconnections, nb)
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Settings of the publication of reports on Reddit.
+const (
+	RedditReportsCheckInterval = 5 * time.Minute
+	RedditReportsPrefixPost    = "reddit-report_"
+	RedditReportsSuffixUpdate  = "_updated" // Of the key of the date of the last publication or update of the submission of a week
+	RedditReportsTruncated     = "\n\n*This report is too long to be posted in full.*"
+)
+
+// RedditReports is a component that publishes the report of each week as a submission on a subreddit,
+// and keeps it up to date for a while as the scores of the comments settle.
+type RedditReports struct {
+	api     *RedditAPI
+	logger  LevelLogger
+	posts   *KeyValueNamespace // IDs of the submissions of each week, and dates of their last update
+	reports ReportFactory
+
+	delay          time.Duration
+	Enabled        bool
+	sub            string
+	updateInterval time.Duration
+	updateMaxAge   time.Duration
+}
+
+// NewRedditReports creates a RedditReports.
+func NewRedditReports(logger LevelLogger, kv *KeyValueStore, api *RedditAPI, reports ReportFactory, conf RedditReportsConf) *RedditReports {
+	return &RedditReports{
+		api:     api,
+		logger:  logger,
+		posts:   kv.Namespace(RedditReportsPrefixPost, 0),
+		reports: reports,
+
+		delay:          conf.ReportDelay.Value,
+		Enabled:        conf.ReportSub != "" && !api.AppOnly(),
+		sub:            conf.ReportSub,
+		updateInterval: conf.ReportUpdateInterval.Value,
+		updateMaxAge:   conf.ReportUpdateMaxAge.Value,
+	}
+}
+
+// Publisher is a Task to be launched independently that publishes the report of the previous week
+// once the delay after the end of that week has passed, then edits it at regular intervals until the maximum age of the updates.
+// The date of the publication is saved before the submission is made and its ID after, so that a report is never published twice,
+// even after a restart or if its ID couldn't be saved, and the date of each update is saved along with it.
+// Like with the scanner, network errors are only logged.
+func (rr *RedditReports) Publisher(ctx context.Context, conn StorageConn) error {
+	rr.logger.Infof("publishing reports in /r/%s %s after the end of each week, updated every %s for %s",
+		rr.sub, rr.delay, rr.updateInterval, rr.updateMaxAge)
+
+	for {
+		if err := rr.publish(ctx, conn, time.Now()); err != nil {
+			return err
+		}
+		if !SleepCtx(ctx, RedditReportsCheckInterval) {
+			return ctx.Err()
+		}
+	}
+}
+
+func (rr *RedditReports) publish(ctx context.Context, conn StorageConn, now time.Time) error {
+	week, year := rr.reports.WeekCoordinates(now.AddDate(0, 0, -7))
+	_, end := rr.reports.WeekYearToDates(week, year)
+	due := end.Add(rr.delay)
+	if now.Before(due) {
+		return nil
+	}
+
+	key := fmt.Sprintf("%d-%02d", year, week)
+	ids, err := rr.posts.Get(conn, key)
+	if err != nil {
+		return err
+	} else if len(ids) > 1 {
+		rr.logger.Errorf("potential bug, only one submission should be associated with %q, found %d: %v; using the first one", key, len(ids), ids)
+	}
+	lastUpdate, published, err := rr.lastUpdate(conn, key)
+	if err != nil {
+		return err
+	} else if published && len(ids) == 0 {
+		rr.logger.Debugf("report of week %d of %d has been published without its ID being saved, not publishing it again", week, year)
+		return nil
+	} else if len(ids) > 0 && (!now.Before(due.Add(rr.updateMaxAge)) || now.Sub(lastUpdate) < rr.updateInterval) {
+		return nil
+	}
+
+	report, err := rr.reports.ReportWeek(conn, week, year)
+	if err != nil {
+		return err
+	} else if report.Len() == 0 && report.SubmissionsLen() == 0 {
+		rr.logger.Debugf("report of week %d of %d is empty, not publishing it", week, year)
+		return nil
+	}
+
+	var text strings.Builder
+	if err := MarkdownReport.Execute(&text, report); err != nil {
+		return err
+	}
+	content := truncateMarkdown(text.String(), RedditMaxSelfTextLength, RedditReportsTruncated)
+
+	if len(ids) == 0 {
+		return rr.submit(ctx, conn, key, week, year, content, now)
+	}
+
+	submission, exists, err := rr.api.SubmissionInfo(ctx, ids[0])
+	if IsCancellation(err) {
+		return err
+	} else if err != nil {
+		rr.logger.Errorf("error when fetching the report of week %d of %d with ID %q: %v", week, year, ids[0], err)
+		return nil
+	} else if !exists {
+		rr.logger.Errorf("the report of week %d of %d with ID %q doesn't exist anymore, not updating it", week, year, ids[0])
+		return rr.saveUpdate(conn, key, now)
+	}
+
+	if submission.Body != content {
+		if err := rr.api.EditSubmission(ctx, ids[0], content); err != nil {
+			if IsCancellation(err) {
+				return err
+			}
+			rr.logger.Errorf("error when updating the report of week %d of %d with ID %q: %v", week, year, ids[0], err)
+			return nil
+		}
+		rr.logger.Debugf("updated the report of week %d of %d with ID %q", week, year, ids[0])
+	}
+	return rr.saveUpdate(conn, key, now)
+}
+
+// submit publishes the report of a week. The date of the publication is saved first, and removed if it failed,
+// so that the report isn't published again if its ID can't be saved.
+func (rr *RedditReports) submit(ctx context.Context, conn StorageConn, key string, week uint8, year int, content string, now time.Time) error {
+	if err := rr.saveUpdate(conn, key, now); err != nil {
+		return err
+	}
+
+	title := fmt.Sprintf("Report of week %d of %d", week, year)
+	id, err := rr.api.Submit(ctx, rr.sub, title, content)
+	if err != nil {
+		if !IsCancellation(err) {
+			rr.logger.Errorf("error when publishing the report of week %d of %d in /r/%s: %v", week, year, rr.sub, err)
+			err = nil
+		}
+		if delErr := rr.posts.DeleteKey(conn, key+RedditReportsSuffixUpdate); delErr != nil {
+			return delErr
+		}
+		return err
+	}
+	rr.logger.Infof("published the report of week %d of %d in /r/%s with ID %q", week, year, rr.sub, id)
+
+	if err := rr.posts.Save(conn, key, id); err != nil {
+		rr.logger.Errorf("the report of week %d of %d in /r/%s with ID %q won't be updated, as its ID couldn't be saved", week, year, rr.sub, id)
+		return err
+	}
+	return nil
+}
+
+// lastUpdate returns the date of the last publication or update of the submission of a week, and whether it has been published.
+func (rr *RedditReports) lastUpdate(conn StorageConn, key string) (time.Time, bool, error) {
+	values, err := rr.posts.Get(conn, key+RedditReportsSuffixUpdate)
+	if err != nil || len(values) == 0 {
+		return time.Time{}, false, err
+	}
+	timestamp, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil {
+		return time.Time{}, true, fmt.Errorf("invalid date of the last update of the report %q: %v", key, err)
+	}
+	return time.Unix(timestamp, 0), true, nil
+}
+
+// saveUpdate replaces the date of the last publication or update of the submission of a week.
+func (rr *RedditReports) saveUpdate(conn StorageConn, key string, date time.Time) error {
+	return conn.WithTx(func() error {
+		if err := rr.posts.DeleteKey(conn, key+RedditReportsSuffixUpdate); err != nil {
+			return err
+		}
+		return rr.posts.Save(conn, key+RedditReportsSuffixUpdate, strconv.FormatInt(date.Unix(), 10))
+	})
+}
+
+// truncateMarkdown cuts a text written in Markdown that is longer than max bytes at the end of a paragraph,
+// and appends a notice that fits within max.
+func truncateMarkdown(text string, max int, notice string) string {
+	if len(text) <= max {
+		return text
+	}
+
+	limit := max - len(notice)
+	cut := strings.LastIndex(text[:limit], "\n\n")
+	if cut < 0 {
+		cut = limit
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+	}
+	return text[:cut] + notice
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedditReports(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fr := NewFakeReddit("")
+	storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.AddUser("AGreatUsername", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	// Week 9 of 2021 goes from the 1st of March to the 8th.
+	comment := Comment{ID: "c1", Author: "AGreatUsername", Score: -100, Permalink: "/r/test/c1", Sub: "test",
+		Created: time.Date(2021, 3, 3, 12, 0, 0, 0, time.UTC), Body: "a downvoted comment"}
+	if _, err := conn.SaveArchivedComments([]Comment{comment}); err != nil {
+		t.Fatal(err)
+	}
+
+	factory := NewReportFactory(ReportConf{CutOff: -50, NbTop: 5, SubmissionsCutOff: -50, Timezone: Timezone{Value: time.UTC}})
+	conf := RedditReportsConf{
+		ReportDelay:          Duration{Value: time.Hour},
+		ReportSub:            "reports",
+		ReportUpdateInterval: Duration{Value: 6 * time.Hour},
+		ReportUpdateMaxAge:   Duration{Value: 72 * time.Hour},
+	}
+	api := newTestRedditAPI(t, fr, "TestBot")
+	rr := NewRedditReports(NewTestLevelLogger(t), storage.KV(), api, factory, conf)
+
+	publish := func(rr *RedditReports, now time.Time) {
+		if err := rr.publish(ctx, conn, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setScore := func(score int64) {
+		comment.Score = score
+		if _, err := conn.UpdateCommentsScore([]Comment{comment}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	post := func() Submission {
+		posts := fr.Posts()
+		if len(posts) != 1 {
+			t.Fatalf("the report should have been published exactly once, got %+v", posts)
+		}
+		return posts[0]
+	}
+
+	t.Run("before the delay", func(t *testing.T) {
+		publish(rr, time.Date(2021, 3, 8, 0, 30, 0, 0, time.UTC))
+		if posts := fr.Posts(); len(posts) != 0 {
+			t.Errorf("the report shouldn't have been published before the delay, got %+v", posts)
+		}
+	})
+
+	t.Run("publish", func(t *testing.T) {
+		publish(rr, time.Date(2021, 3, 8, 2, 0, 0, 0, time.UTC))
+		published := post()
+		if published.Sub != "reports" || published.Author != "TestBot" || published.Title != "Report of week 9 of 2021" {
+			t.Errorf("unexpected submission %+v", published)
+		}
+		if !strings.Contains(published.Body, "**-100**") {
+			t.Errorf("the report should contain the comment with its score, got %q", published.Body)
+		}
+		if ids, err := storage.KV().Namespace(RedditReportsPrefixPost, 0).Get(conn, "2021-09"); err != nil {
+			t.Fatal(err)
+		} else if len(ids) != 1 || ids[0] != published.ID {
+			t.Errorf("the ID %q of the submission should have been saved, got %v", published.ID, ids)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		setScore(-200)
+		publish(rr, time.Date(2021, 3, 8, 3, 0, 0, 0, time.UTC))
+		if body := post().Body; !strings.Contains(body, "**-100**") {
+			t.Errorf("the report shouldn't have been updated before the interval, got %q", body)
+		}
+
+		publish(rr, time.Date(2021, 3, 8, 9, 0, 0, 0, time.UTC))
+		if body := post().Body; !strings.Contains(body, "**-200**") {
+			t.Errorf("the report should have been updated, got %q", body)
+		}
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		setScore(-300)
+		restarted := NewRedditReports(NewTestLevelLogger(t), storage.KV(), api, factory, conf)
+		publish(restarted, time.Date(2021, 3, 8, 10, 0, 0, 0, time.UTC))
+		if body := post().Body; !strings.Contains(body, "**-200**") {
+			t.Errorf("the date of the last update should have been kept after a restart, got %q", body)
+		}
+
+		restarted = NewRedditReports(NewTestLevelLogger(t), storage.KV(), api, factory, conf)
+		publish(restarted, time.Date(2021, 3, 11, 2, 0, 0, 0, time.UTC))
+		if body := post().Body; !strings.Contains(body, "**-200**") {
+			t.Errorf("the report shouldn't have been updated after the maximum age, got %q", body)
+		}
+
+		restarted = NewRedditReports(NewTestLevelLogger(t), storage.KV(), api, factory, conf)
+		publish(restarted, time.Date(2021, 3, 9, 0, 0, 0, 0, time.UTC))
+		if body := post().Body; !strings.Contains(body, "**-300**") {
+			t.Errorf("the report should have been updated without being published again, got %q", body)
+		}
+	})
+
+	t.Run("lost ID", func(t *testing.T) {
+		// Week 10 of 2021 goes from the 8th of March to the 15th.
+		other := Comment{ID: "c2", Author: "AGreatUsername", Score: -100, Permalink: "/r/test/c2", Sub: "test",
+			Created: time.Date(2021, 3, 10, 12, 0, 0, 0, time.UTC), Body: "another downvoted comment"}
+		if _, err := conn.SaveArchivedComments([]Comment{other}); err != nil {
+			t.Fatal(err)
+		}
+		// As if the application stopped right after the submission was made.
+		if err := rr.saveUpdate(conn, "2021-10", time.Date(2021, 3, 15, 1, 0, 0, 0, time.UTC)); err != nil {
+			t.Fatal(err)
+		}
+		publish(rr, time.Date(2021, 3, 15, 2, 0, 0, 0, time.UTC))
+		post()
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		text := strings.Repeat("paragraph\n\n", 10)
+		truncated := truncateMarkdown(text, 50, "\n\n*cut*")
+		if len(truncated) > 50 || !strings.HasSuffix(truncated, "paragraph\n\n*cut*") {
+			t.Errorf("the text should have been cut at the end of a paragraph, got %q", truncated)
+		}
+		if truncateMarkdown(text, len(text), "\n\n*cut*") != text {
+			t.Error("a text that isn't too long shouldn't be truncated")
+		}
+	})
+}
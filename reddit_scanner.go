@@ -2,14 +2,27 @@ package main
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"time"
 )
 
+// Settings of the scheduling of the scans.
+const (
+	// Period over which the activity of a user is measured to schedule their next scan.
+	RedditScannerActivityWindow = 7 * 24 * time.Hour
+	// Maximum number of users scanned in a single pass for each account,
+	// so that the users that are scanned often don't have to wait for a long pass to end.
+	RedditScannerMaxPassSize = 100
+)
+
 // RedditScanner is a component that efficiently scans users' comments and saves them.
+// It can split its work between several RedditAPI, typically each with its own account.
+// Each user is scheduled to be scanned again after an interval that gets shorter the more often
+// they comment and the more of their recent comments have a negative score (see scanInterval).
 type RedditScanner struct {
 	// dependencies
-	api     *RedditAPI
+	apis    []*RedditAPI
 	logger  LevelLogger
 	storage *Storage
 
@@ -19,35 +32,50 @@ type RedditScanner struct {
 	highScores chan Comment
 
 	// configuration
-	fullScanInterval    time.Duration
-	highScoreThreshold  int64
-	inactivityThreshold time.Duration
-	maxAge              time.Duration
-	maxBatches          uint
-	commentsLeeway      uint
+	backfill                bool
+	fullScanInterval        time.Duration
+	highScoreThreshold      int64
+	inactivityThreshold     time.Duration
+	maxAge                  time.Duration
+	maxBatches              uint
+	minScanInterval         time.Duration
+	commentsLeeway          uint
+	scanSubmissions         bool
+	scoreRefreshInterval    time.Duration
+	scoreRefreshMaxAge      time.Duration
+	scoreRefreshMaxComments uint
 }
 
 // NewRedditScanner creates a new RedditScanner.
-func NewRedditScanner(logger LevelLogger, storage *Storage, api *RedditAPI, conf RedditScannerConf) *RedditScanner {
+func NewRedditScanner(logger LevelLogger, storage *Storage, apis []*RedditAPI, conf RedditScannerConf) *RedditScanner {
 	return &RedditScanner{
-		api:     api,
+		apis:    apis,
 		logger:  logger,
 		storage: storage,
 
-		commentsLeeway:      5,
-		fullScanInterval:    conf.FullScanInterval.Value,
-		highScoreThreshold:  conf.HighScoreThreshold,
-		inactivityThreshold: conf.InactivityThreshold.Value,
-		maxAge:              conf.MaxAge.Value,
-		maxBatches:          conf.MaxBatches,
+		backfill:                conf.Backfill,
+		commentsLeeway:          5,
+		fullScanInterval:        conf.FullScanInterval.Value,
+		highScoreThreshold:      conf.HighScoreThreshold,
+		inactivityThreshold:     conf.InactivityThreshold.Value,
+		maxAge:                  conf.MaxAge.Value,
+		maxBatches:              conf.MaxBatches,
+		minScanInterval:         conf.MinScanInterval.Value,
+		scanSubmissions:         conf.ScanSubmissions,
+		scoreRefreshInterval:    conf.ScoreRefreshInterval.Value,
+		scoreRefreshMaxAge:      conf.ScoreRefreshMaxAge.Value,
+		scoreRefreshMaxComments: conf.ScoreRefreshMaxComments,
 	}
 }
 
 // Run launches the scanner and blocks until it errors out or is cancelled.
+// Each pass scans the users that are due, from the most overdue, and schedules their next scan.
+// Once per full scan interval, a pass is followed by the update of the inactive status of the users.
+// The dates at which the passes started and were completed are saved, so that the date of the last full scan is kept,
+// and after a restart the pass that was interrupted is resumed with the users that were due when it started,
+// as those that were already scanned have been scheduled after that.
 // Note that network errors are only logged and not returned, as Reddit is rather unreliable.
 func (rs *RedditScanner) Run(ctx context.Context) error {
-	var lastFullScan time.Time
-
 	conn, err := rs.storage.GetConn(ctx)
 	if err != nil {
 		return err
@@ -56,31 +84,67 @@ func (rs *RedditScanner) Run(ctx context.Context) error {
 
 	rs.logger.Info("starting comments scanner")
 
+	passes := make(map[string]ScanPass)
+	var pass ScanPass
+	for _, kind := range []string{ScanPassFull, ScanPassDue} {
+		if passes[kind], err = conn.GetScanPass(kind); err != nil {
+			return err
+		}
+		if passes[kind].InProgress() && !pass.InProgress() {
+			pass = passes[kind]
+			rs.logger.Infof("resuming the %s scan pass started at %s", pass.Kind, pass.Started)
+		}
+	}
+
 	for ctx.Err() == nil {
 
-		fullScan := time.Now().Sub(lastFullScan) >= rs.fullScanInterval
+		var users []User
+		if pass.InProgress() {
+			// The users that have already been scanned during the pass have been scheduled after its start.
+			users, err = conn.ListUsersDue(pass.Started, rs.passSize())
+			if err != nil {
+				return err
+			}
+		} else {
+			var date time.Time
+			users, date, err = rs.getUsersOrWait(ctx, conn)
+			if err != nil {
+				return err
+			}
 
-		users, err := rs.getUsersOrWait(ctx, conn, fullScan)
-		if err != nil {
-			return err
+			kind := ScanPassDue
+			if date.Sub(passes[ScanPassFull].Completed) >= rs.fullScanInterval {
+				kind = ScanPassFull
+			}
+			pass = passes[kind]
+			pass.Started = date
+			if err := conn.SaveScanPass(pass); err != nil {
+				return err
+			}
 		}
 
-		rs.logger.Debugf("scan pass: %d users, full scan: %t", len(users), fullScan)
-		if err := rs.Scan(ctx, conn, users); err != nil {
+		rs.logger.Debugf("%s scan pass: %d users due, %d accounts", pass.Kind, len(users), len(rs.apis))
+		if err := rs.scan(ctx, conn, users, pass.Kind == ScanPassFull); err != nil {
 			return err
 		}
-		rs.logger.Debug("scan pass done")
 
-		if fullScan {
-			lastFullScan = time.Now()
+		// The inactive status of the users, on which their schedule depends, is updated once per full scan interval.
+		if pass.Kind == ScanPassFull {
 			if err := conn.UpdateInactiveStatus(rs.inactivityThreshold); err != nil {
 				return err
 			}
+			if err := conn.Analyze(); err != nil {
+				return err
+			}
 		}
 
-		if err := conn.Analyze(); err != nil {
+		pass.Started = time.Time{}
+		pass.Completed = time.Now()
+		if err := conn.SaveScanPass(pass); err != nil {
 			return err
 		}
+		passes[pass.Kind] = pass
+		rs.logger.Debug("scan pass done")
 	}
 
 	rs.logger.Debug("scanner run done")
@@ -88,6 +152,92 @@ func (rs *RedditScanner) Run(ctx context.Context) error {
 	return ctx.Err()
 }
 
+// ScoreRefreshIsEnabled tells if the setting for RefreshScores allow to run it.
+func (rs *RedditScanner) ScoreRefreshIsEnabled() bool {
+	return rs.scoreRefreshInterval > 0
+}
+
+// RefreshScores is a Task that periodically updates the scores of the comments that are too old
+// to be updated by the scans, starting with the most negative ones, so that the reports and the compendium
+// reflect their final score. Like with Run, network errors are only logged.
+func (rs *RedditScanner) RefreshScores(ctx context.Context, conn StorageConn) error {
+	rs.logger.Infof("refreshing scores of comments with interval %s", rs.scoreRefreshInterval)
+
+	for SleepCtx(ctx, rs.scoreRefreshInterval) {
+		if err := rs.refreshScores(ctx, conn); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+func (rs *RedditScanner) refreshScores(ctx context.Context, conn StorageConn) error {
+	now := time.Now()
+	comments, err := conn.CommentsToRefresh(now.Add(-rs.scoreRefreshMaxAge), now.Add(-rs.maxAge), rs.scoreRefreshMaxComments)
+	if err != nil {
+		return err
+	}
+	rs.logger.Debugf("refreshing the scores of %d comments", len(comments))
+
+	queue := make(chan []string, len(comments)/MaxRedditListingLength+1)
+	for start := 0; start < len(comments); start += MaxRedditListingLength {
+		end := start + MaxRedditListingLength
+		if end > len(comments) {
+			end = len(comments)
+		}
+		ids := make([]string, 0, end-start)
+		for _, comment := range comments[start:end] {
+			ids = append(ids, comment.ID)
+		}
+		queue <- ids
+	}
+	close(queue)
+
+	tasks := NewTaskGroup(ctx)
+	for _, api := range rs.apis {
+		api := api
+		tasks.SpawnCtx(func(ctx context.Context) error {
+			for ids := range queue {
+				if err := rs.refreshBatch(ctx, conn, api, ids); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+
+	if err := tasks.Wait().ToError(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (rs *RedditScanner) refreshBatch(ctx context.Context, conn StorageConn, api *RedditAPI, ids []string) error {
+	comments, err := api.CommentsInfo(ctx, ids)
+	if IsCancellation(err) {
+		return err
+	} else if err != nil {
+		rs.logger.Errorf("error while refreshing the scores of %d comments, skipping: %v", len(ids), err)
+		return nil
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	// Comments that have been deleted since they were saved are returned by Reddit without their author or body.
+	updated, err := conn.UpdateCommentsScore(comments)
+	if err != nil {
+		return err
+	}
+
+	if err := rs.alertIfHighScore(conn, updated); err != nil {
+		rs.logger.Error(err)
+	}
+
+	return nil
+}
+
 // OpenDeaths creates, set, and returns a channel that sends newly suspended or deleted User.
 func (rs *RedditScanner) OpenDeaths() <-chan User {
 	rs.Lock()
@@ -128,97 +278,335 @@ func (rs *RedditScanner) CloseHighScores() {
 	}
 }
 
-// Scan scans a slice of users once.
+// Scan scans a slice of users once, splitting the users between the instances of RedditAPI,
+// and schedules their next scan. The connection is shared between them with its sync.Locker interface.
 func (rs *RedditScanner) Scan(ctx context.Context, conn StorageConn, users []User) error {
-OUTER:
-	for _, user := range users {
+	return rs.scan(ctx, conn, users, true)
+}
 
-		for i := uint(0); i < rs.maxBatches; i++ {
-			var err error
-			var comments []Comment
-			var limit uint
-			lastScan := time.Now().Sub(user.LastScan)
-
-			if user.New || // if the user is new, we need to scan everything as fast as possible
-				user.Position != "" || // we don't know how many relevant comments the next page has, so take as many as possible
-				user.BatchSize+rs.commentsLeeway > MaxRedditListingLength || // don't request more than the maximum, else we'll look stupid
-				lastScan > rs.maxAge { // use rs.maxAge as a heuristic to say if too much time has passed since the last scan
-				limit = MaxRedditListingLength
-			} else {
-				limit = user.BatchSize + rs.commentsLeeway
-			}
+// scan works like Scan, but only follows the changes of capitalization of the names of the users if full is true,
+// which is the case of full passes.
+func (rs *RedditScanner) scan(ctx context.Context, conn StorageConn, users []User, full bool) error {
 
-			rs.logger.Debugf("trying to get %d comments from user %+v", limit, user)
-			comments, user, err = rs.api.UserComments(ctx, user, limit)
-			if IsCancellation(err) {
-				return err
-			} else if err != nil {
-				rs.logger.Errorf("error while scanning user %q, skipping: %v", user.Name, err)
-				continue OUTER
+	queue := make(chan User, len(users))
+	for _, user := range users {
+		queue <- user
+	}
+	close(queue)
+
+	tasks := NewTaskGroup(ctx)
+	for _, api := range rs.apis {
+		api := api
+		tasks.SpawnCtx(func(ctx context.Context) error {
+			for user := range queue {
+				user, err := rs.scanUser(ctx, conn, api, user, full)
+				if err != nil {
+					return err
+				}
+				if err := rs.scheduleScan(conn, user); err != nil {
+					return err
+				}
 			}
-			rs.logger.Debugf("fetched comments: %+v", comments)
+			return nil
+		})
+	}
+
+	if err := tasks.Wait().ToError(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
 
-			rs.logger.Debugf("before scanner's user update: %+v", user)
-			// This method contains logic that returns an User datastructure whose metadata
-			// has been updated; in other words, it indirectly controls the behavior of the
-			// current loop.
-			user, err = conn.SaveCommentsUpdateUser(comments, user, lastScan+rs.maxAge)
+// scanUser scans the comments of a user, and returns it with its updated metadata.
+// If rename is true, the user is renamed if the capitalization of its name changed on Reddit.
+func (rs *RedditScanner) scanUser(ctx context.Context, conn StorageConn, api *RedditAPI, user User, rename bool) (User, error) {
+	previousScan := user.LastScan
+	for i := uint(0); i < rs.maxBatches; i++ {
+		var err error
+		var comments []Comment
+		lastScan := time.Now().Sub(user.LastScan)
+		limit := rs.batchLimit(user.New, user.Position, user.BatchSize, lastScan)
+
+		rs.logger.Debugf("trying to get %d comments from user %+v", limit, user)
+		comments, user, err = api.UserComments(ctx, user, limit)
+		if IsCancellation(err) {
+			return user, err
+		} else if err != nil {
+			rs.logger.Errorf("error while scanning user %q, skipping: %v", user.Name, err)
+			return user, nil
+		}
+		rs.logger.Debugf("fetched comments: %+v", comments)
+
+		// The comments carry the name of their author as Reddit currently has it,
+		// which must match the registered one for them to be saved.
+		if len(comments) > 0 && rename && i == 0 {
+			conn.Lock()
+			user, err = UpdateUserName(rs.logger, rs.storage, conn, user, comments[0].Author)
+			conn.Unlock()
 			if err != nil {
-				if IsSQLiteForeignKeyErr(err) { // triggered after a PurgeUser
-					rs.logger.Debugf("saving the comments of %q resulted in a foreign key constraint error, skipping", user.Name)
-					continue OUTER
+				if err == ErrNoUser || IsSQLiteForeignKeyErr(err) { // the user has been purged during the scan
+					rs.logger.Debugf("renaming %q failed because it has been purged, skipping", user.Name)
+					return user, nil
 				}
-				return err
+				return user, err
 			}
-			rs.logger.Debugf("after scanner's user update: %+v", user)
+		}
+		for j := range comments {
+			comments[j].Author = registeredAuthor(user, comments[j].Author)
+		}
 
-			if user.Suspended || user.NotFound {
-				rs.Lock()
-				if rs.deaths != nil {
-					rs.deaths <- user
-				}
-				rs.Unlock()
-				break
+		rs.logger.Debugf("before scanner's user update: %+v", user)
+		// This method contains logic that returns an User datastructure whose metadata
+		// has been updated; in other words, it indirectly controls the behavior of the
+		// current loop.
+		conn.Lock()
+		user, err = conn.SaveCommentsUpdateUser(comments, user, lastScan+rs.maxAge)
+		conn.Unlock()
+		if err != nil {
+			if IsSQLiteForeignKeyErr(err) { // triggered after a PurgeUser
+				rs.logger.Debugf("saving the comments of %q resulted in a foreign key constraint error, skipping", user.Name)
+				return user, nil
 			}
+			return user, err
+		}
+		rs.logger.Debugf("after scanner's user update: %+v", user)
 
-			if err := rs.alertIfHighScore(conn, comments); err != nil {
-				rs.logger.Error(err)
-			}
+		if user.Suspended || user.NotFound {
+			rs.announceDeath(user)
+			return user, nil
+		}
+
+		conn.Lock()
+		err = rs.alertIfHighScore(conn, comments)
+		conn.Unlock()
+		if err != nil {
+			rs.logger.Error(err)
+		}
+
+		// There are no more pages to scan, either because that's what the Reddit API returned,
+		// or because the logic we called previously decided no more pages should be scanned.
+		if user.Position == "" {
+			break
+		}
+	}
+
+	if rs.backfill {
+		var err error
+		user, err = rs.backfillUser(ctx, conn, api, user)
+		if err != nil || user.Suspended || user.NotFound {
+			return user, err
+		}
+	}
+
+	if rs.scanSubmissions {
+		return rs.scanUserSubmissions(ctx, conn, api, user, previousScan)
+	}
+	return user, nil
+}
+
+// scanUserSubmissions works like scanUser but for submissions. Since the date of the last scan
+// has already been updated by the scan of the comments, the previous one has to be given.
+func (rs *RedditScanner) scanUserSubmissions(ctx context.Context, conn StorageConn, api *RedditAPI, user User, previousScan time.Time) (User, error) {
+	for i := uint(0); i < rs.maxBatches; i++ {
+		var err error
+		var submissions []Submission
+		lastScan := time.Now().Sub(previousScan)
+		limit := rs.batchLimit(user.SubmissionsNew, user.SubmissionsPosition, user.SubmissionsBatchSize, lastScan)
+
+		rs.logger.Debugf("trying to get %d submissions from user %+v", limit, user)
+		submissions, user, err = api.UserSubmissions(ctx, user, limit)
+		if IsCancellation(err) {
+			return user, err
+		} else if err != nil {
+			rs.logger.Errorf("error while scanning the submissions of user %q, skipping: %v", user.Name, err)
+			return user, nil
+		}
+		for j := range submissions {
+			submissions[j].Author = registeredAuthor(user, submissions[j].Author)
+		}
 
-			// There are no more pages to scan, either because that's what the Reddit API returned,
-			// or because the logic we called previously decided no more pages should be scanned.
-			if user.Position == "" {
-				break
+		conn.Lock()
+		user, err = conn.SaveSubmissionsUpdateUser(submissions, user, lastScan+rs.maxAge)
+		conn.Unlock()
+		if err != nil {
+			if IsSQLiteForeignKeyErr(err) { // triggered after a PurgeUser
+				rs.logger.Debugf("saving the submissions of %q resulted in a foreign key constraint error, skipping", user.Name)
+				return user, nil
 			}
+			return user, err
+		}
+		previousScan = time.Now()
+
+		if user.Suspended || user.NotFound {
+			rs.announceDeath(user)
+			return user, nil
 		}
 
+		if user.SubmissionsPosition == "" {
+			break
+		}
 	}
-	return nil
+	return user, nil
 }
 
-func (rs *RedditScanner) getUsersOrWait(ctx context.Context, conn StorageConn, fullScan bool) ([]User, error) {
-	var users []User
-	var err error
-	// We could be using a channel to signal when a new user is added,
-	// but this isn't worth complicating AddUser for a feature that
-	// is used in production only once, when the database is empty.
-	for SleepCtx(ctx, time.Second) {
-		if fullScan {
-			users, err = conn.ListUsers()
-			if err != nil {
-				return nil, err
+// backfillUser walks through the listings of comments of a user in the order of RedditBackfillSorts,
+// up to the maximum number of batches of a scan, and saves the comments that aren't already known.
+// It starts when the scan of the newest comments of a new user is done, and resumes from the saved position.
+// Reddit can't sort listings in ascending order, so they have to be read in full to get to the most downvoted comments.
+func (rs *RedditScanner) backfillUser(ctx context.Context, conn StorageConn, api *RedditAPI, user User) (User, error) {
+	if user.New && user.Position == "" {
+		conn.Lock()
+		err := conn.StartBackfill(user.Name)
+		conn.Unlock()
+		if err != nil {
+			return user, err
+		}
+		user.BackfillSort = RedditBackfillSorts[0]
+		user.BackfillPosition = ""
+		rs.logger.Infof("starting to backfill the comments of %q", user.Name)
+	}
+
+	for i := uint(0); i < rs.maxBatches && user.BackfillSort != ""; i++ {
+		var err error
+		var comments []Comment
+		var inserted uint
+		sort := user.BackfillSort
+
+		rs.logger.Debugf("trying to backfill %d comments sorted by %s from user %+v", MaxRedditListingLength, sort, user)
+		comments, user, err = api.UserCommentsBackfill(ctx, user, MaxRedditListingLength)
+		if IsCancellation(err) {
+			return user, err
+		} else if err != nil {
+			rs.logger.Errorf("error while backfilling the comments of user %q, skipping: %v", user.Name, err)
+			return user, nil
+		}
+		for j := range comments {
+			comments[j].Author = registeredAuthor(user, comments[j].Author)
+		}
+
+		// Those comments are old, so they aren't checked for high scores, which would cause a flood of alerts.
+		conn.Lock()
+		user, inserted, err = conn.SaveBackfillUpdateUser(comments, user)
+		conn.Unlock()
+		if err != nil {
+			if IsSQLiteForeignKeyErr(err) { // triggered after a PurgeUser
+				rs.logger.Debugf("saving the backfilled comments of %q resulted in a foreign key constraint error, skipping", user.Name)
+				return user, nil
 			}
-		} else {
-			users, err = conn.ListActiveUsers()
+			return user, err
+		}
+		rs.logger.Debugf("backfilled %d new comments out of %d sorted by %s from %q", inserted, len(comments), sort, user.Name)
+
+		if user.Suspended || user.NotFound {
+			rs.announceDeath(user)
+			return user, nil
+		}
+
+		if user.BackfillSort != sort {
+			rs.logger.Infof("done backfilling the comments of %q sorted by %s", user.Name, sort)
+		}
+	}
+
+	return user, nil
+}
+
+// registeredAuthor returns the name under which the user is registered if the name of the author
+// of an item only differs by its capitalization, which is followed later, so that the item can be saved until then.
+func registeredAuthor(user User, author string) string {
+	if strings.EqualFold(author, user.Name) {
+		return user.Name
+	}
+	return author
+}
+
+// batchLimit returns how many items to request from a listing of a user.
+func (rs *RedditScanner) batchLimit(isNew bool, position string, batchSize uint, lastScan time.Duration) uint {
+	if isNew || // if the user is new, we need to scan everything as fast as possible
+		position != "" || // we don't know how many relevant items the next page has, so take as many as possible
+		batchSize+rs.commentsLeeway > MaxRedditListingLength || // don't request more than the maximum, else we'll look stupid
+		lastScan > rs.maxAge { // use rs.maxAge as a heuristic to say if too much time has passed since the last scan
+		return MaxRedditListingLength
+	}
+	return batchSize + rs.commentsLeeway
+}
+
+func (rs *RedditScanner) announceDeath(user User) {
+	rs.Lock()
+	defer rs.Unlock()
+	if rs.deaths != nil {
+		rs.deaths <- user
+	}
+}
+
+// scheduleScan saves the date of the next scan of a user, which is right away if one of their listings hasn't been read to its end.
+// A failed scan doesn't update the date of the last scan, so the next one is pushed back to at least the minimum interval.
+func (rs *RedditScanner) scheduleScan(conn StorageConn, user User) error {
+	if user.Suspended || user.NotFound {
+		return nil
+	}
+
+	conn.Lock()
+	defer conn.Unlock()
+
+	now := time.Now()
+	next := now
+	if user.Position == "" && user.SubmissionsPosition == "" && user.BackfillSort == "" {
+		interval := rs.fullScanInterval
+		if !user.Inactive {
+			comments, negative, err := conn.UserActivity(user.Name, now.Add(-RedditScannerActivityWindow))
 			if err != nil {
-				return nil, err
+				return err
 			}
+			interval = rs.scanInterval(comments, negative)
 		}
-		if len(users) > 0 {
-			break
+		next = user.LastScan.Add(interval)
+		if min := now.Add(rs.minScanInterval); next.Before(min) {
+			next = min
+		}
+	}
+
+	rs.logger.Debugf("next scan of %q scheduled at %s", user.Name, next)
+	return conn.ScheduleScan(user.Name, next)
+}
+
+// scanInterval returns the interval between two scans of a user from the number of comments they made
+// during RedditScannerActivityWindow, and from how many of them have a negative score.
+// It starts from the average time between two comments, which is divided by one more than the number of negative comments,
+// and is kept between the minimum scan interval and the full scan interval.
+func (rs *RedditScanner) scanInterval(comments, negative uint64) time.Duration {
+	if comments == 0 {
+		return rs.fullScanInterval
+	}
+	interval := RedditScannerActivityWindow / time.Duration(comments*(negative+1))
+	if interval < rs.minScanInterval {
+		return rs.minScanInterval
+	} else if interval > rs.fullScanInterval {
+		return rs.fullScanInterval
+	}
+	return interval
+}
+
+// getUsersOrWait returns the users that are due to be scanned as soon as there are some,
+// along with the date at which they were due.
+func (rs *RedditScanner) getUsersOrWait(ctx context.Context, conn StorageConn) ([]User, time.Time, error) {
+	// We could be using a channel to signal when a new user is added or when the next scan is due,
+	// but polling is cheap and simple enough.
+	for {
+		date := time.Now()
+		users, err := conn.ListUsersDue(date, rs.passSize())
+		if err != nil {
+			return nil, date, err
+		} else if len(users) > 0 {
+			return users, date, nil
+		}
+		if !SleepCtx(ctx, time.Second) {
+			return nil, date, ctx.Err()
 		}
 	}
-	return users, nil
+}
+
+func (rs *RedditScanner) passSize() uint {
+	return RedditScannerMaxPassSize * uint(len(rs.apis))
 }
 
 func (rs *RedditScanner) alertIfHighScore(conn StorageConn, comments []Comment) error {
@@ -232,15 +620,19 @@ func (rs *RedditScanner) alertIfHighScore(conn StorageConn, comments []Comment)
 	var highscoresID []string
 	var highscores []Comment
 	for _, comment := range comments {
-		if comment.Score < rs.highScoreThreshold {
-			if !rs.storage.KV().Has("highscores", comment.ID) {
+		// Older comments may have been forgotten, and would be signaled again.
+		if comment.Score < rs.highScoreThreshold && time.Since(comment.Created) < HighScoresTTL {
+			known, err := rs.storage.HighScores().Has(conn, comment.ID)
+			if err != nil {
+				return err
+			} else if !known {
 				highscoresID = append(highscoresID, comment.ID)
 				highscores = append(highscores, comment)
 			}
 		}
 	}
 
-	err := conn.WithTx(func() error { return rs.storage.KV().SaveMany(conn, "highscores", highscoresID) })
+	err := conn.WithTx(func() error { return rs.storage.HighScores().Save(conn, highscoresID) })
 	if err != nil {
 		return err
 	}
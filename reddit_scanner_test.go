@@ -0,0 +1,866 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedditScannerScan(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fr := NewFakeReddit("")
+	names, err := fr.Populate(2, 3, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apis := []*RedditAPI{newTestRedditAPI(t, fr, "TestBot1"), newTestRedditAPI(t, fr, "TestBot2")}
+
+	storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, name := range names {
+		if err := conn.AddUser(name, false, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Deleted from Reddit after having been registered.
+	if err := conn.AddUser("Deleted", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := NewRedditScanner(NewTestLevelLogger(t), storage, apis, RedditScannerConf{
+		FullScanInterval:    Duration{Value: 6 * time.Hour},
+		HighScoreThreshold:  -1000,
+		InactivityThreshold: Duration{Value: 2200 * time.Hour},
+		MaxAge:              Duration{Value: 24 * time.Hour},
+		MaxBatches:          5,
+		ScanSubmissions:     true,
+	})
+	deaths := rs.OpenDeaths()
+	defer rs.CloseDeaths()
+
+	users, err := conn.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Scan(ctx, conn, users); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("comments", func(t *testing.T) {
+		for _, name := range names {
+			comments, err := conn.UserComments(name, Pagination{Limit: 1000})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(comments) != 120 {
+				t.Errorf("all 120 comments of %q should have been saved, not %d", name, len(comments))
+			}
+		}
+	})
+
+	t.Run("submissions", func(t *testing.T) {
+		for _, name := range names {
+			submissions, err := conn.UserSubmissions(name, Pagination{Limit: 1000})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(submissions) != 12 {
+				t.Errorf("all 12 submissions of %q should have been saved, not %d", name, len(submissions))
+			}
+			for _, submission := range submissions {
+				if submission.Title == "" || submission.URL == "" {
+					t.Errorf("submission %q should have a title and a link, got %+v", submission.ID, submission)
+				}
+			}
+		}
+	})
+
+	t.Run("users", func(t *testing.T) {
+		for _, name := range names {
+			query := conn.GetUser(name)
+			if query.Error != nil {
+				t.Fatal(query.Error)
+			}
+			if query.User.New || query.User.SubmissionsNew {
+				t.Errorf("user %q should have been fully scanned", name)
+			}
+		}
+	})
+
+	t.Run("deaths", func(t *testing.T) {
+		select {
+		case user := <-deaths:
+			if user.Name != "Deleted" || !user.NotFound {
+				t.Errorf("user %q should have been found to be deleted, got %+v", "Deleted", user)
+			}
+		default:
+			t.Error("the deletion of a user should have been signaled")
+		}
+	})
+}
+
+func TestRedditScannerAccounts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// Each account uses its own FakeReddit with the same users, to know which one scanned each user.
+	frs := []*FakeReddit{NewFakeReddit(""), NewFakeReddit("")}
+	var names []string
+	for _, fr := range frs {
+		var err error
+		if names, err = fr.Populate(3, 20, 30); err != nil {
+			t.Fatal(err)
+		}
+	}
+	apis := []*RedditAPI{newTestRedditAPI(t, frs[0], "TestBot1"), newTestRedditAPI(t, frs[1], "TestBot2")}
+
+	storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, name := range names {
+		if err := conn.AddUser(name, false, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf := RedditScannerConf{
+		FullScanInterval:    Duration{Value: 6 * time.Hour},
+		HighScoreThreshold:  -1000,
+		InactivityThreshold: Duration{Value: 2200 * time.Hour},
+		MaxAge:              Duration{Value: 24 * time.Hour},
+		MaxBatches:          5,
+	}
+
+	// Returns the indexes of the accounts that requested the comments of each user since the previous call.
+	previous := make(map[string][]int)
+	for _, name := range names {
+		previous[name] = make([]int, len(frs))
+	}
+	scannedBy := func() map[string][]int {
+		accounts := make(map[string][]int)
+		for _, name := range names {
+			for i, fr := range frs {
+				count := fr.Requests("/u/" + name + "/comments")
+				if count > previous[name][i] {
+					accounts[name] = append(accounts[name], i)
+				}
+				previous[name][i] = count
+			}
+		}
+		return accounts
+	}
+
+	scan := func(t *testing.T, logger LevelLogger) {
+		t.Helper()
+		rs := NewRedditScanner(logger, storage, apis, conf)
+		users, err := conn.ListUsers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.Scan(ctx, conn, users); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("split", func(t *testing.T) {
+		scan(t, NewTestLevelLogger(t))
+		scanned := scannedBy()
+		for _, name := range names {
+			if accounts := scanned[name]; len(accounts) != 1 {
+				t.Errorf("user %q should have been scanned by exactly one account, got %v", name, accounts)
+			}
+		}
+		for _, name := range names {
+			if comments, err := conn.UserComments(name, Pagination{Limit: 1000}); err != nil {
+				t.Fatal(err)
+			} else if len(comments) != 30 {
+				t.Errorf("all 30 comments of %q should have been saved, not %d", name, len(comments))
+			}
+		}
+	})
+
+	t.Run("error on one account", func(t *testing.T) {
+		frs[1].RejectTokens(true)
+		defer frs[1].RejectTokens(false)
+
+		// The errors of the failing account are expected.
+		logger, err := NewStdLevelLogger("scanner", ioutil.Discard, "Info")
+		if err != nil {
+			t.Fatal(err)
+		}
+		before := time.Now().Truncate(time.Second)
+		scan(t, logger)
+		scanned := scannedBy()
+		for _, name := range names {
+			accounts := scanned[name]
+			if len(accounts) != 1 {
+				t.Errorf("user %q should have been tried by exactly one account, got %v", name, accounts)
+				continue
+			}
+
+			query := conn.GetUser(name)
+			if query.Error != nil {
+				t.Fatal(query.Error)
+			}
+			if accounts[0] == 0 && query.User.LastScan.Before(before) {
+				t.Errorf("user %q should have been scanned by the working account", name)
+			}
+			if query.User.NextScan.Before(before) {
+				t.Errorf("the next scan of user %q should have been scheduled", name)
+			}
+		}
+	})
+}
+
+func TestRedditScannerBackfill(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	// Reddit only lists the newest comments, so the oldest are only reachable through the other sorts.
+	fr := NewFakeReddit("")
+	fr.SetListingCap(2 * MaxRedditListingLength)
+	names, err := fr.Populate(3, 1, 5*MaxRedditListingLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := names[0]
+
+	storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.AddUser(name, false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := NewRedditScanner(NewTestLevelLogger(t), storage, []*RedditAPI{newTestRedditAPI(t, fr, "TestBot")}, RedditScannerConf{
+		Backfill:            true,
+		FullScanInterval:    Duration{Value: 6 * time.Hour},
+		HighScoreThreshold:  -1000,
+		InactivityThreshold: Duration{Value: 2200 * time.Hour},
+		MaxAge:              Duration{Value: 24 * time.Hour},
+		MaxBatches:          3,
+	})
+
+	// The first pass scans the newest comments and starts the backfill, the next ones resume it.
+	var user User
+	for i := 0; i < 2*len(RedditBackfillSorts); i++ {
+		users, err := conn.ListUsers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.Scan(ctx, conn, users); err != nil {
+			t.Fatal(err)
+		}
+		query := conn.GetUser(name)
+		if query.Error != nil {
+			t.Fatal(query.Error)
+		}
+		user = query.User
+		if user.BackfillSort == "" {
+			break
+		}
+	}
+	if user.BackfillSort != "" || user.BackfillPosition != "" {
+		t.Errorf("the backfill of %q should be done, got %+v", name, user)
+	}
+
+	comments, err := conn.UserComments(name, Pagination{Limit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) <= 2*MaxRedditListingLength {
+		t.Errorf("more than the %d newest comments of %q should have been saved, got %d", 2*MaxRedditListingLength, name, len(comments))
+	}
+
+	saved := make(map[string]bool, len(comments))
+	for _, comment := range comments {
+		saved[comment.ID] = true
+	}
+	// The most and the least downvoted comments of each period must have been reached.
+	for _, period := range []string{"all", "month", "week"} {
+		var inPeriod []Comment
+		for _, comment := range fr.users[strings.ToLower(name)].comments {
+			if length, ok := fakeRedditPeriods[period]; !ok || time.Since(comment.Created) < length-time.Minute {
+				inPeriod = append(inPeriod, comment)
+			}
+		}
+		if len(inPeriod) <= 2*MaxRedditListingLength && period != "week" {
+			t.Fatalf("there should be more comments than the listings can reach in the period %s, got %d", period, len(inPeriod))
+		}
+		sort.Slice(inPeriod, func(i, j int) bool { return inPeriod[i].Score < inPeriod[j].Score })
+		lowest, highest := inPeriod[len(inPeriod)-1].Score, inPeriod[0].Score
+		if len(inPeriod) > 2*MaxRedditListingLength {
+			lowest, highest = inPeriod[2*MaxRedditListingLength-1].Score, inPeriod[len(inPeriod)-2*MaxRedditListingLength].Score
+		}
+		for _, comment := range inPeriod {
+			if (comment.Score < lowest || comment.Score > highest) && !saved[comment.ID] {
+				t.Errorf("comment %q with score %d posted on %s should have been backfilled from the listings over the period %s",
+					comment.ID, comment.Score, comment.Created, period)
+			}
+		}
+	}
+}
+
+func TestRedditScannerRefreshScores(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fr := NewFakeReddit("")
+	fr.AddUser("AGreatUsername", time.Now().Add(-24*time.Hour))
+
+	storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	user := User{Name: "AGreatUsername"}
+	if err := conn.AddUser(user.Name, false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Enough comments to need several batches.
+	var comments []Comment
+	for i := 0; i < MaxRedditListingLength+50; i++ {
+		comments = append(comments, Comment{
+			ID:      fmt.Sprintf("c%d", i),
+			Author:  user.Name,
+			Score:   -1,
+			Sub:     "test",
+			Created: time.Now().Add(-72 * time.Hour).Round(time.Second),
+		})
+	}
+	tooOld := Comment{ID: "old", Author: user.Name, Score: -1, Sub: "test", Created: time.Now().Add(-30 * 24 * time.Hour)}
+	comments = append(comments, tooOld)
+	deleted := Comment{ID: "deleted", Author: user.Name, Score: -1, Sub: "test", Created: time.Now().Add(-48 * time.Hour).Round(time.Second), Body: "text"}
+
+	if _, err := conn.SaveCommentsUpdateUser(append(comments, deleted), user, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range comments {
+		comments[i].Score = -100
+	}
+	if err := fr.AddComments(comments...); err != nil {
+		t.Fatal(err)
+	}
+	// Reddit keeps the score of deleted comments, but not their author nor their body.
+	fr.AddUser("[deleted]", time.Time{})
+	if err := fr.AddComments(Comment{ID: deleted.ID, Author: "[deleted]", Score: -1000, Sub: "test", Created: deleted.Created, Body: "[deleted]"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := NewRedditScanner(NewTestLevelLogger(t), storage, []*RedditAPI{newTestRedditAPI(t, fr, "TestBot")}, RedditScannerConf{
+		HighScoreThreshold:      -500,
+		MaxAge:                  Duration{Value: 24 * time.Hour},
+		ScoreRefreshInterval:    Duration{Value: time.Hour},
+		ScoreRefreshMaxAge:      Duration{Value: 7 * 24 * time.Hour},
+		ScoreRefreshMaxComments: 1000,
+	})
+
+	highScores := rs.OpenHighScores()
+	defer rs.CloseHighScores()
+
+	if err := rs.refreshScores(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case comment := <-highScores:
+		if comment.ID != deleted.ID || comment.Author != user.Name || comment.Body != deleted.Body || comment.Score != -1000 {
+			t.Errorf("the alert should be about the saved comment with its new score, got %+v", comment)
+		}
+	default:
+		t.Error("the deleted comment with a high score should have been signaled")
+	}
+
+	saved, err := conn.UserComments(user.Name, Pagination{Limit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, comment := range saved {
+		if comment.ID == deleted.ID {
+			continue
+		} else if comment.ID == tooOld.ID && comment.Score != -1 {
+			t.Errorf("comment older than the max age shouldn't have been refreshed, got %+v", comment)
+		} else if comment.ID != tooOld.ID && comment.Score != -100 {
+			t.Errorf("comment should have been refreshed to a score of -100, got %+v", comment)
+		}
+	}
+}
+
+func TestRedditScannerRemovals(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fr := NewFakeReddit("")
+	fr.AddUser("AGreatUsername", time.Now().Add(-24*time.Hour))
+
+	storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	user := User{Name: "AGreatUsername"}
+	if err := conn.AddUser(user.Name, false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Recent comments are found again by the scans, old ones by the refreshing of the scores.
+	var comments []Comment
+	for i, age := range []time.Duration{time.Hour, time.Hour, 72 * time.Hour, 72 * time.Hour} {
+		comments = append(comments, Comment{
+			ID:      fmt.Sprintf("c%d", i),
+			Author:  user.Name,
+			Score:   -1,
+			Sub:     "test",
+			Created: time.Now().Add(-age).Round(time.Second),
+			Body:    fmt.Sprintf("original body %d", i),
+		})
+	}
+	if err := fr.AddComments(comments...); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := NewRedditScanner(NewTestLevelLogger(t), storage, []*RedditAPI{newTestRedditAPI(t, fr, "TestBot")}, RedditScannerConf{
+		FullScanInterval:        Duration{Value: 6 * time.Hour},
+		HighScoreThreshold:      -1000,
+		InactivityThreshold:     Duration{Value: 2200 * time.Hour},
+		MaxAge:                  Duration{Value: 24 * time.Hour},
+		MaxBatches:              5,
+		ScoreRefreshInterval:    Duration{Value: time.Hour},
+		ScoreRefreshMaxAge:      Duration{Value: 7 * 24 * time.Hour},
+		ScoreRefreshMaxComments: 1000,
+	})
+
+	scan := func() {
+		users, err := conn.ListUsers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.Scan(ctx, conn, users); err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.refreshScores(ctx, conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scan()
+
+	removed := map[string]string{
+		"c0": RedditDeletedBody,
+		"c1": RedditRemovedBody,
+		"c2": RedditDeletedBody,
+		"c3": RedditRemovedBody,
+	}
+	expected := map[string]string{
+		"c0": RemovedByAuthor,
+		"c1": RemovedByModerator,
+		"c2": RemovedByAuthor,
+		"c3": RemovedByModerator,
+	}
+	for i := range comments {
+		comments[i].Body = removed[comments[i].ID]
+	}
+	if err := fr.AddComments(comments...); err != nil {
+		t.Fatal(err)
+	}
+	scan()
+
+	var firstSeen map[string]time.Time
+	t.Run("removed", func(t *testing.T) {
+		firstSeen = make(map[string]time.Time)
+		for i, original := range comments {
+			comment, _, err := conn.GetComment(original.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if comment.Body != fmt.Sprintf("original body %d", i) {
+				t.Errorf("comment %q should have kept its original body, got %q", comment.ID, comment.Body)
+			}
+			if comment.RemovedBy != expected[comment.ID] {
+				t.Errorf("comment %q should have been removed by %q, got %q", comment.ID, expected[comment.ID], comment.RemovedBy)
+			}
+			if comment.Removed.IsZero() {
+				t.Errorf("comment %q should have a date of removal", comment.ID)
+			}
+			firstSeen[comment.ID] = comment.Removed
+		}
+	})
+
+	t.Run("seen again", func(t *testing.T) {
+		time.Sleep(time.Second)
+		scan()
+		for _, original := range comments {
+			comment, _, err := conn.GetComment(original.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !comment.Removed.Equal(firstSeen[comment.ID]) {
+				t.Errorf("comment %q should have kept the date of its removal %v, got %v", comment.ID, firstSeen[comment.ID], comment.Removed)
+			}
+		}
+	})
+
+	t.Run("per sub", func(t *testing.T) {
+		stats, err := conn.RemovalsPerSub()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 1 || stats[0].Sub != "test" || stats[0].ByAuthor != 2 || stats[0].ByModerator != 2 {
+			t.Errorf("unexpected statistics about removals: %+v", stats)
+		}
+
+		removals, err := conn.SubRemovals("TEST", Pagination{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(removals) != len(comments) {
+			t.Errorf("all %d comments should be listed as removed, got %d", len(comments), len(removals))
+		}
+	})
+
+	t.Run("reinstated", func(t *testing.T) {
+		comments[1].Body = "reinstated body"
+		if err := fr.AddComments(comments[1]); err != nil {
+			t.Fatal(err)
+		}
+		scan()
+
+		comment, _, err := conn.GetComment(comments[1].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if comment.RemovedBy != "" || !comment.Removed.IsZero() || comment.Body != "reinstated body" {
+			t.Errorf("comment %q should have been reinstated, got %+v", comment.ID, comment)
+		}
+	})
+}
+
+func TestRedditScannerRename(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fr := NewFakeReddit("")
+	fr.AddUser("agreatusername", time.Now().Add(-24*time.Hour))
+	if err := fr.AddComments(
+		Comment{ID: "c1", Author: "agreatusername", Score: -1, Sub: "test", Created: time.Now().Add(-time.Hour).Round(time.Second), Body: "a"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.AddUser("agreatusername", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SaveKarma(UserKarma{Name: "agreatusername", CommentKarma: -1, Observed: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.WhoRegistered().Save(conn, "agreatusername", "1234"); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := NewRedditScanner(NewTestLevelLogger(t), storage, []*RedditAPI{newTestRedditAPI(t, fr, "TestBot")}, RedditScannerConf{
+		FullScanInterval:    Duration{Value: 6 * time.Hour},
+		HighScoreThreshold:  -1000,
+		InactivityThreshold: Duration{Value: 2200 * time.Hour},
+		MaxAge:              Duration{Value: 24 * time.Hour},
+		MaxBatches:          5,
+	})
+
+	scan := func(full bool) {
+		users, err := conn.ListUsers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rs.scan(ctx, conn, users, full); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scan(true)
+
+	fr.RenameUser("AGreatUsername")
+	if err := fr.AddComments(
+		Comment{ID: "c2", Author: "AGreatUsername", Score: -2, Sub: "test", Created: time.Now().Round(time.Second), Body: "b"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	// The renaming waits for a full pass, but the new comments are saved in the meantime.
+	scan(false)
+	if users, err := conn.ListAllUsers(); err != nil {
+		t.Fatal(err)
+	} else if len(users) != 1 || users[0].Name != "agreatusername" {
+		t.Errorf("the user shouldn't be renamed outside of full passes, got %+v", users)
+	}
+	if comments, err := conn.UserComments("agreatusername", Pagination{Limit: 10}); err != nil {
+		t.Fatal(err)
+	} else if len(comments) != 2 {
+		t.Errorf("the new comments should be saved under the registered name until the next full pass, got %+v", comments)
+	}
+
+	scan(true)
+
+	users, err := conn.ListAllUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Name != "AGreatUsername" {
+		t.Fatalf("the user should have been renamed to %q, got %+v", "AGreatUsername", users)
+	}
+
+	comments, err := conn.UserComments("AGreatUsername", Pagination{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 2 {
+		t.Errorf("both the old and the new comments should belong to the renamed user, got %+v", comments)
+	}
+
+	history, err := conn.KarmaHistory("AGreatUsername")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Name != "AGreatUsername" {
+		t.Errorf("the samples of the karma should belong to the renamed user, got %+v", history)
+	}
+
+	if id, known, err := storage.WhoRegistered().Get(conn, "AGreatUsername"); err != nil {
+		t.Fatal(err)
+	} else if !known || id != "1234" {
+		t.Error("the key of who registered the user should have been renamed")
+	}
+	if _, known, err := storage.WhoRegistered().Get(conn, "agreatusername"); err != nil {
+		t.Fatal(err)
+	} else if known {
+		t.Error("the key of who registered the user under the previous name should have been removed")
+	}
+
+	// The user may be purged while it is being scanned.
+	users, err = conn.ListAllUsers()
+	if err != nil {
+		t.Fatal(err)
+	} else if err := conn.PurgeUser("AGreatUsername"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UpdateUserName(NewTestLevelLogger(t), storage, conn, users[0], "agreatusername"); err != ErrNoUser {
+		t.Errorf("renaming a purged user should return ErrNoUser, got %v", err)
+	}
+}
+
+func TestRedditScannerSchedule(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fr := NewFakeReddit("")
+	fr.AddUser("Troll", time.Now().Add(-24*time.Hour))
+	fr.AddUser("Quiet", time.Now().Add(-24*time.Hour))
+	var comments []Comment
+	for i := 0; i < 60; i++ {
+		score := int64(1)
+		if i%2 == 0 {
+			score = -10
+		}
+		created := time.Now().Add(-time.Duration(i) * 2 * time.Hour).Round(time.Second)
+		comments = append(comments, Comment{ID: fmt.Sprintf("t%d", i), Author: "Troll", Score: score, Sub: "test", Created: created, Body: "troll"})
+	}
+	comments = append(comments,
+		Comment{ID: "q1", Author: "Quiet", Score: -5, Sub: "test", Created: time.Now().Add(-time.Hour).Round(time.Second), Body: "quiet"},
+		Comment{ID: "q2", Author: "Quiet", Score: 3, Sub: "test", Created: time.Now().Add(-14 * 24 * time.Hour).Round(time.Second), Body: "quiet"},
+	)
+	if err := fr.AddComments(comments...); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, name := range []string{"Troll", "Quiet"} {
+		if err := conn.AddUser(name, false, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rs := NewRedditScanner(NewTestLevelLogger(t), storage, []*RedditAPI{newTestRedditAPI(t, fr, "TestBot")}, RedditScannerConf{
+		FullScanInterval:    Duration{Value: 6 * time.Hour},
+		HighScoreThreshold:  -1000,
+		InactivityThreshold: Duration{Value: 2200 * time.Hour},
+		MaxAge:              Duration{Value: 24 * time.Hour},
+		MaxBatches:          5,
+		MinScanInterval:     Duration{Value: 2 * time.Minute},
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		cases := []struct {
+			comments, negative uint64
+			expected           time.Duration
+		}{
+			{0, 0, 6 * time.Hour},
+			{2, 1, 6 * time.Hour},
+			{100, 1, 50 * time.Minute},
+			{100, 50, 2 * time.Minute},
+		}
+		for _, c := range cases {
+			if interval := rs.scanInterval(c.comments, c.negative); interval.Round(time.Minute) != c.expected {
+				t.Errorf("with %d comments of which %d are negative, the interval should be %s, not %s", c.comments, c.negative, c.expected, interval)
+			}
+		}
+	})
+
+	t.Run("due", func(t *testing.T) {
+		now := time.Now()
+		users, err := conn.ListUsersDue(now, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 2 {
+			t.Fatalf("new users should be due right away, got %+v", users)
+		}
+		if err := rs.Scan(ctx, conn, users); err != nil {
+			t.Fatal(err)
+		}
+
+		if users, err := conn.ListUsersDue(now, 10); err != nil {
+			t.Fatal(err)
+		} else if len(users) != 0 {
+			t.Errorf("no user should be due right after having been scanned, got %+v", users)
+		}
+
+		users, err = conn.ListUsersDue(now.Add(10*time.Minute), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(users) != 1 || users[0].Name != "Troll" {
+			t.Errorf("only the most active user with negative comments should be due after a few minutes, got %+v", users)
+		}
+
+		quiet := conn.GetUser("Quiet")
+		if quiet.Error != nil {
+			t.Fatal(quiet.Error)
+		}
+		if next := quiet.User.NextScan.Sub(quiet.User.LastScan); next != 6*time.Hour {
+			t.Errorf("the quiet user should be scanned again after the full scan interval, not after %s", next)
+		}
+	})
+}
+
+func TestRedditScannerResume(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	fr := NewFakeReddit("")
+	names := []string{"First", "Second", "Later"}
+	for i, name := range names {
+		fr.AddUser(name, time.Now().Add(-24*time.Hour))
+		comment := Comment{ID: fmt.Sprintf("c%d", i), Author: name, Score: -1, Sub: "test", Created: time.Now().Add(-time.Hour).Round(time.Second), Body: "a"}
+		if err := fr.AddComments(comment); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: filepath.Join(t.TempDir(), "dab.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// A full pass was interrupted before scanning the first two users, and the last one became due afterwards.
+	started := time.Now().Add(-time.Hour)
+	for _, name := range names {
+		if err := conn.AddUser(name, false, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := conn.ScheduleScan("Later", started.Add(30*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	interrupted := ScanPass{Kind: ScanPassFull, Started: started}
+	if err := conn.SaveScanPass(interrupted); err != nil {
+		t.Fatal(err)
+	}
+
+	rs := NewRedditScanner(NewTestLevelLogger(t), storage, []*RedditAPI{newTestRedditAPI(t, fr, "TestBot")}, RedditScannerConf{
+		FullScanInterval:    Duration{Value: 6 * time.Hour},
+		HighScoreThreshold:  -1000,
+		InactivityThreshold: Duration{Value: 2200 * time.Hour},
+		MaxAge:              Duration{Value: 24 * time.Hour},
+		MaxBatches:          5,
+		MinScanInterval:     Duration{Value: 2 * time.Minute},
+	})
+	done := make(chan error)
+	go func() { done <- rs.Run(runCtx) }()
+
+	var full, due ScanPass
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if full, err = conn.GetScanPass(ScanPassFull); err != nil {
+			t.Fatal(err)
+		}
+		if due, err = conn.GetScanPass(ScanPassDue); err != nil {
+			t.Fatal(err)
+		}
+		if !full.Completed.IsZero() && !due.Completed.IsZero() {
+			break
+		}
+	}
+	cancel()
+	if err := <-done; !IsCancellation(err) {
+		t.Fatal(err)
+	}
+
+	if full.Completed.IsZero() || full.InProgress() {
+		t.Fatalf("the interrupted full pass should have been completed, got %+v", full)
+	}
+	if due.Completed.IsZero() || due.Completed.Before(full.Completed) {
+		t.Fatalf("a pass over the user that became due after the interrupted pass should have followed it, got %+v", due)
+	}
+
+	for _, name := range names {
+		query := conn.GetUser(name)
+		if query.Error != nil {
+			t.Fatal(query.Error)
+		}
+		if query.User.LastScan.Before(started) {
+			t.Errorf("user %q should have been scanned", name)
+		}
+		// The resumed pass only scans the users that were due when it started.
+		if name == "Later" && query.User.LastScan.Before(full.Completed) {
+			t.Errorf("user %q should have been scanned after the resumed pass, got %+v", name, query.User)
+		}
+	}
+}
@@ -3,36 +3,59 @@ package main
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 )
 
+// RedditUsersKarmaCheckInterval is the interval between the checks for users whose karma is due to be sampled.
+const RedditUsersKarmaCheckInterval = 10 * time.Minute
+
 // AddRedditUser is a function for when the only thing needed is to add users by checking through Reddit first.
 type AddRedditUser func(context.Context, StorageConn, string, bool, bool) UserQuery
 
 // RedditUsers is a data structure to manage Reddit users by interacting with both the database and Reddit.
 type RedditUsers struct {
-	api    *RedditAPI
-	logger LevelLogger
+	api     *RedditAPI
+	logger  LevelLogger
+	storage *Storage
 
 	resurrections               chan User
 	ResurrectionsInterval       time.Duration
 	ResurrectionsWatcherEnabled bool
+
+	discoveryCutOff   int64
+	discoverySubs     []string
+	DiscoveryEnabled  bool
+	DiscoveryInterval time.Duration
+
+	KarmaInterval       time.Duration
+	KarmaSamplerEnabled bool
 }
 
 // NewRedditUsers creates a RedditUsers.
-func NewRedditUsers(logger LevelLogger, api *RedditAPI, conf RedditUsersConf) *RedditUsers {
+func NewRedditUsers(logger LevelLogger, storage *Storage, api *RedditAPI, conf RedditUsersConf) *RedditUsers {
 	return &RedditUsers{
-		api:    api,
-		logger: logger,
+		api:     api,
+		logger:  logger,
+		storage: storage,
 
 		resurrections:               make(chan User, DefaultChannelSize),
 		ResurrectionsInterval:       conf.ResurrectionsInterval.Value,
 		ResurrectionsWatcherEnabled: conf.ResurrectionsInterval.Value > 0,
+
+		discoveryCutOff:   conf.DiscoveryCutOff,
+		discoverySubs:     conf.DiscoverySubs,
+		DiscoveryEnabled:  len(conf.DiscoverySubs) > 0 && conf.DiscoveryInterval.Value > 0,
+		DiscoveryInterval: conf.DiscoveryInterval.Value,
+
+		KarmaInterval:       conf.KarmaInterval.Value,
+		KarmaSamplerEnabled: conf.KarmaInterval.Value > 0,
 	}
 }
 
 // Add registers the a user, sets it to "hidden" or not,
 // and with the argument forceSuspended can add the user even if it was found to be suspended.
+// If the user was a candidate for registration, it is removed from the candidates.
 // Case-insensitive.
 func (ru *RedditUsers) Add(ctx context.Context, conn StorageConn, username string, hidden, forceSuspended bool) UserQuery {
 	query := UserQuery{User: User{Name: username}}
@@ -59,6 +82,19 @@ func (ru *RedditUsers) Add(ctx context.Context, conn StorageConn, username strin
 
 	if err := conn.AddUser(query.User.Name, hidden, query.User.Created); err != nil {
 		query.Error = err
+		return query
+	}
+
+	if err := conn.DelCandidate(query.User.Name); err != nil {
+		query.Error = err
+		return query
+	}
+
+	if !query.User.Suspended {
+		if err := conn.SaveKarma(query.Karma); err != nil {
+			query.Error = err
+			return query
+		}
 	}
 
 	if query.User.Suspended {
@@ -70,6 +106,133 @@ func (ru *RedditUsers) Add(ctx context.Context, conn StorageConn, username strin
 	return query
 }
 
+// AcceptCandidate registers a pending candidate for registration with addUser, which removes it from the candidates.
+// The returned UserQuery doesn't exist if the candidate isn't pending or can't be found on Reddit anymore.
+func AcceptCandidate(ctx context.Context, conn StorageConn, addUser AddRedditUser, name string, hidden bool) UserQuery {
+	query := UserQuery{User: User{Name: name}}
+
+	candidate, exists, err := conn.GetCandidate(name)
+	if err != nil {
+		query.Error = err
+		return query
+	} else if !exists || !candidate.Rejected.IsZero() {
+		query.Error = fmt.Errorf("no pending candidate named %q", name)
+		return query
+	}
+
+	return addUser(ctx, conn, candidate.Name, hidden, false)
+}
+
+// Discovery is a Task to be launched independently that regularly fetches the newest comments of the configured subreddits
+// and suggests the authors of those whose score is at most the configured cut-off as candidates for registration.
+// Only the newest comments are checked, so the interval should be short enough for the subreddits not to have
+// more new comments than what a single listing can return between two checks.
+func (ru *RedditUsers) Discovery(ctx context.Context, conn StorageConn) error {
+	ru.logger.Infof("discovering users in %s with interval %s", strings.Join(ru.discoverySubs, ", "), ru.DiscoveryInterval)
+
+	for SleepCtx(ctx, ru.DiscoveryInterval) {
+		for _, sub := range ru.discoverySubs {
+			if err := ru.discover(ctx, conn, sub); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+func (ru *RedditUsers) discover(ctx context.Context, conn StorageConn, sub string) error {
+	ru.logger.Debugf("discovering users in %s", sub)
+
+	comments, err := ru.api.SubComments(ctx, sub, MaxRedditListingLength)
+	if err != nil {
+		if IsCancellation(err) {
+			return err
+		}
+		ru.logger.Errorf("discovery network error, skipping %s: %v", sub, err)
+		return nil
+	}
+
+	var found []Comment
+	for _, comment := range comments {
+		// Comments deleted by their authors don't tell who wrote them anymore.
+		if comment.Score <= ru.discoveryCutOff && comment.RemovedBy != RemovedByAuthor {
+			found = append(found, comment)
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+
+	added, err := conn.SaveCandidates(found)
+	if err != nil {
+		return err
+	}
+	if added > 0 {
+		ru.logger.Infof("discovered %d new candidate(s) for registration in %s", added, sub)
+	}
+	return nil
+}
+
+// KarmaSampler is a Task to be launched independently that samples the karma and the status of the accounts of users
+// from Reddit, so that they can be compared with what the application measures.
+// Each user is sampled at most once per interval; the users that are due are regularly checked,
+// so that the sampling resumes where it stopped after a restart.
+func (ru *RedditUsers) KarmaSampler(ctx context.Context, conn StorageConn) error {
+	ru.logger.Infof("sampling the karma of users with interval %s", ru.KarmaInterval)
+
+	for {
+		if err := ru.sampleKarma(ctx, conn); err != nil {
+			return err
+		}
+		if !SleepCtx(ctx, RedditUsersKarmaCheckInterval) {
+			return ctx.Err()
+		}
+	}
+}
+
+func (ru *RedditUsers) sampleKarma(ctx context.Context, conn StorageConn) error {
+	users, err := conn.ListUsersKarmaDue(time.Now().Add(-ru.KarmaInterval))
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		ru.logger.Debugf("sampling the karma of %s", user.Name)
+
+		res := ru.api.AboutUser(ctx, user.Name)
+		if res.Error != nil {
+			if IsCancellation(res.Error) {
+				return res.Error
+			}
+			ru.logger.Errorf("karma sampler network error, skipping %q: %v", user.Name, res.Error)
+			continue
+		}
+
+		// Suspensions and deletions are dealt with by the scanner and the resurrections watcher.
+		if !res.Exists || res.User.Suspended {
+			continue
+		}
+
+		user, err = UpdateUserName(ru.logger, ru.storage, conn, user, res.User.Name)
+		if err != nil {
+			if err == ErrNoUser || IsSQLiteForeignKeyErr(err) { // indicates that the user has been purged
+				continue
+			}
+			return err
+		}
+
+		res.Karma.Name = user.Name
+		if err := conn.SaveKarma(res.Karma); err != nil {
+			if IsSQLiteForeignKeyErr(err) { // indicates that the user has been purged
+				continue
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
 // OpenResurrections returns a channel that alerts of newly unsuspended or undeleted users.
 func (ru *RedditUsers) OpenResurrections() <-chan User {
 	return ru.resurrections
@@ -107,6 +270,17 @@ func (ru *RedditUsers) ResurrectionsWatcher(ctx context.Context, conn StorageCon
 
 			ru.logger.Debugf("resurrections watcher found about user %+v data from Reddit %+v", user, res)
 
+			if res.Exists {
+				var err error
+				user, err = UpdateUserName(ru.logger, ru.storage, conn, user, res.User.Name)
+				if err != nil {
+					if err == ErrNoUser || IsSQLiteForeignKeyErr(err) { // indicates that the user has been purged
+						continue
+					}
+					return err
+				}
+			}
+
 			if err := conn.WithTx(func() error { return ru.updateRedditUserStatus(conn, user, res) }); err != nil {
 				if IsSQLiteForeignKeyErr(err) { // indicates that the user has been purged
 					continue
@@ -166,3 +340,24 @@ func (ru *RedditUsers) updateRedditUserStatus(conn StorageConn, user User, res U
 
 	return nil
 }
+
+// UpdateUserName compares the name of a User with the one Reddit has for it, and if only its capitalization changed,
+// renames the User in the database and returns the updated User. Other mismatches are only logged,
+// as Reddit doesn't allow to rename accounts, so they likely indicate a bug. It returns ErrNoUser if the User has been purged.
+func UpdateUserName(logger LevelLogger, storage *Storage, conn StorageConn, user User, name string) (User, error) {
+	if name == "" || name == user.Name {
+		return user, nil
+	}
+
+	if !strings.EqualFold(name, user.Name) {
+		logger.Errorf("Reddit returned the name %q for the user %q, ignoring it", name, user.Name)
+		return user, nil
+	}
+
+	logger.Infof("the capitalization of the name of %q changed on Reddit to %q, renaming", user.Name, name)
+	if err := storage.RenameUser(conn, user.Name, name); err != nil {
+		return user, err
+	}
+	user.Name = name
+	return user, nil
+}
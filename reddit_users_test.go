@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRedditUsers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fr := NewFakeReddit("")
+	fr.AddUser("AGreatUsername", time.Now().Add(-24*time.Hour).Round(time.Second))
+	fr.AddUser("Suspended", time.Now())
+	fr.SuspendUser("Suspended", true)
+
+	storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ru := NewRedditUsers(NewTestLevelLogger(t), storage, newTestRedditAPI(t, fr, "TestBot"), RedditUsersConf{
+		ResurrectionsInterval: Duration{Value: 10 * time.Millisecond},
+	})
+
+	t.Run("add", func(t *testing.T) {
+		query := ru.Add(ctx, conn, "agreatusername", false, false)
+		if query.Error != nil {
+			t.Fatal(query.Error)
+		}
+		if !query.Exists || query.User.Name != "AGreatUsername" {
+			t.Errorf("user should have been added with its original capitalization, got %+v", query)
+		}
+
+		if query := conn.GetUser("AGreatUsername"); query.Error != nil || !query.Exists {
+			t.Errorf("user should have been saved in the database, got %+v", query)
+		}
+	})
+
+	t.Run("add existing", func(t *testing.T) {
+		if query := ru.Add(ctx, conn, "AGreatUsername", false, false); query.Error == nil {
+			t.Error("adding a user twice should fail")
+		}
+	})
+
+	t.Run("add unknown", func(t *testing.T) {
+		query := ru.Add(ctx, conn, "NotAUser", false, false)
+		if query.Error != nil {
+			t.Fatal(query.Error)
+		}
+		if query.Exists {
+			t.Errorf("user shouldn't have been found, got %+v", query)
+		}
+	})
+
+	t.Run("add suspended", func(t *testing.T) {
+		if query := ru.Add(ctx, conn, "Suspended", false, false); query.Error == nil {
+			t.Error("adding a suspended user shouldn't work without forcing it")
+		}
+
+		query := ru.Add(ctx, conn, "Suspended", false, true)
+		if query.Error != nil {
+			t.Fatal(query.Error)
+		}
+		if !query.User.Suspended {
+			t.Errorf("user should have been added as suspended, got %+v", query)
+		}
+	})
+
+	// Leave this case at the end, it depends on the previous ones.
+	t.Run("resurrections watcher", func(t *testing.T) {
+		fr.SuspendUser("Suspended", false)
+
+		ctx, cancel := context.WithCancel(ctx)
+		done := make(chan error)
+		go func() { done <- ru.ResurrectionsWatcher(ctx, conn) }()
+
+		select {
+		case user := <-ru.OpenResurrections():
+			if user.Name != "Suspended" {
+				t.Errorf("the resurrection of %q should have been signaled, not %+v", "Suspended", user)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("the resurrection of a user should have been signaled")
+		}
+
+		cancel()
+		if err := <-done; !IsCancellation(err) {
+			t.Error(err)
+		}
+
+		if query := conn.GetUser("Suspended"); query.Error != nil || query.User.Suspended {
+			t.Errorf("user shouldn't be suspended anymore in the database, got %+v", query)
+		}
+	})
+}
+
+func TestRedditUsersDiscovery(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fr := NewFakeReddit("")
+	for _, name := range []string{"Downvoted", "Upvoted", "Registered", "Elsewhere", "Rejected"} {
+		fr.AddUser(name, time.Now().Add(-24*time.Hour))
+	}
+	now := time.Now().Round(time.Second)
+	if err := fr.AddComments(
+		Comment{ID: "c1", Author: "Downvoted", Score: -20, Permalink: "/r/Watched/c1", Sub: "Watched", Created: now, Body: "a"},
+		Comment{ID: "c2", Author: "Downvoted", Score: -40, Permalink: "/r/Watched/c2", Sub: "Watched", Created: now, Body: "b"},
+		Comment{ID: "c3", Author: "Upvoted", Score: 5, Permalink: "/r/Watched/c3", Sub: "Watched", Created: now, Body: "c"},
+		Comment{ID: "c4", Author: "Registered", Score: -30, Permalink: "/r/Watched/c4", Sub: "Watched", Created: now, Body: "d"},
+		Comment{ID: "c5", Author: "Elsewhere", Score: -50, Permalink: "/r/Other/c5", Sub: "Other", Created: now, Body: "e"},
+		Comment{ID: "c6", Author: "Rejected", Score: -60, Permalink: "/r/Watched/c6", Sub: "Watched", Created: now, Body: "f"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.AddUser("Registered", false, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.SaveCandidates([]Comment{{Author: "Rejected", Score: -1, Sub: "Watched"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.RejectCandidate("rejected"); err != nil {
+		t.Fatal(err)
+	}
+
+	ru := NewRedditUsers(NewTestLevelLogger(t), storage, newTestRedditAPI(t, fr, "TestBot"), RedditUsersConf{
+		DiscoveryCutOff:   -10,
+		DiscoveryInterval: Duration{Value: time.Minute},
+		DiscoverySubs:     []string{"Watched"},
+	})
+
+	t.Run("discover", func(t *testing.T) {
+		if err := ru.discover(ctx, conn, "Watched"); err != nil {
+			t.Fatal(err)
+		}
+
+		candidates, err := conn.ListCandidates()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(candidates) != 1 || candidates[0].Name != "Downvoted" {
+			t.Fatalf("only %q should be a candidate, got %+v", "Downvoted", candidates)
+		}
+		if candidates[0].Score != -40 || candidates[0].Permalink != "/r/Watched/c2" {
+			t.Errorf("the comment with the lowest score should have been kept, got %+v", candidates[0])
+		}
+	})
+
+	t.Run("accept", func(t *testing.T) {
+		if query := AcceptCandidate(ctx, conn, ru.Add, "Rejected", false); query.Error == nil {
+			t.Error("a rejected candidate shouldn't be accepted")
+		}
+
+		query := AcceptCandidate(ctx, conn, ru.Add, "downvoted", true)
+		if query.Error != nil {
+			t.Fatal(query.Error)
+		}
+		if query := conn.GetUser("Downvoted"); query.Error != nil || !query.Exists || !query.User.Hidden {
+			t.Errorf("candidate should have been registered as hidden, got %+v", query)
+		}
+		if _, exists, err := conn.GetCandidate("Downvoted"); err != nil {
+			t.Fatal(err)
+		} else if exists {
+			t.Error("accepted candidate should have been removed from the candidates")
+		}
+
+		// Registered users aren't suggested again.
+		if err := ru.discover(ctx, conn, "Watched"); err != nil {
+			t.Fatal(err)
+		}
+		if candidates, err := conn.ListCandidates(); err != nil {
+			t.Fatal(err)
+		} else if len(candidates) != 0 {
+			t.Errorf("there should be no candidate left, got %+v", candidates)
+		}
+	})
+}
+
+func TestRedditUsersKarma(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fr := NewFakeReddit("")
+	fr.AddUser("Sampled", time.Now().Add(-24*time.Hour))
+	fr.AddUser("Late", time.Now().Add(-24*time.Hour))
+	now := time.Now().Round(time.Second)
+	if err := fr.AddComments(
+		Comment{ID: "c1", Author: "Sampled", Score: -20, Permalink: "/r/sub/c1", Sub: "sub", Created: now, Body: "a"},
+		Comment{ID: "c2", Author: "Sampled", Score: 5, Permalink: "/r/sub/c2", Sub: "sub", Created: now, Body: "b"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ru := NewRedditUsers(NewTestLevelLogger(t), storage, newTestRedditAPI(t, fr, "TestBot"), RedditUsersConf{
+		KarmaInterval: Duration{Value: time.Hour},
+	})
+
+	t.Run("add", func(t *testing.T) {
+		if query := ru.Add(ctx, conn, "Sampled", false, false); query.Error != nil {
+			t.Fatal(query.Error)
+		}
+
+		history, err := conn.KarmaHistory("sampled")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 1 {
+			t.Fatalf("adding a user should have saved exactly one sample of their karma, got %+v", history)
+		}
+		if history[0].CommentKarma != -15 || !history[0].Verified {
+			t.Errorf("unexpected sample of the karma %+v", history[0])
+		}
+	})
+
+	t.Run("sample", func(t *testing.T) {
+		if err := conn.AddUser("Late", false, now); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ru.sampleKarma(ctx, conn); err != nil {
+			t.Fatal(err)
+		}
+
+		history, err := conn.KarmaHistory("Late")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 1 || history[0].Name != "Late" {
+			t.Errorf("the karma of a user without any sample should have been sampled, got %+v", history)
+		}
+
+		history, err = conn.KarmaHistory("Sampled")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 1 {
+			t.Errorf("a user sampled less than an interval ago shouldn't have been sampled again, got %+v", history)
+		}
+
+		due, err := conn.ListUsersKarmaDue(time.Now().Add(-time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 0 {
+			t.Errorf("no user should be due for a sample, got %+v", due)
+		}
+	})
+
+	t.Run("trend", func(t *testing.T) {
+		history := []UserKarma{
+			{Name: "Sampled", CommentKarma: 10, LinkKarma: 1, Observed: now.Add(-40 * 24 * time.Hour)},
+			{Name: "Sampled", CommentKarma: 8, LinkKarma: 2, Observed: now.Add(-20 * 24 * time.Hour)},
+			{Name: "Sampled", CommentKarma: -5, LinkKarma: 4, Observed: now},
+		}
+		trend := NewKarmaTrend(history, KarmaTrendPeriod)
+		if !trend.Exists() || trend.CommentChange != -15 || trend.LinkChange != 3 || !trend.Since.Equal(history[0].Observed) {
+			t.Errorf("the trend should start at the last sample before the period, got %+v", trend)
+		}
+
+		trend = NewKarmaTrend(history[1:], KarmaTrendPeriod)
+		if trend.CommentChange != -13 || trend.LinkChange != 2 || !trend.Since.Equal(history[1].Observed) {
+			t.Errorf("the trend should start at the oldest sample if none is older than the period, got %+v", trend)
+		}
+
+		if trend := NewKarmaTrend(nil, KarmaTrendPeriod); trend.Exists() {
+			t.Errorf("there shouldn't be a trend without any sample, got %+v", trend)
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		if err := conn.PurgeUser("Late"); err != nil {
+			t.Fatal(err)
+		}
+		history, err := conn.KarmaHistory("Late")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(history) != 0 {
+			t.Errorf("the samples of the karma of a purged user should have been deleted, got %+v", history)
+		}
+	})
+}
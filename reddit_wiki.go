@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// Settings of the publication of the compendium on the wiki of a subreddit.
+const (
+	RedditWikiReason    = "automatic update of the compendium"
+	RedditWikiTruncated = "\n\n*The compendium is too long to be published in full.*"
+)
+
+// RedditWiki is a component that publishes the index of the compendium on a page of the wiki of a subreddit
+// and keeps it up to date.
+type RedditWiki struct {
+	api        *RedditAPI
+	compendium CompendiumFactory
+	logger     LevelLogger
+
+	Enabled  bool
+	interval time.Duration
+	page     string
+	sub      string
+}
+
+// NewRedditWiki creates a RedditWiki.
+func NewRedditWiki(logger LevelLogger, api *RedditAPI, compendium CompendiumFactory, conf RedditWikiConf) *RedditWiki {
+	return &RedditWiki{
+		api:        api,
+		compendium: compendium,
+		logger:     logger,
+
+		Enabled:  conf.CompendiumWikiSub != "" && !api.AppOnly(),
+		interval: conf.CompendiumWikiInterval.Value,
+		page:     strings.Trim(conf.CompendiumWikiPage, "/ "),
+		sub:      conf.CompendiumWikiSub,
+	}
+}
+
+// CompendiumPublisher is a Task to be launched independently that publishes the compendium at regular intervals.
+// The page is not edited if its content wouldn't change, so that its history only contains actual updates.
+// Like with the scanner, network errors are only logged.
+func (rw *RedditWiki) CompendiumPublisher(ctx context.Context, conn StorageConn) error {
+	rw.logger.Infof("publishing the compendium on the page %q of the wiki of /r/%s every %s", rw.page, rw.sub, rw.interval)
+
+	for {
+		if err := rw.publishCompendium(ctx, conn); err != nil {
+			return err
+		}
+		if !SleepCtx(ctx, rw.interval) {
+			return ctx.Err()
+		}
+	}
+}
+
+func (rw *RedditWiki) publishCompendium(ctx context.Context, conn StorageConn) error {
+	compendium, err := rw.compendium.Index(conn)
+	if err != nil {
+		return err
+	}
+
+	var text strings.Builder
+	if err := MarkdownCompendium.Execute(&text, compendium); err != nil {
+		return err
+	}
+	content := truncateMarkdown(text.String(), RedditMaxWikiPageLength, RedditWikiTruncated)
+
+	current, err := rw.api.WikiPage(ctx, rw.sub, rw.page)
+	if IsCancellation(err) {
+		return err
+	} else if err != nil {
+		// The page may not exist yet, in which case editing it creates it.
+		rw.logger.Debugf("error when reading the page %q of the wiki of /r/%s, editing it anyway: %v", rw.page, rw.sub, err)
+	} else if normalizeWikiPage(current) == normalizeWikiPage(content) {
+		rw.logger.Debugf("the compendium on the page %q of the wiki of /r/%s is up to date", rw.page, rw.sub)
+		return nil
+	}
+
+	if err := rw.api.EditWikiPage(ctx, rw.sub, rw.page, content, RedditWikiReason); err != nil {
+		if IsCancellation(err) {
+			return err
+		}
+		rw.logger.Errorf("error when publishing the compendium on the page %q of the wiki of /r/%s: %v", rw.page, rw.sub, err)
+		return nil
+	}
+	rw.logger.Debugf("published the compendium on the page %q of the wiki of /r/%s", rw.page, rw.sub)
+	return nil
+}
+
+// Reddit may change the line endings and the surrounding white space of the pages of a wiki.
+func normalizeWikiPage(content string) string {
+	return strings.TrimSpace(strings.ReplaceAll(content, "\r\n", "\n"))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedditWiki(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	fr := NewFakeReddit("")
+	_, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.AddUser("AGreatUsername", false, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	comment := Comment{ID: "c1", Author: "AGreatUsername", Score: -100, Permalink: "/r/test/c1", Sub: "test",
+		Created: time.Date(2021, 3, 3, 12, 0, 0, 0, time.UTC), Body: "a downvoted comment"}
+	if _, err := conn.SaveArchivedComments([]Comment{comment}); err != nil {
+		t.Fatal(err)
+	}
+
+	compendium := NewCompendiumFactory(CompendiumConf{NbTop: 5, Timezone: Timezone{Value: time.UTC}})
+	conf := RedditWikiConf{
+		CompendiumWikiInterval: Duration{Value: time.Hour},
+		CompendiumWikiPage:     "/compendium/",
+		CompendiumWikiSub:      "test",
+	}
+	rw := NewRedditWiki(NewTestLevelLogger(t), newTestRedditAPI(t, fr, "TestBot"), compendium, conf)
+
+	publish := func() []string {
+		if err := rw.publishCompendium(ctx, conn); err != nil {
+			t.Fatal(err)
+		}
+		contents, reasons := fr.WikiRevisions("test", "compendium")
+		for _, reason := range reasons {
+			if reason != RedditWikiReason {
+				t.Errorf("unexpected reason for the revision %q", reason)
+			}
+		}
+		return contents
+	}
+
+	t.Run("publish", func(t *testing.T) {
+		contents := publish()
+		if len(contents) != 1 {
+			t.Fatalf("the compendium should have been published exactly once, got %d revisions", len(contents))
+		}
+		for _, expected := range []string{"/u/AGreatUsername", "**-100**", "> a downvoted comment"} {
+			if !strings.Contains(contents[0], expected) {
+				t.Errorf("the compendium should contain %q, got %q", expected, contents[0])
+			}
+		}
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		if contents := publish(); len(contents) != 1 {
+			t.Errorf("the page shouldn't have been edited if the compendium didn't change, got %d revisions", len(contents))
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		comment.Score = -200
+		if _, err := conn.UpdateCommentsScore([]Comment{comment}); err != nil {
+			t.Fatal(err)
+		}
+		contents := publish()
+		if len(contents) != 2 {
+			t.Fatalf("the page should have been edited once more, got %d revisions", len(contents))
+		}
+		if !strings.Contains(contents[1], "**-200**") {
+			t.Errorf("the compendium should contain the new score, got %q", contents[1])
+		}
+	})
+}
@@ -7,19 +7,21 @@ import (
 // ReportFactory generates data structures that define reports about the comments made between two dates,
 // and provides method to deal with week numbers, so as to easily generate reports for a specific week.
 type ReportFactory struct {
-	cutOff   int64          // Max acceptable comment score for inclusion in the report
-	leeway   time.Duration  // Shift of the report's start and end date
-	nbTop    uint           // Number of items to summarize the weeks with statistics
-	Timezone *time.Location // Timezone used to compute weeks, years and corresponding start/end dates
+	cutOff            int64          // Max acceptable comment score for inclusion in the report
+	leeway            time.Duration  // Shift of the report's start and end date
+	nbTop             uint           // Number of items to summarize the weeks with statistics
+	submissionsCutOff int64          // Max acceptable submission score for inclusion in the report
+	Timezone          *time.Location // Timezone used to compute weeks, years and corresponding start/end dates
 }
 
 // NewReportFactory returns a ReportFactory.
 func NewReportFactory(conf ReportConf) ReportFactory {
 	return ReportFactory{
-		leeway:   conf.Leeway.Value,
-		Timezone: conf.Timezone.Value,
-		cutOff:   conf.CutOff,
-		nbTop:    conf.NbTop,
+		leeway:            conf.Leeway.Value,
+		Timezone:          conf.Timezone.Value,
+		cutOff:            conf.CutOff,
+		nbTop:             conf.NbTop,
+		submissionsCutOff: conf.SubmissionsCutOff,
 	}
 }
 
@@ -50,6 +52,8 @@ func (rf ReportFactory) StatsWeek(conn StorageConn, weekNum uint8, year int) (Re
 // Report generates a Report between two arbitrary dates.
 func (rf ReportFactory) Report(conn StorageConn, start, end time.Time) (Report, error) {
 	var comments []Comment
+	var revised map[string]bool
+	var submissions []Submission
 	var stats StatsCollection
 
 	err := conn.WithTx(func() error {
@@ -58,21 +62,32 @@ func (rf ReportFactory) Report(conn StorageConn, start, end time.Time) (Report,
 		if err != nil {
 			return err
 		}
+		revised, err = conn.RevisedComments(comments)
+		if err != nil {
+			return err
+		}
+		submissions, err = conn.GetSubmissionsBelowBetween(rf.submissionsCutOff, start, end)
+		if err != nil {
+			return err
+		}
 		stats, err = conn.StatsBetween(start, end)
 		return err
 	})
 
 	report := Report{
 		ReportInfo: ReportInfo{
-			CutOff:   rf.cutOff,
-			End:      end,
-			Start:    start,
-			Timezone: rf.Timezone,
-			Version:  Version,
+			CutOff:            rf.cutOff,
+			End:               end,
+			Start:             start,
+			SubmissionsCutOff: rf.submissionsCutOff,
+			Timezone:          rf.Timezone,
+			Version:           Version,
 		},
-		comments: comments,
-		nbTop:    rf.nbTop,
-		stats:    stats.Filter(func(s Stats) bool { return s.Sum < rf.cutOff }),
+		comments:    comments,
+		nbTop:       rf.nbTop,
+		revised:     revised,
+		stats:       stats.Filter(func(s Stats) bool { return s.Sum < rf.cutOff }),
+		submissions: submissions,
 	}
 
 	return report, err
@@ -84,11 +99,12 @@ func (rf ReportFactory) Stats(conn StorageConn, start, end time.Time) (ReportHea
 	global := stats.Stats()
 	report := ReportHeader{
 		ReportInfo: ReportInfo{
-			CutOff:   rf.cutOff,
-			End:      end,
-			Start:    start,
-			Timezone: rf.Timezone,
-			Version:  Version,
+			CutOff:            rf.cutOff,
+			End:               end,
+			Start:             start,
+			SubmissionsCutOff: rf.submissionsCutOff,
+			Timezone:          rf.Timezone,
+			Version:           Version,
 		},
 		Average: stats.OrderBy(func(a, b Stats) bool { return a.Average < b.Average }).ToView(rf.Timezone),
 		Delta:   stats.ToView(rf.Timezone),
@@ -100,13 +116,17 @@ func (rf ReportFactory) Stats(conn StorageConn, start, end time.Time) (ReportHea
 
 // CurrentWeekCoordinates returns the week number and year of the current week according to the ReportFactory's time zone.
 func (rf ReportFactory) CurrentWeekCoordinates() (uint8, int) {
-	year, week := rf.Now().ISOWeek()
-	return uint8(week), year
+	return rf.WeekCoordinates(rf.Now())
 }
 
 // LastWeekCoordinates returns the week number and year of the previous week according to the ReportFactory's time zone.
 func (rf ReportFactory) LastWeekCoordinates() (uint8, int) {
-	year, week := rf.Now().AddDate(0, 0, -7).ISOWeek()
+	return rf.WeekCoordinates(rf.Now().AddDate(0, 0, -7))
+}
+
+// WeekCoordinates returns the week number and year of the week of a date according to the ReportFactory's time zone.
+func (rf ReportFactory) WeekCoordinates(date time.Time) (uint8, int) {
+	year, week := date.In(rf.Timezone).ISOWeek()
 	return uint8(week), year
 }
 
@@ -136,22 +156,24 @@ func (rf ReportFactory) Now() time.Time {
 
 // ReportInfo describes the metadata of a report.
 type ReportInfo struct {
-	CutOff   int64          // Max score of the comments included in the report
-	End      time.Time      // End date of the report
-	Start    time.Time      // Start date of the report
-	Timezone *time.Location // Timezone of dates
-	Version  SemVer         // Version of the software with which the report was made
-	Week     uint8          // ISO Week number of the report
-	Year     int            // Year of the report
+	CutOff            int64          // Max score of the comments included in the report
+	End               time.Time      // End date of the report
+	Start             time.Time      // Start date of the report
+	SubmissionsCutOff int64          // Max score of the submissions included in the report
+	Timezone          *time.Location // Timezone of dates
+	Version           SemVer         // Version of the software with which the report was made
+	Week              uint8          // ISO Week number of the report
+	Year              int            // Year of the report
 }
 
 // ReportHeader describes a summary of a Report suitable for a use in a template.
 type ReportHeader struct {
 	ReportInfo
-	Global  StatsView
-	Average []StatsView // List of users with the lowest average karma
-	Delta   []StatsView // List of users with the biggest loss of karma
-	Len     uint64      // Number of comments in the report
+	Global         StatsView
+	Average        []StatsView // List of users with the lowest average karma
+	Delta          []StatsView // List of users with the biggest loss of karma
+	Len            uint64      // Number of comments in the report
+	SubmissionsLen uint64      // Number of submissions in the report
 }
 
 // ReportComment is a specialized version of CommentView for use in Report.
@@ -164,9 +186,11 @@ type ReportComment struct {
 // It is suitable for use in a template.
 type Report struct {
 	ReportInfo
-	nbTop    uint            // Max number of statistics to put in the report's headers to summarize the week
-	stats    StatsCollection // Statistics for all users
-	comments []Comment
+	nbTop       uint            // Max number of statistics to put in the report's headers to summarize the week
+	stats       StatsCollection // Statistics for all users
+	comments    []Comment
+	revised     map[string]bool // Comments whose body has been edited
+	submissions []Submission
 
 	CommentBodyConverter CommentBodyConverter
 }
@@ -174,11 +198,12 @@ type Report struct {
 // Header returns a data structure that describes a summary of the Report.
 func (r Report) Header() ReportHeader {
 	return ReportHeader{
-		ReportInfo: r.ReportInfo,
-		Average:    r.stats.OrderBy(func(a, b Stats) bool { return a.Average < b.Average }).Limit(r.nbTop).ToView(r.Timezone),
-		Delta:      r.stats.Limit(r.nbTop).ToView(r.Timezone),
-		Global:     r.stats.Stats().ToView(0, r.Timezone),
-		Len:        r.Len(),
+		ReportInfo:     r.ReportInfo,
+		Average:        r.stats.OrderBy(func(a, b Stats) bool { return a.Average < b.Average }).Limit(r.nbTop).ToView(r.Timezone),
+		Delta:          r.stats.Limit(r.nbTop).ToView(r.Timezone),
+		Global:         r.stats.Stats().ToView(0, r.Timezone),
+		Len:            r.Len(),
+		SubmissionsLen: r.SubmissionsLen(),
 	}
 }
 
@@ -190,8 +215,10 @@ func (r Report) Comments() []ReportComment {
 	for i := uint64(0); i < n; i++ {
 		comment := r.comments[i]
 		number := i + 1
+		view := comment.ToView(number, r.Timezone, r.CommentBodyConverter)
+		view.Revised = r.revised[comment.ID]
 		views = append(views, ReportComment{
-			CommentView: comment.ToView(number, r.Timezone, r.CommentBodyConverter),
+			CommentView: view,
 			Stats:       byName[comment.Author].ToView(number, r.Timezone),
 		})
 	}
@@ -202,3 +229,17 @@ func (r Report) Comments() []ReportComment {
 func (r Report) Len() uint64 {
 	return uint64(len(r.comments))
 }
+
+// Submissions returns a slice of data structures describing submissions that are suitable for use in templates.
+func (r Report) Submissions() []SubmissionView {
+	views := make([]SubmissionView, 0, len(r.submissions))
+	for i, submission := range r.submissions {
+		views = append(views, submission.ToView(uint64(i+1), r.Timezone, r.CommentBodyConverter))
+	}
+	return views
+}
+
+// SubmissionsLen returns the number of submissions without having to run Submissions.
+func (r Report) SubmissionsLen() uint64 {
+	return uint64(len(r.submissions))
+}
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	sqlite "github.com/bvinc/go-sqlite-lite/sqlite3"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -24,10 +26,12 @@ type SQLQuery struct {
 }
 
 // SQLiteMigration describes a migration from a SemVer to another.
+// Down is optional and reverts Exec; a database can't be rolled back past a migration without it.
 type SQLiteMigration struct {
 	From SemVer
 	To   SemVer
 	Exec func(SQLiteConn) error
+	Down func(SQLiteConn) error
 }
 
 // SQLiteBackupOptions replaces three consecutive string arguments to avoid mistakenly swapping
@@ -42,21 +46,22 @@ type SQLiteBackupOptions struct {
 
 // SQLiteDatabaseOptions describes the configuration for an SQLite database.
 type SQLiteDatabaseOptions struct {
-	AppID           int
-	CleanupInterval time.Duration
-	InitHook        func(SQLiteConn) error
-	Migrations      []SQLiteMigration
-	Path            string
-	Retry           RetryConf
-	Timeout         time.Duration
-	Version         SemVer
+	AppID            int
+	CleanupInterval  time.Duration
+	InitHook         func(SQLiteConn) error // Run after the database has been initialized and migrated
+	MigrationBackups bool                   // Backup the database next to it before migrating it or rolling it back
+	Migrations       []SQLiteMigration
+	Path             string
+	Retry            RetryConf
+	Timeout          time.Duration
+	Version          SemVer
 }
 
 // SQLiteDatabase provides database features that are not application-specific:
 //  - open or create a database file with data-safe performance-oriented options
 //  - check its application ID and version fields
 //  - check its consistency
-//  - apply migrations
+//  - apply migrations, with a backup beforehand, a dry run, and rollbacks
 //  - write the choosen application ID and version
 //  - create connections with sane options
 //  - a backup method
@@ -90,7 +95,47 @@ func NewSQLiteDatabase(ctx context.Context, logger LevelLogger, opts SQLiteDatab
 		return nil, nil, err
 	}
 
-	if err := db.init(conn); err != nil {
+	if err := db.init(ctx, conn); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if db.InitHook != nil {
+		if err := db.InitHook(conn); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	return db, conn, nil
+}
+
+// OpenSQLiteDatabaseForMigrations opens an existing SQLiteDatabase and runs its checks,
+// but neither applies the migrations nor writes the version, so that they can be inspected, tried, or rolled back.
+// It returns the connection it needed to run the checks.
+func OpenSQLiteDatabaseForMigrations(ctx context.Context, logger LevelLogger, opts SQLiteDatabaseOptions) (*SQLiteDatabase, SQLiteConn, error) {
+	db := &SQLiteDatabase{
+		SQLiteDatabaseOptions: opts,
+
+		backups: sync.Mutex{},
+		logger:  logger,
+	}
+
+	db.logger.Debugf("opening %s for migrations, version %s, application ID 0x%x", db, db.Version, db.AppID)
+
+	if stat, err := os.Stat(db.Path); err != nil {
+		return nil, nil, err
+	} else if stat.IsDir() {
+		return nil, nil, fmt.Errorf("cannot open %q as a database, it is a directory", db.Path)
+	} else if stat.Size() == 0 {
+		return nil, nil, fmt.Errorf("database %q is empty", db.Path)
+	}
+
+	conn, err := db.GetConn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := conn.WithTx(func() error { return db.check(conn) }); err != nil {
 		conn.Close()
 		return nil, nil, err
 	}
@@ -113,7 +158,7 @@ func (db *SQLiteDatabase) getConnDefaultOptions() SQLiteConnOptions {
 	}
 }
 
-func (db *SQLiteDatabase) init(conn SQLiteConn) error {
+func (db *SQLiteDatabase) init(ctx context.Context, conn SQLiteConn) error {
 	isNew := false
 	if stat, err := os.Stat(db.Path); os.IsNotExist(err) || stat.Size() == 0 {
 		isNew = true
@@ -125,23 +170,7 @@ func (db *SQLiteDatabase) init(conn SQLiteConn) error {
 	}
 
 	if !isNew {
-		err := conn.WithTx(func() error {
-			if err := db.checkApplicationID(conn); err != nil {
-				return err
-			} else if err := db.getWrittenVersion(conn); err != nil {
-				return err
-			} else if db.WrittenVersion.Equal(SemVer{0, 0, 0}) && !db.Version.Equal(SemVer{0, 0, 0}) {
-				return fmt.Errorf("database at %q was already created but no version was set", db.Path)
-			} else if db.WrittenVersion.After(db.Version) {
-				return fmt.Errorf("database at %q was last written by version %s more recent than the current version", db.Path, db.WrittenVersion)
-			} else if err := db.quickCheck(conn); err != nil {
-				return err
-			} else if err := db.foreignKeysCheck(conn); err != nil {
-				return err
-			}
-			return nil
-		})
-		if err != nil {
+		if err := conn.WithTx(func() error { return db.check(conn) }); err != nil {
 			return err
 		}
 
@@ -149,6 +178,12 @@ func (db *SQLiteDatabase) init(conn SQLiteConn) error {
 			db.logger.Infof("database at %q was last written by previous version %s", db.Path, db.WrittenVersion)
 		}
 
+		if db.MigrationBackups && len(db.PendingMigrations()) > 0 {
+			if err := db.migrationBackup(ctx, conn); err != nil {
+				return err
+			}
+		}
+
 		// Migrations may need to disable foreign keys, and to do so they have to be outside a transaction.
 		if err := db.migrate(conn); err != nil {
 			return err
@@ -170,6 +205,23 @@ func (db *SQLiteDatabase) init(conn SQLiteConn) error {
 	})
 }
 
+func (db *SQLiteDatabase) check(conn SQLiteConn) error {
+	if err := db.checkApplicationID(conn); err != nil {
+		return err
+	} else if err := db.getWrittenVersion(conn); err != nil {
+		return err
+	} else if db.WrittenVersion.Equal(SemVer{0, 0, 0}) && !db.Version.Equal(SemVer{0, 0, 0}) {
+		return fmt.Errorf("database at %q was already created but no version was set", db.Path)
+	} else if db.WrittenVersion.After(db.Version) {
+		return fmt.Errorf("database at %q was last written by version %s more recent than the current version", db.Path, db.WrittenVersion)
+	} else if err := db.quickCheck(conn); err != nil {
+		return err
+	} else if err := db.foreignKeysCheck(conn); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (db *SQLiteDatabase) checkApplicationID(conn SQLiteConn) error {
 	var appID int
 	err := conn.Select("PRAGMA application_id", func(stmt *SQLiteStmt) error {
@@ -219,26 +271,161 @@ func (db *SQLiteDatabase) setVersion(conn SQLiteConn, version SemVer) error {
 	return nil
 }
 
-func (db *SQLiteDatabase) migrate(conn SQLiteConn) error {
+// PendingMigrations returns the migrations from the version last written in the database to the current version.
+func (db *SQLiteDatabase) PendingMigrations() []SQLiteMigration {
+	var pending []SQLiteMigration
 	for _, migration := range db.Migrations {
 		// The migrations are supposed to be sorted from lowest to highest version,
 		// so there's no point in having a stop condition.
 		if migration.From.AfterOrEqual(db.WrittenVersion) && db.Version.AfterOrEqual(migration.To) {
-			db.logger.Infof("migrating database %q from version %s to %s", db.Path, migration.From, migration.To)
-			if err := migration.Exec(conn); err != nil {
-				return err
-			}
-			db.logger.Infof("migration of database %q from version %s to %s successful", db.Path, migration.From, migration.To)
-			// Set new version in case there's an error in the next loop,
-			// so that the user can easily retry the migration.
-			if err := db.setVersion(conn, migration.To); err != nil {
-				return err
-			}
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
+
+func (db *SQLiteDatabase) migrate(conn SQLiteConn) error {
+	for _, migration := range db.PendingMigrations() {
+		db.logger.Infof("migrating database %q from version %s to %s", db.Path, migration.From, migration.To)
+		if err := migration.Exec(conn); err != nil {
+			return err
+		}
+		db.logger.Infof("migration of database %q from version %s to %s successful", db.Path, migration.From, migration.To)
+		// Set new version in case there's an error in the next loop,
+		// so that the user can easily retry the migration.
+		if err := db.setVersion(conn, migration.To); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// MigrationBackupPath returns where the database is saved before being migrated or rolled back from a version.
+func (db *SQLiteDatabase) MigrationBackupPath(version SemVer) string {
+	return fmt.Sprintf("%s.%s.backup", db.Path, version)
+}
+
+func (db *SQLiteDatabase) migrationBackup(ctx context.Context, conn SQLiteConn) error {
+	path := db.MigrationBackupPath(db.WrittenVersion)
+	db.logger.Infof("backing up database %q of version %s to %q before changing its version", db.Path, db.WrittenVersion, path)
+	return db.Backup(ctx, conn, SQLiteBackupOptions{DestName: "main", DestPath: path, SrcName: "main"})
+}
+
+// DryRunMigrations applies the pending migrations to a temporary copy of the database, checks it, and deletes it,
+// so that errors can be found before migrating the database itself.
+// It returns the migrations that have been applied.
+func (db *SQLiteDatabase) DryRunMigrations(ctx context.Context, conn SQLiteConn) ([]SQLiteMigration, error) {
+	pending := db.PendingMigrations()
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
+	// The copy is next to the database rather than in the temporary directory, which may not be large enough.
+	dir, err := ioutil.TempDir(filepath.Dir(db.Path), filepath.Base(db.Path)+".dry-run-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	opts := SQLiteBackupOptions{DestName: "main", DestPath: filepath.Join(dir, filepath.Base(db.Path)), SrcName: "main"}
+	db.logger.Infof("copying database %q to %q for a dry run of its migrations", db.Path, opts.DestPath)
+	if err := db.Backup(ctx, conn, opts); err != nil {
+		return nil, err
+	}
+
+	copyOpts := db.SQLiteDatabaseOptions
+	copyOpts.MigrationBackups = false
+	copyOpts.Path = opts.DestPath
+	copyDB, copyConn, err := NewSQLiteDatabase(ctx, db.logger, copyOpts)
+	if err != nil {
+		return nil, fmt.Errorf("dry run of the migrations of database %q: %v", db.Path, err)
+	}
+	defer copyConn.Close()
+
+	err = copyConn.WithTx(func() error {
+		if err := copyDB.quickCheck(copyConn); err != nil {
+			return err
+		}
+		return copyDB.foreignKeysCheck(copyConn)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dry run of the migrations of database %q: %v", db.Path, err)
+	}
+	return pending, nil
+}
+
+// RollbackLimit returns the oldest version the database can be rolled back to,
+// which is the version it was last written by if the last migration can't be reverted.
+func (db *SQLiteDatabase) RollbackLimit() SemVer {
+	limit := db.WrittenVersion
+	for i := len(db.Migrations) - 1; i >= 0; i-- {
+		migration := db.Migrations[i]
+		if migration.To.After(limit) {
+			continue
+		} else if migration.Down == nil {
+			break
+		}
+		limit = migration.From
+	}
+	return limit
+}
+
+// Rollback reverts the migrations from the version last written in the database to an older version,
+// and writes that version, so that the database can be used by that version of the application.
+// It fails without modifying the database if a migration in between can't be reverted,
+// or if the version isn't the one a migration starts from, as the migrations would then be skipped on the next startup.
+// It returns the migrations that have been reverted, from the most recent.
+func (db *SQLiteDatabase) Rollback(ctx context.Context, conn SQLiteConn, to SemVer) ([]SQLiteMigration, error) {
+	if !db.WrittenVersion.After(to) {
+		return nil, fmt.Errorf("cannot roll back database %q from version %s to version %s, which isn't older", db.Path, db.WrittenVersion, to)
+	} else if limit := db.RollbackLimit(); limit.After(to) {
+		return nil, fmt.Errorf("cannot roll back database %q from version %s to version %s, it can't be older than version %s",
+			db.Path, db.WrittenVersion, to, limit)
+	}
+
+	var reverted []SQLiteMigration
+	for i := len(db.Migrations) - 1; i >= 0; i-- {
+		if migration := db.Migrations[i]; migration.To.After(to) && db.WrittenVersion.AfterOrEqual(migration.To) {
+			reverted = append(reverted, migration)
+		}
+	}
+	if len(reverted) > 0 {
+		if from := reverted[len(reverted)-1].From; !from.Equal(to) {
+			return nil, fmt.Errorf("cannot roll back database %q from version %s to version %s, "+
+				"it is in the middle of the migration from version %s to %s; roll back to version %s instead",
+				db.Path, db.WrittenVersion, to, from, reverted[len(reverted)-1].To, from)
+		}
+	}
+
+	if db.MigrationBackups {
+		if err := db.migrationBackup(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, migration := range reverted {
+		db.logger.Infof("rolling back database %q from version %s to %s", db.Path, migration.To, migration.From)
+		// Like migrations, rollbacks may need to disable foreign keys, so they run outside a transaction.
+		if err := migration.Down(conn); err != nil {
+			return nil, err
+		}
+		db.logger.Infof("rollback of database %q from version %s to %s successful", db.Path, migration.To, migration.From)
+		if err := db.setVersion(conn, migration.From); err != nil {
+			return nil, err
+		}
+	}
+
+	err := conn.WithTx(func() error {
+		if err := db.setVersion(conn, to); err != nil {
+			return err
+		} else if err := db.quickCheck(conn); err != nil {
+			return err
+		}
+		return db.foreignKeysCheck(conn)
+	})
+	return reverted, err
+}
+
 func (db *SQLiteDatabase) foreignKeysCheck(conn SQLiteConn) error {
 	var checks []error
 	err := conn.Select("PRAGMA foreign_key_check", func(stmt *SQLiteStmt) error {
@@ -272,7 +459,7 @@ func (db *SQLiteDatabase) quickCheck(conn SQLiteConn) error {
 		return err
 	}
 	if len(errs) > 0 {
-		return fmt.Errorf("integrity check error(s) in database %q: %v", db.Path, errs)
+		return fmt.Errorf("integrity check error(s) in database %q: %v", conn.Path(), errs)
 	}
 	return nil
 }
@@ -299,7 +486,8 @@ func (db *SQLiteDatabase) PeriodicCleanup(ctx context.Context) error {
 	return ctx.Err()
 }
 
-// Backup creates or clobbers a backup of the current database at the destination set in the SQLiteBackupOptinos.
+// Backup creates or clobbers a backup of the current database at the destination set in the SQLiteBackupOptinos,
+// and checks the integrity of the copy.
 func (db *SQLiteDatabase) Backup(ctx context.Context, srcConn SQLiteConn, opts SQLiteBackupOptions) error {
 	db.backups.Lock()
 	defer db.backups.Unlock()
@@ -334,8 +522,15 @@ func (db *SQLiteDatabase) Backup(ctx context.Context, srcConn SQLiteConn, opts S
 		}
 	}
 
-	if err != io.EOF {
-		db.logger.Debugf("error with backing up with connections %p and %p from %q to %q: %v", err)
+	if err == io.EOF {
+		// The destination connection can't be used until the backup is finished.
+		if err = backup.Close(); err == nil {
+			err = db.quickCheck(destConn)
+		}
+	} else {
+		db.logger.Debugf("error with backing up with connections %p and %p from %q to %q: %v", srcConn, destConn, db.Path, opts.DestPath, err)
+	}
+	if err != nil {
 		os.Remove(opts.DestPath)
 		return err
 	}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 )
 
@@ -10,26 +13,33 @@ const ApplicationFileID int = 0xdab
 
 // Storage is a collection of methods to write, update, and retrieve all persistent data used throughout the application.
 type Storage struct {
-	backupPath   string
-	backupMaxAge time.Duration
-	db           *SQLiteDatabase
-	kv           *KeyValueStore
-	logger       LevelLogger
+	backupCompression     BackupCompression
+	backupCreation        sync.Mutex   // Prevents several backups from being made at the same time
+	backupFiles           sync.RWMutex // Prevents reading a backup and its checksum while they are being replaced
+	backupInterval        time.Duration
+	backupPath            string
+	backupMaxAge          time.Duration
+	backupRetention       BackupRetentionConf
+	db                    *SQLiteDatabase
+	highScores            HighScores
+	kv                    *KeyValueStore
+	logger                LevelLogger
+	scoreHistoryRetention time.Duration
+	whoRegistered         WhoRegistered
 }
 
 // NewStorage returns a Storage instance after running initialization, checks, and migrations onto the target database file.
 // It returns the connection it needed to run the checks; if you are using a temporary database, keep it open until shut down.
 func NewStorage(ctx context.Context, logger LevelLogger, conf StorageConf) (*Storage, StorageConn, error) {
 	conn := StorageConn{}
-	db, baseConn, err := NewSQLiteDatabase(ctx, logger, SQLiteDatabaseOptions{
-		AppID:           ApplicationFileID,
-		CleanupInterval: conf.CleanupInterval.Value,
-		Migrations:      StorageMigrations,
-		Path:            conf.Path,
-		Retry:           conf.Retry,
-		Timeout:         conf.Timeout.Value,
-		Version:         Version,
-	})
+
+	if conf.BackupCompression == BackupCompressionZstd {
+		if err := CheckZstd("compressing backups with zstd"); err != nil {
+			return nil, conn, err
+		}
+	}
+
+	db, baseConn, err := NewSQLiteDatabase(ctx, logger, storageDatabaseOptions(conf))
 	if err != nil {
 		return nil, conn, err
 	}
@@ -41,24 +51,52 @@ func NewStorage(ctx context.Context, logger LevelLogger, conf StorageConf) (*Sto
 	}
 
 	s := &Storage{
-		backupMaxAge: conf.BackupMaxAge.Value,
-		backupPath:   conf.BackupPath,
-		db:           db,
-		kv:           kv,
-		logger:       logger,
-	}
-
-	if err := s.initTables(conn); err != nil {
-		return nil, conn, err
+		backupCompression:     conf.BackupCompression,
+		backupInterval:        conf.BackupInterval.Value,
+		backupMaxAge:          conf.BackupMaxAge.Value,
+		backupPath:            conf.BackupPath,
+		backupRetention:       conf.BackupRetention,
+		db:                    db,
+		highScores:            NewHighScores(kv),
+		kv:                    kv,
+		logger:                logger,
+		scoreHistoryRetention: conf.ScoreHistoryRetention.Value,
+		whoRegistered:         NewWhoRegistered(kv),
 	}
 
 	return s, conn, nil
 }
 
-func (s *Storage) initTables(conn SQLiteConn) error {
+// OpenStorageForMigrations opens the database of a Storage without migrating it,
+// so that its migrations can be listed, tried on a copy, or rolled back.
+func OpenStorageForMigrations(ctx context.Context, logger LevelLogger, conf StorageConf) (*SQLiteDatabase, SQLiteConn, error) {
+	return OpenSQLiteDatabaseForMigrations(ctx, logger, storageDatabaseOptions(conf))
+}
+
+func storageDatabaseOptions(conf StorageConf) SQLiteDatabaseOptions {
+	return SQLiteDatabaseOptions{
+		AppID:            ApplicationFileID,
+		CleanupInterval:  conf.CleanupInterval.Value,
+		InitHook:         initStorageTables,
+		MigrationBackups: true,
+		Migrations:       StorageMigrations,
+		Path:             conf.Path,
+		Retry:            conf.Retry,
+		Timeout:          conf.Timeout.Value,
+		Version:          Version,
+	}
+}
+
+func initStorageTables(conn SQLiteConn) error {
 	var queries []SQLQuery
 	queries = append(queries, User{}.InitializationQueries()...)
+	queries = append(queries, Submission{}.InitializationQueries()...)
 	queries = append(queries, Comment{}.InitializationQueries()...)
+	queries = append(queries, CommentScore{}.InitializationQueries()...)
+	queries = append(queries, CommentRevision{}.InitializationQueries()...)
+	queries = append(queries, UserKarma{}.InitializationQueries()...)
+	queries = append(queries, Candidate{}.InitializationQueries()...)
+	queries = append(queries, ScanPass{}.InitializationQueries()...)
 	if err := conn.MultiExec(queries); err != nil {
 		return err
 	}
@@ -70,14 +108,69 @@ func (s *Storage) KV() *KeyValueStore {
 	return s.kv
 }
 
+// HighScores returns the part of the key-value store that remembers the comments signaled as high scores.
+func (s *Storage) HighScores() HighScores {
+	return s.highScores
+}
+
+// WhoRegistered returns the part of the key-value store that remembers which Discord user registered each Reddit user.
+func (s *Storage) WhoRegistered() WhoRegistered {
+	return s.whoRegistered
+}
+
+// RenameUser changes the name of a User (case-sensitive) everywhere it is used, including in the key-value store,
+// in a single transaction.
+func (s *Storage) RenameUser(conn StorageConn, from, to string) error {
+	err := conn.WithTx(func() error {
+		if err := conn.RenameUser(from, to); err != nil {
+			return err
+		}
+		return s.whoRegistered.Rename(conn, from, to)
+	})
+	if err != nil {
+		// The cache of the key-value store may have been changed before the transaction was rolled back.
+		s.kv.Reload()
+	}
+	return err
+}
+
 // PeriodicCleanupIsEnabled tells if the setting for PeriodCleanup allow to run it.
 func (s *Storage) PeriodicCleanupIsEnabled() bool {
 	return s.db.CleanupInterval > 0
 }
 
-// PeriodicCleanup is a Task that periodically cleans up and optimizes the underlying database.
+// PeriodicCleanup is a Task that periodically cleans up and optimizes the underlying database,
+// deletes the values of the key-value store that have expired,
+// and thins out the history of the scores of comments that is older than the retention setting.
 func (s *Storage) PeriodicCleanup(ctx context.Context) error {
-	return s.db.PeriodicCleanup(ctx)
+	tasks := NewTaskGroup(ctx)
+	tasks.SpawnCtx(s.db.PeriodicCleanup)
+	tasks.SpawnCtx(func(ctx context.Context) error {
+		return s.WithConn(ctx, func(conn StorageConn) error {
+			for SleepCtx(ctx, s.db.CleanupInterval) {
+				nb, err := s.kv.Prune(conn, time.Now())
+				if err != nil {
+					return err
+				}
+				s.logger.Debugf("deleted %d expired values from the key-value store", nb)
+			}
+			return ctx.Err()
+		})
+	})
+	if s.scoreHistoryRetention > 0 {
+		tasks.SpawnCtx(func(ctx context.Context) error {
+			return s.WithConn(ctx, func(conn StorageConn) error {
+				for SleepCtx(ctx, s.db.CleanupInterval) {
+					s.logger.Debugf("thinning out the history of scores older than %s", s.scoreHistoryRetention)
+					if err := conn.ThinCommentScores(time.Now().Add(-s.scoreHistoryRetention)); err != nil {
+						return err
+					}
+				}
+				return ctx.Err()
+			})
+		})
+	}
+	return tasks.Wait().ToError()
 }
 
 // BackupPath returns the set path for backups.
@@ -85,19 +178,223 @@ func (s *Storage) BackupPath() string {
 	return s.backupPath
 }
 
-// Backup performs a backup on the destination returned by BackupPath.
+// BackupCompression returns the compression of the backups.
+func (s *Storage) BackupCompression() BackupCompression {
+	return s.backupCompression
+}
+
+// BackupsAreRetained tells if older backups are kept according to a retention policy.
+func (s *Storage) BackupsAreRetained() bool {
+	return s.backupRetention.Dir != ""
+}
+
+// Backup performs a backup on the destination returned by BackupPath if the last one is older than the set maximum age.
 func (s *Storage) Backup(ctx context.Context, conn StorageConn) error {
-	if older, err := FileOlderThan(s.BackupPath(), s.backupMaxAge); err != nil {
-		return err
-	} else if !older {
-		s.logger.Debugf("in Storage %p on %s, database backup was not older than %v, nothing was done", s, s.backupMaxAge)
-		return nil
+	return s.refreshBackup(ctx, conn, s.backupMaxAge)
+}
+
+// PeriodicBackupIsEnabled tells if the settings allow to run PeriodicBackup.
+func (s *Storage) PeriodicBackupIsEnabled() bool {
+	return s.backupInterval > 0
+}
+
+// PeriodicBackup is a Task that performs a backup at the set interval, starting with one if the last backup is older than that.
+// Failed backups are logged and don't stop the following ones.
+func (s *Storage) PeriodicBackup(ctx context.Context) error {
+	return s.WithConn(ctx, func(conn StorageConn) error {
+		for {
+			if err := s.refreshBackup(ctx, conn, s.backupInterval); IsCancellation(err) {
+				return err
+			} else if err != nil {
+				s.logger.Errorf("error when performing the scheduled backup of the database: %v", err)
+			}
+			if !SleepCtx(ctx, s.backupInterval) {
+				return ctx.Err()
+			}
+		}
+	})
+}
+
+func (s *Storage) refreshBackup(ctx context.Context, conn StorageConn, maxAge time.Duration) error {
+	s.backupCreation.Lock()
+	defer s.backupCreation.Unlock()
+
+	paths := []string{s.backupPath, s.backupPath + BackupChecksumExtension}
+	if s.backupCompression.Compressed() {
+		compressed := s.compressedBackupPath()
+		paths = append(paths, compressed, compressed+BackupChecksumExtension)
+	}
+	for _, path := range paths {
+		if older, err := FileOlderThan(path, maxAge); err != nil {
+			return err
+		} else if older {
+			return s.backup(ctx, conn)
+		}
 	}
-	return s.db.Backup(ctx, conn, SQLiteBackupOptions{
+	s.logger.Debugf("in Storage %p, database backup was not older than %v, nothing was done", s, maxAge)
+	return nil
+}
+
+// backup makes a backup of the database, checks it, compresses it, and publishes it with its checksum,
+// then adds it to the retained backups and deletes those that have expired.
+func (s *Storage) backup(ctx context.Context, conn StorageConn) error {
+	created := time.Now()
+	tmp := s.backupPath + BackupTemporaryExtension
+	defer os.Remove(tmp)
+
+	err := s.db.Backup(ctx, conn, SQLiteBackupOptions{
 		DestName: "main",
-		DestPath: s.BackupPath(),
+		DestPath: tmp,
 		SrcName:  "main",
 	})
+	if err != nil {
+		return err
+	}
+
+	published := s.backupPath
+	checksum, err := s.publishBackup(tmp, published)
+	if err != nil {
+		return err
+	}
+
+	if s.backupCompression.Compressed() {
+		published = s.compressedBackupPath()
+		tmp := published + BackupTemporaryExtension
+		defer os.Remove(tmp)
+		if err := s.backupCompression.Compress(ctx, s.backupPath, tmp); err != nil {
+			return err
+		}
+		if checksum, err = s.publishBackup(tmp, published); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Infof("backup of the database published at %q with SHA-256 checksum %s", published, checksum)
+
+	if s.BackupsAreRetained() {
+		return s.retainBackup(published, checksum, created)
+	}
+	return nil
+}
+
+// publishBackup computes the checksum of a temporary file, and moves it to its destination along with its checksum.
+func (s *Storage) publishBackup(tmp, dest string) (string, error) {
+	checksum, err := checksumFile(tmp)
+	if err != nil {
+		return "", err
+	}
+
+	s.backupFiles.Lock()
+	defer s.backupFiles.Unlock()
+
+	if err := os.Rename(tmp, dest); err != nil {
+		return "", err
+	}
+	return checksum, writeChecksumFile(dest, checksum)
+}
+
+func (s *Storage) retainBackup(path, checksum string, created time.Time) error {
+	dir := s.backupRetention.Dir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	dest := filepath.Join(dir, BackupFileName(created, s.backupCompression))
+	tmp := dest + BackupTemporaryExtension
+	defer os.Remove(tmp)
+	if err := linkOrCopyFile(path, tmp); err != nil {
+		return err
+	} else if err := os.Rename(tmp, dest); err != nil {
+		return err
+	} else if err := writeChecksumFile(dest, checksum); err != nil {
+		return err
+	}
+
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return err
+	}
+	for _, backup := range s.backupRetention.Expired(backups) {
+		s.logger.Infof("deleting expired backup %q", backup.Path)
+		// Delete the checksum first, so that the backup isn't listed anymore.
+		if err := os.Remove(backup.Path + BackupChecksumExtension); err != nil {
+			return err
+		} else if err := os.Remove(backup.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) compressedBackupPath() string {
+	return s.backupPath + s.backupCompression.Extension()
+}
+
+// LatestBackup opens the last published backup, the compressed one if asked and available.
+// The caller must close the returned file.
+func (s *Storage) LatestBackup(compressed bool) (BackupFile, *os.File, error) {
+	backup := BackupFile{Compression: BackupCompressionNone, Path: s.backupPath}
+	if compressed && s.backupCompression.Compressed() {
+		backup.Compression = s.backupCompression
+		backup.Path = s.compressedBackupPath()
+	}
+	backup.Name = filepath.Base(backup.Path)
+	return s.openBackup(backup)
+}
+
+// RetainedBackups lists the backups kept according to the retention policy, from the newest.
+func (s *Storage) RetainedBackups() ([]BackupFile, error) {
+	if !s.BackupsAreRetained() {
+		return nil, nil
+	}
+	return ListBackups(s.backupRetention.Dir)
+}
+
+// RetainedBackup opens the retained backup of the given file name, or returns ErrNoBackup.
+// The caller must close the returned file.
+func (s *Storage) RetainedBackup(name string) (BackupFile, *os.File, error) {
+	created, compression, ok := parseBackupFileName(name)
+	if !ok || !s.BackupsAreRetained() {
+		return BackupFile{}, nil, ErrNoBackup
+	}
+	return s.openBackup(BackupFile{
+		Compression: compression,
+		Created:     created,
+		Name:        name,
+		Path:        filepath.Join(s.backupRetention.Dir, name),
+	})
+}
+
+func (s *Storage) openBackup(backup BackupFile) (BackupFile, *os.File, error) {
+	s.backupFiles.RLock()
+	defer s.backupFiles.RUnlock()
+
+	checksum, err := readChecksumFile(backup.Path)
+	if os.IsNotExist(err) {
+		return backup, nil, ErrNoBackup
+	} else if err != nil {
+		return backup, nil, err
+	}
+	backup.Checksum = checksum
+
+	file, err := os.Open(backup.Path)
+	if os.IsNotExist(err) {
+		return backup, nil, ErrNoBackup
+	} else if err != nil {
+		return backup, nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return backup, nil, err
+	}
+	backup.Size = info.Size()
+	if backup.Created.IsZero() {
+		backup.Created = info.ModTime()
+	}
+
+	return backup, file, nil
 }
 
 // GetConn creates new connections to the associated database.
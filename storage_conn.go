@@ -1,11 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	sqlite "github.com/bvinc/go-sqlite-lite/sqlite3"
+	"strings"
 	"time"
 )
 
+// ErrNoUser is returned when a User that should be registered isn't, like when it has been purged in the meantime.
+var ErrNoUser = errors.New("no such user")
+
 // StorageConn is a database connection from a specific Storage with application-specific methods to query the database.
 // It implements SQLiteConn.
 type StorageConn struct {
@@ -48,12 +53,37 @@ func (conn StorageConn) ListActiveUsers() ([]User, error) {
 	return conn.users("SELECT * FROM users WHERE inactive IS FALSE AND suspended IS FALSE AND not_found IS FALSE ORDER BY last_scan")
 }
 
+// ListUsersDue lists at most limit users that are neither suspended nor deleted and are due to be scanned at a date,
+// ordered from the most overdue.
+func (conn StorageConn) ListUsersDue(date time.Time, limit uint) ([]User, error) {
+	return conn.users(`
+		SELECT * FROM users
+		WHERE suspended IS FALSE AND not_found IS FALSE AND next_scan <= ?
+		ORDER BY next_scan LIMIT ?`, date.Unix(), int(limit))
+}
+
 // ListRegisteredUsers returns all registered users, even if deleted or suspended, ordered from the most recently scanned.
 func (conn StorageConn) ListRegisteredUsers() ([]User, error) {
 	return conn.users("SELECT * FROM users ORDER BY last_scan DESC")
 }
 
-func (conn StorageConn) users(sql string) ([]User, error) {
+// ListAllUsers returns all users ever registered, including deleted ones.
+func (conn StorageConn) ListAllUsers() ([]User, error) {
+	return conn.users("SELECT * FROM user_archive ORDER BY name")
+}
+
+// ListUsersKarmaDue lists the users that are neither suspended nor deleted and whose karma hasn't been sampled since a date.
+func (conn StorageConn) ListUsersKarmaDue(since time.Time) ([]User, error) {
+	return conn.users(`
+		SELECT * FROM users
+		WHERE
+			suspended IS FALSE
+			AND not_found IS FALSE
+			AND NOT EXISTS (SELECT 1 FROM user_karma_history WHERE user_karma_history.name = users.name AND observed >= ?)
+		ORDER BY name`, since.Unix())
+}
+
+func (conn StorageConn) users(sql string, args ...interface{}) ([]User, error) {
 	var users []User
 	err := conn.Select(sql, func(stmt *SQLiteStmt) error {
 		user := &User{}
@@ -62,12 +92,46 @@ func (conn StorageConn) users(sql string) ([]User, error) {
 		}
 		users = append(users, *user)
 		return nil
-	})
+	}, args...)
 	return users, err
 }
 
+// KarmaHistory returns the samples of the karma of a User (case-insensitive), from the oldest to the newest.
+func (conn StorageConn) KarmaHistory(username string) ([]UserKarma, error) {
+	var history []UserKarma
+	err := conn.Select("SELECT * FROM user_karma_history WHERE name = ? COLLATE NOCASE ORDER BY observed ASC", func(stmt *SQLiteStmt) error {
+		var sample UserKarma
+		if err := sample.FromDB(stmt); err != nil {
+			return err
+		}
+		history = append(history, sample)
+		return nil
+	}, username)
+	return history, err
+}
+
+// UserActivity returns how many comments a User (case-sensitive) has made since a date, and how many of them have a negative score.
+func (conn StorageConn) UserActivity(username string, since time.Time) (uint64, uint64, error) {
+	var count, negative int64
+	sql := "SELECT COUNT(id), COUNT(CASE WHEN score < 0 THEN 1 ELSE NULL END) FROM comments WHERE author = ? AND created >= ?"
+	err := conn.Select(sql, func(stmt *SQLiteStmt) error {
+		var err error
+		if count, _, err = stmt.ColumnInt64(0); err != nil {
+			return err
+		}
+		negative, _, err = stmt.ColumnInt64(1)
+		return err
+	}, username, since.Unix())
+	return uint64(count), uint64(negative), err
+}
+
 // Write
 
+// ScheduleScan sets the date from which a User (case-sensitive) is due to be scanned again.
+func (conn StorageConn) ScheduleScan(username string, next time.Time) error {
+	return conn.Exec("UPDATE user_archive SET next_scan = ? WHERE name = ?", next.Unix(), username)
+}
+
 // UpdateInactiveStatus updates what is considered for a user to be "inactive",
 // that is, if they haven't posted since maxAge.
 func (conn StorageConn) UpdateInactiveStatus(maxAge time.Duration) error {
@@ -131,6 +195,38 @@ func (conn StorageConn) PurgeUser(username string) error {
 	return conn.simpleEditUser("DELETE FROM user_archive WHERE name = ? COLLATE NOCASE", username)
 }
 
+// RenameUser changes the name of a User (case-sensitive) in the tables that refer to it,
+// typically because Reddit changed its capitalization. It should be run within a transaction.
+func (conn StorageConn) RenameUser(from, to string) error {
+	// The foreign keys are only valid again once every table has been updated.
+	if err := conn.Exec("PRAGMA defer_foreign_keys = ON"); err != nil {
+		return err
+	}
+
+	if err := conn.Exec("UPDATE user_archive SET name = ? WHERE name = ?", to, from); err != nil {
+		return err
+	}
+	if conn.Changes() == 0 {
+		return ErrNoUser
+	}
+
+	for _, sql := range []string{
+		"UPDATE comments SET author = ? WHERE author = ?",
+		"UPDATE submissions SET author = ? WHERE author = ?",
+		"UPDATE user_karma_history SET name = ? WHERE name = ?",
+	} {
+		if err := conn.Exec(sql, to, from); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveKarma saves a sample of the karma of a User, whose name must have the same case as when it was registered.
+func (conn StorageConn) SaveKarma(sample UserKarma) error {
+	return conn.Exec("INSERT OR REPLACE INTO user_karma_history VALUES (?, ?, ?, ?, ?, ?, ?)", sample.ToDB()...)
+}
+
 func (conn StorageConn) simpleEditUser(sql, username string) error {
 	if err := conn.Exec(sql, username); err != nil {
 		return err
@@ -162,16 +258,24 @@ func (conn StorageConn) SaveCommentsUpdateUser(comments []Comment, user User, ma
 	}
 
 	err := conn.WithTx(func() error {
+		// The body of a removed comment is replaced by Reddit, so keep the last one we know of.
+		// A removal that was already seen keeps its date, and a comment that is reinstated isn't removed anymore.
 		stmt, err := conn.Prepare(`
-			INSERT INTO comments VALUES (?, ?, ?, ?, ?, ?, ?)
+			INSERT INTO comments VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 			ON CONFLICT(id) DO UPDATE SET
 				score=excluded.score,
-				body=excluded.body`)
+				body=CASE WHEN excluded.removed_by = "" THEN excluded.body ELSE comments.body END,
+				removed_by=CASE WHEN excluded.removed_by = "" OR comments.removed_by = "" THEN excluded.removed_by ELSE comments.removed_by END,
+				removed=CASE WHEN excluded.removed_by = "" OR comments.removed_by = "" THEN excluded.removed ELSE comments.removed END`)
 		if err != nil {
 			return err
 		}
 		defer stmt.Close()
 
+		if err := conn.saveCommentRevisions(comments); err != nil {
+			return err
+		}
+
 		for _, comment := range comments {
 			if err := stmt.Exec(comment.ToDB()...); err != nil {
 				return err
@@ -181,32 +285,24 @@ func (conn StorageConn) SaveCommentsUpdateUser(comments []Comment, user User, ma
 			}
 		}
 
+		if err := conn.saveCommentScores(comments); err != nil {
+			return err
+		}
+
 		// Frow now on we don't need to check for an error because if the user doesn't exist,
 		// then the constraints would have made the previous statement fail.
 
-		// We need to know how many relevant we got, and save it in the user's metadata.
-		// This way, the scanner can avoid fetching superfluous comments.
-		user.BatchSize = 0
-		for _, comment := range comments {
-			if time.Now().Sub(comment.Created) < maxAge {
-				user.BatchSize++
-			}
-		}
-
 		if user.New && user.Position == "" { // end of the listing reached
 			if err := conn.Exec("UPDATE user_archive SET new = FALSE WHERE name = ?", user.Name); err != nil {
 				return err
 			}
 		}
 
-		if !user.New && user.BatchSize < uint(len(comments)) { // position resetting doesn't apply to new users
-			user.Position = ""
-		}
-
-		// All comments are younger than maxAge, there may be more.
-		if user.BatchSize == uint(len(comments)) {
-			user.BatchSize = MaxRedditListingLength
+		dates := make([]time.Time, 0, len(comments))
+		for _, comment := range comments {
+			dates = append(dates, comment.Created)
 		}
+		user.BatchSize, user.Position = nextBatch(dates, maxAge, user.New, user.Position)
 
 		user.LastScan = time.Now()
 
@@ -217,6 +313,114 @@ func (conn StorageConn) SaveCommentsUpdateUser(comments []Comment, user User, ma
 	return user, err
 }
 
+// SaveArchivedComments saves comments from an archive, without changing those already saved,
+// and returns how many have been inserted.
+func (conn StorageConn) SaveArchivedComments(comments []Comment) (uint, error) {
+	var inserted uint
+	err := conn.WithTx(func() error {
+		stmt, err := conn.Prepare("INSERT INTO comments VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, comment := range comments {
+			if err := stmt.Exec(comment.ToDB()...); err != nil {
+				return err
+			}
+			inserted += uint(conn.Changes())
+			if err := stmt.ClearBindings(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return inserted, err
+}
+
+// StartBackfill marks a user as having listings of comments to backfill, starting with the first of RedditBackfillSorts.
+func (conn StorageConn) StartBackfill(username string) error {
+	return conn.Exec(`UPDATE user_archive SET backfill_sort = ?, backfill_position = "" WHERE name = ?`, RedditBackfillSorts[0], username)
+}
+
+// SaveBackfillUpdateUser saves the comments of a single user fetched to backfill its history, leaving alone
+// those that were already saved, and updates the progress of the backfill so that it can be resumed.
+// It returns the updated User data structure and the number of comments that weren't already saved.
+func (conn StorageConn) SaveBackfillUpdateUser(comments []Comment, user User) (User, uint, error) {
+	if user.Suspended {
+		return user, 0, conn.SuspendUser(user.Name)
+	} else if user.NotFound {
+		return user, 0, conn.NotFoundUser(user.Name)
+	}
+
+	var inserted uint
+	err := conn.WithTx(func() error {
+		stmt, err := conn.Prepare("INSERT INTO comments VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) ON CONFLICT(id) DO NOTHING")
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, comment := range comments {
+			if err := stmt.Exec(comment.ToDB()...); err != nil {
+				return err
+			}
+			inserted += uint(conn.Changes())
+			if err := stmt.ClearBindings(); err != nil {
+				return err
+			}
+		}
+
+		if err := conn.saveCommentScores(comments); err != nil {
+			return err
+		}
+
+		if user.BackfillPosition == "" { // end of the listing reached
+			user.BackfillSort = nextBackfillSort(user.BackfillSort)
+		}
+
+		sql := "UPDATE user_archive SET backfill_sort = ?, backfill_position = ? WHERE name = ?"
+		return conn.Exec(sql, user.BackfillSort, user.BackfillPosition, user.Name)
+	})
+
+	return user, inserted, err
+}
+
+// nextBackfillSort returns the sort of the listing to backfill after the given one, or an empty string if it was the last.
+func nextBackfillSort(backfillSort string) string {
+	sort, period := ParseBackfillSort(backfillSort)
+	for i, candidate := range RedditBackfillSorts {
+		if candidate == sort+":"+period && i+1 < len(RedditBackfillSorts) {
+			return RedditBackfillSorts[i+1]
+		}
+	}
+	return ""
+}
+
+// nextBatch returns the size of the next batch of a listing of a user and the position to fetch it from,
+// according to the creation dates of the items of the last batch.
+func nextBatch(dates []time.Time, maxAge time.Duration, isNew bool, position string) (uint, string) {
+	// We need to know how many relevant items we got, and save it in the user's metadata.
+	// This way, the scanner can avoid fetching superfluous items.
+	var size uint
+	for _, date := range dates {
+		if time.Now().Sub(date) < maxAge {
+			size++
+		}
+	}
+
+	if !isNew && size < uint(len(dates)) { // position resetting doesn't apply to new users
+		position = ""
+	}
+
+	// All items are younger than maxAge, there may be more.
+	if size == uint(len(dates)) {
+		size = MaxRedditListingLength
+	}
+
+	return size, position
+}
+
 // GetCommentsBelowBetween returns the comments below a score, between since and until.
 // To be used within a transaction.
 func (conn StorageConn) GetCommentsBelowBetween(score int64, since, until time.Time) ([]Comment, error) {
@@ -232,6 +436,200 @@ func (conn StorageConn) GetCommentsBelowBetween(score int64, since, until time.T
 		`, score, since.Unix(), until.Unix())
 }
 
+// CommentsToRefresh returns the comments between since and until whose score should be updated first,
+// that is the most negative ones, then the most recent ones, up to a number set by limit.
+func (conn StorageConn) CommentsToRefresh(since, until time.Time, limit uint) ([]Comment, error) {
+	return conn.comments(`
+			SELECT comments.*
+			FROM users JOIN comments
+			ON comments.author = users.name
+			WHERE comments.created BETWEEN ? AND ?
+			ORDER BY comments.score ASC, comments.created DESC
+			LIMIT ?
+		`, since.Unix(), until.Unix(), int(limit))
+}
+
+// UpdateCommentsScore updates the score of already saved comments, and whether they have been removed,
+// in the same way as SaveCommentsUpdateUser.
+// It returns the updated comments as they are saved, which keep their author and body
+// even if the given ones have been deleted since.
+func (conn StorageConn) UpdateCommentsScore(comments []Comment) ([]Comment, error) {
+	var updated []Comment
+	err := conn.WithTx(func() error {
+		stmt, err := conn.Prepare(`
+			UPDATE comments SET
+				score = ?2,
+				removed_by = CASE WHEN ?3 = "" OR removed_by = "" THEN ?3 ELSE removed_by END,
+				removed = CASE WHEN ?3 = "" OR removed_by = "" THEN ?4 ELSE removed END
+			WHERE id = ?1`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		var ids []string
+		for _, comment := range comments {
+			args := append([]interface{}{comment.ID, comment.Score}, comment.removalToDB()...)
+			if err := stmt.Exec(args...); err != nil {
+				return err
+			}
+			if conn.Changes() > 0 {
+				ids = append(ids, comment.ID)
+			}
+			if err := stmt.ClearBindings(); err != nil {
+				return err
+			}
+		}
+
+		if err := conn.saveCommentScores(comments); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if comment, exists, err := conn.GetComment(id); err != nil {
+				return err
+			} else if exists {
+				updated = append(updated, comment)
+			}
+		}
+		return nil
+	})
+	return updated, err
+}
+
+// saveCommentScores adds the scores of saved comments to their history if they changed since the last sample.
+// It must be called inside a transaction.
+func (conn StorageConn) saveCommentScores(comments []Comment) error {
+	stmt, err := conn.Prepare(`
+		INSERT OR REPLACE INTO comment_scores
+		SELECT ?1, ?2, ?3
+		WHERE
+			EXISTS (SELECT 1 FROM comments WHERE id = ?1)
+			AND (SELECT score FROM comment_scores WHERE id = ?1 ORDER BY observed DESC LIMIT 1) IS NOT ?2`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().Unix()
+	for _, comment := range comments {
+		if err := stmt.Exec(comment.ID, comment.Score, now); err != nil {
+			return err
+		}
+		if err := stmt.ClearBindings(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveCommentRevisions saves the current bodies of comments that are about to be replaced by different ones,
+// except when the comments have been removed, since their body is then kept.
+// It must be called inside a transaction, before the comments are saved.
+func (conn StorageConn) saveCommentRevisions(comments []Comment) error {
+	stmt, err := conn.Prepare(`
+		INSERT OR IGNORE INTO comment_revisions
+		SELECT id, body, ?3 FROM comments WHERE id = ?1 AND body != ?2`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now().Unix()
+	for _, comment := range comments {
+		if comment.RemovedBy != "" {
+			continue
+		}
+		if err := stmt.Exec(comment.ID, comment.Body, now); err != nil {
+			return err
+		}
+		if err := stmt.ClearBindings(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CommentRevisions returns the previous versions of the body of a comment, from the oldest to the newest.
+func (conn StorageConn) CommentRevisions(id string) ([]CommentRevision, error) {
+	var revisions []CommentRevision
+	err := conn.Select("SELECT * FROM comment_revisions WHERE id = ? ORDER BY replaced ASC", func(stmt *SQLiteStmt) error {
+		var revision CommentRevision
+		if err := revision.FromDB(stmt); err != nil {
+			return err
+		}
+		revisions = append(revisions, revision)
+		return nil
+	}, id)
+	return revisions, err
+}
+
+// RevisedComments tells which of the comments have had their body edited since they were first saved.
+func (conn StorageConn) RevisedComments(comments []Comment) (map[string]bool, error) {
+	// Stay well below the maximum number of variables of a statement.
+	const chunkSize = 500
+
+	revised := make(map[string]bool)
+	for start := 0; start < len(comments); start += chunkSize {
+		end := start + chunkSize
+		if end > len(comments) {
+			end = len(comments)
+		}
+
+		args := make([]interface{}, 0, end-start)
+		for _, comment := range comments[start:end] {
+			args = append(args, comment.ID)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+
+		sql := "SELECT DISTINCT id FROM comment_revisions WHERE id IN (" + placeholders + ")"
+		err := conn.Select(sql, func(stmt *SQLiteStmt) error {
+			id, _, err := stmt.ColumnText(0)
+			revised[id] = true
+			return err
+		}, args...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return revised, nil
+}
+
+// ThinCommentScores only keeps the last sample of each day in the history of the scores of comments before a date.
+func (conn StorageConn) ThinCommentScores(before time.Time) error {
+	return conn.Exec(`
+		DELETE FROM comment_scores
+		WHERE
+			observed < ?
+			AND observed < (
+				SELECT MAX(last.observed) FROM comment_scores AS last
+				WHERE last.id = comment_scores.id AND last.observed / 86400 = comment_scores.observed / 86400
+			)`, before.Unix())
+}
+
+// GetComment returns a single comment, and whether it exists.
+func (conn StorageConn) GetComment(id string) (Comment, bool, error) {
+	comments, err := conn.comments("SELECT * FROM comments WHERE id = ?", id)
+	if err != nil || len(comments) == 0 {
+		return Comment{}, false, err
+	}
+	return comments[0], true, nil
+}
+
+// CommentScores returns the history of the score of a comment, from the oldest to the newest sample.
+func (conn StorageConn) CommentScores(id string) ([]CommentScore, error) {
+	var scores []CommentScore
+	err := conn.Select("SELECT * FROM comment_scores WHERE id = ? ORDER BY observed ASC", func(stmt *SQLiteStmt) error {
+		var score CommentScore
+		if err := score.FromDB(stmt); err != nil {
+			return err
+		}
+		scores = append(scores, score)
+		return nil
+	}, id)
+	return scores, err
+}
+
 // Comments returns the most downvoted comments, up to a number set by the limit, with an offset.
 func (conn StorageConn) Comments(page Pagination) ([]Comment, error) {
 	return conn.comments(`
@@ -251,6 +649,59 @@ func (conn StorageConn) UserComments(username string, page Pagination) ([]Commen
 	return conn.comments(sql, username, int(page.Limit), int(page.Offset))
 }
 
+// Removals returns the comments of non-hidden users that have been removed, from the most recently removed,
+// up to a number set by the limit, with an offset.
+func (conn StorageConn) Removals(page Pagination) ([]Comment, error) {
+	return conn.comments(`
+			SELECT comments.*
+			FROM users JOIN comments
+			ON comments.author = users.name
+			WHERE
+				comments.removed_by != ""
+				AND users.hidden IS FALSE
+			ORDER BY comments.removed DESC LIMIT ? OFFSET ?
+		`, int(page.Limit), int(page.Offset))
+}
+
+// SubRemovals works like Removals but only for the comments of a subreddit (case-insensitive).
+func (conn StorageConn) SubRemovals(sub string, page Pagination) ([]Comment, error) {
+	return conn.comments(`
+			SELECT comments.*
+			FROM users JOIN comments
+			ON comments.author = users.name
+			WHERE
+				comments.removed_by != ""
+				AND comments.sub = ? COLLATE NOCASE
+				AND users.hidden IS FALSE
+			ORDER BY comments.removed DESC LIMIT ? OFFSET ?
+		`, sub, int(page.Limit), int(page.Offset))
+}
+
+// SearchComments returns the comments of non-hidden users that match a full-text search, from the most relevant,
+// up to a number set by the limit, with an offset.
+func (conn StorageConn) SearchComments(search CommentSearch, page Pagination) ([]Comment, error) {
+	match := search.MatchExpression()
+	if match == "" {
+		return nil, errors.New("a search needs at least one word")
+	}
+	args := append([]interface{}{match}, search.filtersToDB()...)
+	return conn.comments(`
+			SELECT comments.*
+			FROM comment_search
+				JOIN comment_search_ids ON comment_search_ids.search_id = comment_search.rowid
+				JOIN comments ON comments.id = comment_search_ids.id
+				JOIN users ON users.name = comments.author
+			WHERE
+				comment_search MATCH ?
+				AND users.hidden IS FALSE
+				AND (? = "" OR comments.author = ? COLLATE NOCASE)
+				AND (? = "" OR comments.sub = ? COLLATE NOCASE)
+				AND comments.score BETWEEN ? AND ?
+				AND comments.created >= ? AND comments.created < ?
+			ORDER BY comment_search.rank LIMIT ? OFFSET ?
+		`, append(args, int(page.Limit), int(page.Offset))...)
+}
+
 func (conn StorageConn) comments(sql string, args ...interface{}) ([]Comment, error) {
 	var comments []Comment
 	cb := func(stmt *SQLiteStmt) error {
@@ -265,10 +716,248 @@ func (conn StorageConn) comments(sql string, args ...interface{}) ([]Comment, er
 	return comments, err
 }
 
+/***********
+ Submissions
+************/
+
+// SaveSubmissionsUpdateUser saves submissions of a single user, and updates the user's metadata
+// about the scan of their submissions in the same way as SaveCommentsUpdateUser does for comments.
+// It doesn't change the date of the last scan, which is set by the scan of the comments.
+func (conn StorageConn) SaveSubmissionsUpdateUser(submissions []Submission, user User, maxAge time.Duration) (User, error) {
+	if user.Suspended {
+		return user, conn.SuspendUser(user.Name)
+	} else if user.NotFound {
+		return user, conn.NotFoundUser(user.Name)
+	}
+
+	err := conn.WithTx(func() error {
+		stmt, err := conn.Prepare(`
+			INSERT INTO submissions VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(id) DO UPDATE SET
+				score=excluded.score,
+				body=excluded.body`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, submission := range submissions {
+			if err := stmt.Exec(submission.ToDB()...); err != nil {
+				return err
+			}
+			if err := stmt.ClearBindings(); err != nil {
+				return err
+			}
+		}
+
+		if user.SubmissionsNew && user.SubmissionsPosition == "" {
+			if err := conn.Exec("UPDATE user_archive SET submissions_new = FALSE WHERE name = ?", user.Name); err != nil {
+				return err
+			}
+		}
+
+		dates := make([]time.Time, 0, len(submissions))
+		for _, submission := range submissions {
+			dates = append(dates, submission.Created)
+		}
+		user.SubmissionsBatchSize, user.SubmissionsPosition = nextBatch(dates, maxAge, user.SubmissionsNew, user.SubmissionsPosition)
+
+		sql := "UPDATE user_archive SET submissions_position = ?, submissions_batch_size = ? WHERE name = ?"
+		return conn.Exec(sql, user.SubmissionsPosition, int(user.SubmissionsBatchSize), user.Name)
+	})
+
+	return user, err
+}
+
+// GetSubmissionsBelowBetween returns the submissions below a score, between since and until.
+// To be used within a transaction.
+func (conn StorageConn) GetSubmissionsBelowBetween(score int64, since, until time.Time) ([]Submission, error) {
+	return conn.submissions(`
+			SELECT submissions.*
+			FROM users JOIN submissions
+			ON submissions.author = users.name
+			WHERE
+				submissions.score <= ?
+				AND users.hidden IS FALSE
+				AND submissions.created BETWEEN ? AND ?
+			ORDER BY submissions.score ASC
+		`, score, since.Unix(), until.Unix())
+}
+
+// Submissions returns the most downvoted submissions, up to a number set by the limit, with an offset.
+func (conn StorageConn) Submissions(page Pagination) ([]Submission, error) {
+	return conn.submissions(`
+			SELECT submissions.*
+			FROM users JOIN submissions
+			ON submissions.author = users.name
+			WHERE
+				submissions.score < 0
+				AND users.hidden IS FALSE
+			ORDER BY score ASC LIMIT ? OFFSET ?
+		`, int(page.Limit), int(page.Offset))
+}
+
+// UserSubmissions returns the most downvoted submissions of a single user, up to a number set by the limit, with an offset.
+func (conn StorageConn) UserSubmissions(username string, page Pagination) ([]Submission, error) {
+	sql := "SELECT * FROM submissions WHERE author = ? ORDER BY score ASC LIMIT ? OFFSET ?"
+	return conn.submissions(sql, username, int(page.Limit), int(page.Offset))
+}
+
+func (conn StorageConn) submissions(sql string, args ...interface{}) ([]Submission, error) {
+	var submissions []Submission
+	cb := func(stmt *SQLiteStmt) error {
+		submission := &Submission{}
+		if err := submission.FromDB(stmt); err != nil {
+			return err
+		}
+		submissions = append(submissions, *submission)
+		return nil
+	}
+	err := conn.Select(sql, cb, args...)
+	return submissions, err
+}
+
+/*********
+ Candidates
+**********/
+
+// SaveCandidates suggests the authors of comments for registration, unless they are already registered
+// or have been rejected, and keeps for each of them the comment with the lowest score.
+// It returns how many users weren't already suggested.
+func (conn StorageConn) SaveCandidates(comments []Comment) (uint, error) {
+	var added uint
+	err := conn.WithTx(func() error {
+		insert, err := conn.Prepare(`
+			INSERT INTO user_candidates(name, sub, score, permalink, found)
+			SELECT ?1, ?2, ?3, ?4, ?5
+			WHERE NOT EXISTS (SELECT 1 FROM user_archive WHERE name = ?1 COLLATE NOCASE)
+			ON CONFLICT(name) DO NOTHING`)
+		if err != nil {
+			return err
+		}
+		defer insert.Close()
+
+		update, err := conn.Prepare(`
+			UPDATE user_candidates SET sub = ?2, score = ?3, permalink = ?4
+			WHERE name = ?1 AND score > ?3 AND rejected = 0`)
+		if err != nil {
+			return err
+		}
+		defer update.Close()
+
+		now := time.Now().Unix()
+		for _, comment := range comments {
+			if err := insert.Exec(comment.Author, comment.Sub, comment.Score, comment.Permalink, now); err != nil {
+				return err
+			}
+			if conn.Changes() > 0 {
+				added++
+			} else if err := update.Exec(comment.Author, comment.Sub, comment.Score, comment.Permalink); err != nil {
+				return err
+			}
+			if err := insert.ClearBindings(); err != nil {
+				return err
+			}
+			if err := update.ClearBindings(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return added, err
+}
+
+// ListCandidates lists the pending candidates for registration, from the lowest score.
+func (conn StorageConn) ListCandidates() ([]Candidate, error) {
+	var candidates []Candidate
+	err := conn.Select(`
+		SELECT * FROM user_candidates
+		WHERE
+			rejected = 0
+			AND name NOT IN (SELECT name FROM user_archive)
+		ORDER BY score ASC`, func(stmt *SQLiteStmt) error {
+		var candidate Candidate
+		if err := candidate.FromDB(stmt); err != nil {
+			return err
+		}
+		candidates = append(candidates, candidate)
+		return nil
+	})
+	return candidates, err
+}
+
+// GetCandidate fetches a candidate for registration from a case-insensitive name, and returns whether it exists.
+func (conn StorageConn) GetCandidate(name string) (Candidate, bool, error) {
+	var candidate Candidate
+	err := conn.Select("SELECT * FROM user_candidates WHERE name = ?", candidate.FromDB, name)
+	return candidate, candidate.Name != "", err
+}
+
+// RejectCandidate marks a pending candidate for registration as rejected (case-insensitive),
+// so that it isn't suggested again.
+func (conn StorageConn) RejectCandidate(name string) error {
+	if err := conn.Exec("UPDATE user_candidates SET rejected = ? WHERE name = ? AND rejected = 0", time.Now().Unix(), name); err != nil {
+		return err
+	}
+	if conn.Changes() == 0 {
+		return fmt.Errorf("no pending candidate named %q", name)
+	}
+	return nil
+}
+
+// DelCandidate removes a user from the candidates for registration (case-insensitive), whether it is pending or not.
+func (conn StorageConn) DelCandidate(name string) error {
+	return conn.Exec("DELETE FROM user_candidates WHERE name = ?", name)
+}
+
+/***********
+ Scan passes
+************/
+
+// GetScanPass returns the state of the passes of the scanner of a kind, which is empty if there never was one.
+func (conn StorageConn) GetScanPass(kind string) (ScanPass, error) {
+	pass := ScanPass{Kind: kind}
+	err := conn.Select("SELECT * FROM scan_passes WHERE kind = ?", pass.FromDB, kind)
+	return pass, err
+}
+
+// SaveScanPass saves the state of the passes of the scanner of a kind.
+func (conn StorageConn) SaveScanPass(pass ScanPass) error {
+	return conn.Exec("INSERT OR REPLACE INTO scan_passes VALUES (?, ?, ?)", pass.ToDB()...)
+}
+
 /**********
  Statistics
 ***********/
 
+// RemovalsPerSub returns statistics about the removed comments of non-hidden users for each subreddit,
+// from the subreddit with the most removals.
+func (conn StorageConn) RemovalsPerSub() ([]RemovalStats, error) {
+	var stats []RemovalStats
+	err := conn.Select(`
+		SELECT
+			comments.sub,
+			SUM(comments.removed_by = ?),
+			SUM(comments.removed_by = ?),
+			MAX(comments.removed)
+		FROM users JOIN comments
+		ON comments.author = users.name
+		WHERE
+			comments.removed_by != ""
+			AND users.hidden IS FALSE
+		GROUP BY comments.sub
+		ORDER BY COUNT(*) DESC
+	`, func(stmt *SQLiteStmt) error {
+		var sub RemovalStats
+		if err := sub.FromDB(stmt); err != nil {
+			return err
+		}
+		stats = append(stats, sub)
+		return nil
+	}, RemovedByAuthor, RemovedByModerator)
+	return stats, err
+}
+
 // GetKarma returns the total and negative karma of a User (case-insensitive).
 func (conn StorageConn) GetKarma(username string) (int64, int64, error) {
 	sql := `
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 )
@@ -369,3 +370,359 @@ func TestCRUDComments(t *testing.T) {
 		}
 	})
 }
+
+func TestCommentScores(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	_, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	user := User{Name: "User1", Created: time.Now().Round(time.Second).Add(-time.Hour)}
+	if err := conn.AddUser(user.Name, false, user.Created); err != nil {
+		t.Fatal(err)
+	}
+
+	comment := Comment{
+		ID:        "comment1",
+		Author:    user.Name,
+		Score:     -10,
+		Permalink: "https://example.org/comment1",
+		Sub:       "A",
+		Created:   time.Now().Round(time.Second),
+		Body:      "This is a test comment.",
+	}
+
+	t.Run("record", func(t *testing.T) {
+		if _, err := conn.SaveCommentsUpdateUser([]Comment{comment}, user, 24*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		// Scores that didn't change aren't recorded again
+		if _, err := conn.UpdateCommentsScore([]Comment{comment}); err != nil {
+			t.Fatal(err)
+		}
+		// Comments that aren't saved are ignored
+		if updated, err := conn.UpdateCommentsScore([]Comment{{ID: "unknown", Score: 1}}); err != nil {
+			t.Fatal(err)
+		} else if len(updated) != 0 {
+			t.Errorf("no comment should have been updated, got %+v", updated)
+		}
+
+		scores, err := conn.CommentScores(comment.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(scores) != 1 || scores[0].Score != comment.Score {
+			t.Errorf("history should only contain the score %d, got %+v", comment.Score, scores)
+		}
+	})
+
+	t.Run("thin", func(t *testing.T) {
+		day := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < 4; i++ {
+			observed := day.Add(time.Duration(i) * 6 * time.Hour)
+			if err := conn.Exec("INSERT INTO comment_scores VALUES (?, ?, ?)", comment.ID, -i, observed.Unix()); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := conn.ThinCommentScores(time.Now().Add(-time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		scores, err := conn.CommentScores(comment.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(scores) != 2 {
+			t.Fatalf("history should contain the last sample of the old day and the recent sample, got %+v", scores)
+		}
+		if scores[0].Score != -3 || scores[1].Score != comment.Score {
+			t.Errorf("unexpected samples after thinning: %+v", scores)
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		if err := conn.PurgeUser(user.Name); err != nil {
+			t.Fatal(err)
+		}
+
+		scores, err := conn.CommentScores(comment.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(scores) != 0 {
+			t.Errorf("history should have been deleted with the comment, got %+v", scores)
+		}
+	})
+}
+
+func TestCommentRevisions(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	_, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	user := User{Name: "User1", Created: time.Now().Round(time.Second).Add(-time.Hour)}
+	if err := conn.AddUser(user.Name, false, user.Created); err != nil {
+		t.Fatal(err)
+	}
+
+	comment := Comment{
+		ID:        "comment1",
+		Author:    user.Name,
+		Score:     -10,
+		Permalink: "https://example.org/comment1",
+		Sub:       "A",
+		Created:   time.Now().Round(time.Second),
+		Body:      "This is a test comment.",
+	}
+	other := comment
+	other.ID = "comment2"
+
+	t.Run("record", func(t *testing.T) {
+		if _, err := conn.SaveCommentsUpdateUser([]Comment{comment, other}, user, 24*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		edited := comment
+		edited.Body = "This is an edited test comment."
+		removed := other
+		removed.Body = RedditRemovedBody
+		removed.RemovedBy = RemovedByModerator
+		removed.Removed = time.Now()
+		if _, err := conn.SaveCommentsUpdateUser([]Comment{edited, removed}, user, 24*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		// Bodies that didn't change aren't recorded again
+		if _, err := conn.SaveCommentsUpdateUser([]Comment{edited}, user, 24*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		revisions, err := conn.CommentRevisions(comment.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 1 || revisions[0].Body != comment.Body {
+			t.Errorf("history should only contain the body %q, got %+v", comment.Body, revisions)
+		}
+
+		// Removals aren't edits
+		revisions, err = conn.CommentRevisions(other.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 0 {
+			t.Errorf("history of a removed comment should be empty, got %+v", revisions)
+		}
+
+		revised, err := conn.RevisedComments([]Comment{comment, other})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !revised[comment.ID] || revised[other.ID] {
+			t.Errorf("only %q should be marked as revised, got %v", comment.ID, revised)
+		}
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		diff := NewTextDiff("This is a test comment.", "This is an edited test comment.")
+		var before, after strings.Builder
+		for _, segment := range diff {
+			if segment.Kind != DiffInsert {
+				before.WriteString(segment.Text)
+			}
+			if segment.Kind != DiffDelete {
+				after.WriteString(segment.Text)
+			}
+		}
+		if before.String() != "This is a test comment." || after.String() != "This is an edited test comment." {
+			t.Errorf("diff doesn't rebuild both texts: %+v", diff)
+		}
+		if len(diff) != 4 || diff[1].Kind != DiffDelete || diff[1].Text != "a" || diff[2].Kind != DiffInsert || diff[2].Text != "an edited" {
+			t.Errorf("unexpected diff: %+v", diff)
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		if err := conn.PurgeUser(user.Name); err != nil {
+			t.Fatal(err)
+		}
+
+		revisions, err := conn.CommentRevisions(comment.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) != 0 {
+			t.Errorf("history should have been deleted with the comment, got %+v", revisions)
+		}
+	})
+}
+
+func TestSearchComments(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	_, conn, err := NewStorage(ctx, NewTestLevelLogger(t), StorageConf{Path: ":memory:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	created := time.Now().Round(time.Second).Add(-time.Hour)
+	user := User{Name: "User1", Created: created}
+	hidden := User{Name: "Hidden", Created: created}
+	if err := conn.AddUser(user.Name, false, user.Created); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.AddUser(hidden.Name, true, hidden.Created); err != nil {
+		t.Fatal(err)
+	}
+
+	comments := []Comment{{
+		ID:      "comment1",
+		Author:  user.Name,
+		Score:   -10,
+		Sub:     "A",
+		Created: created.Add(-48 * time.Hour),
+		Body:    "The quick brown fox jumps over the lazy dog.",
+	}, {
+		ID:      "comment2",
+		Author:  user.Name,
+		Score:   5,
+		Sub:     "B",
+		Created: created,
+		Body:    "A lazy afternoon, lazy and quick.",
+	}, {
+		ID:      "comment3",
+		Author:  user.Name,
+		Score:   -2,
+		Sub:     "B",
+		Created: created,
+		Body:    "Nothing to see here.",
+	}}
+	if _, err := conn.SaveCommentsUpdateUser(comments, user, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	hiddenComment := Comment{ID: "comment4", Author: hidden.Name, Sub: "A", Created: created, Body: "A lazy comment."}
+	if _, err := conn.SaveCommentsUpdateUser([]Comment{hiddenComment}, hidden, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(t *testing.T, search CommentSearch, expected ...string) {
+		t.Helper()
+		results, err := conn.SearchComments(search, Pagination{Limit: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, 0, len(results))
+		for _, comment := range results {
+			ids = append(ids, comment.ID)
+		}
+		if strings.Join(ids, ",") != strings.Join(expected, ",") {
+			t.Errorf("search %+v should return %v, got %v", search, expected, ids)
+		}
+	}
+
+	t.Run("match", func(t *testing.T) {
+		// The comment with more occurrences of the word comes first, and hidden users are left out.
+		check(t, NewCommentSearch("lazy"), "comment2", "comment1")
+		check(t, NewCommentSearch("QUICK fox"), "comment1")
+		check(t, NewCommentSearch(`"lazy dog"`), "comment1")
+		check(t, NewCommentSearch(`"dog lazy"`))
+		check(t, NewCommentSearch("after*"), "comment2")
+		check(t, NewCommentSearch("afternoon*"), "comment2")
+		check(t, NewCommentSearch("unknown"))
+	})
+
+	t.Run("syntax", func(t *testing.T) {
+		// The syntax of FTS5 is ignored.
+		check(t, NewCommentSearch(`fox OR "nothing`))
+		check(t, NewCommentSearch(`NEAR(fox) body:lazy "`))
+		if _, err := conn.SearchComments(NewCommentSearch(` " * `), Pagination{Limit: 10}); err == nil {
+			t.Error("a search without any word should be refused")
+		}
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		search := NewCommentSearch("lazy")
+		search.Author = "user1"
+		check(t, search, "comment2", "comment1")
+
+		search = NewCommentSearch("lazy")
+		search.Sub = "a"
+		check(t, search, "comment1")
+
+		search = NewCommentSearch("lazy")
+		if err := search.SetFilter("max_score", "-10", time.UTC); err != nil {
+			t.Fatal(err)
+		}
+		check(t, search, "comment1")
+
+		search = NewCommentSearch("lazy")
+		search.MinScore = 0
+		check(t, search, "comment2")
+
+		search = NewCommentSearch("lazy")
+		search.Since = created.Add(-time.Hour)
+		check(t, search, "comment2")
+
+		search = NewCommentSearch("lazy")
+		search.Until = created.Add(-time.Hour)
+		check(t, search, "comment1")
+
+		if err := search.SetFilter("since", "yesterday", time.UTC); err == nil {
+			t.Error("invalid dates should be refused")
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		results, err := conn.SearchComments(NewCommentSearch("lazy"), Pagination{Limit: 1, Offset: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].ID != "comment1" {
+			t.Errorf("second page should only contain comment1, got %+v", results)
+		}
+	})
+
+	t.Run("edit", func(t *testing.T) {
+		edited := comments[2]
+		edited.Body = "Something to see here, for a lazy reader."
+		if _, err := conn.SaveCommentsUpdateUser([]Comment{edited}, user, 24*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		check(t, NewCommentSearch("nothing"))
+		check(t, NewCommentSearch("reader"), "comment3")
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		if err := conn.PurgeUser(user.Name); err != nil {
+			t.Fatal(err)
+		}
+		check(t, NewCommentSearch("lazy"))
+
+		var nb int64
+		err := conn.Select("SELECT COUNT(*) FROM comment_search_ids", func(stmt *SQLiteStmt) error {
+			var err error
+			nb, _, err = stmt.ColumnInt64(0)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nb != 1 {
+			t.Errorf("only the comment of the hidden user should be left in the index, got %d", nb)
+		}
+	})
+}
@@ -24,7 +24,11 @@ func (tmpl *HTMLTemplate) MustAddParse(name, body string) *HTMLTemplate {
 }
 
 // HTMLTemplates regroups every HTML template so as to easily share common snippets.
-var HTMLTemplates = NewHTMLTemplate("Root").MustAddParse("BackToTop",
+var HTMLTemplates = NewHTMLTemplate("Root").MustAddParse("Removal",
+	`{{if .RemovedBy}} <span class="removed" title="since {{.Removed.Format "2006-01-02 15:04 MST"}}">{{.Removal}}</span>{{end}}`,
+).MustAddParse("Edited",
+	`{{if .Revised}} <a class="edited" href="/compendium/comment/{{.ID}}/history">edited</a>{{end}}`,
+).MustAddParse("BackToTop",
 	`<footer><a href="#title">back to top</a></footer>`,
 ).MustAddParse("DeltaTable",
 	`<table>
@@ -89,6 +93,9 @@ var HTMLTemplates = NewHTMLTemplate("Root").MustAddParse("BackToTop",
 		<li><a href="/reports/{{.Year}}/{{.Week}}#delta">Top negative karma change</a></li>
 		<li><a href="/reports/{{.Year}}/{{.Week}}#average">Top average per comment</a></li>
 		<li><a href="/reports/{{.Year}}/{{.Week}}#comments">Comments</a></li>
+		{{- if .SubmissionsLen}}
+		<li><a href="/reports/{{.Year}}/{{.Week}}#submissions">Submissions</a></li>
+		{{- end}}
 	</ul>
 </nav>
 
@@ -97,6 +104,9 @@ var HTMLTemplates = NewHTMLTemplate("Root").MustAddParse("BackToTop",
 {{- with .Header}}
 	{{- $dateFormat := "02 Jan 06 15:04 MST"}}
 	<p><strong>{{.Len}}</strong> comments under {{.CutOff}} from {{.Start.Format $dateFormat}} to {{.End.Format $dateFormat}}.</p>
+	{{- if .SubmissionsLen}}
+	<p><strong>{{.SubmissionsLen}}</strong> submissions under {{.SubmissionsCutOff}}.</p>
+	{{- end}}
 	<p>Collective karma change for the week: <strong>{{.Global.Sum}}</strong>.</p>
 	<p><a href="/reports/stats/{{.Year}}/{{.Week}}">Complete statistics for the week.</a></p>
 
@@ -116,7 +126,7 @@ var HTMLTemplates = NewHTMLTemplate("Root").MustAddParse("BackToTop",
 <h1 id="comments">Comments</h1>
 {{range .Comments -}}
 <article class="comment">
-	<h2 id="{{.Number}}"><a href="#{{.Number}}">#{{.Number}}</a></h2>
+	<h2 id="{{.Number}}"><a href="#{{.Number}}">#{{.Number}}</a>{{template "Removal" .}}{{template "Edited" .}}</h2>
 	<table>
 	<tr>
 		<td>Author</td>
@@ -143,6 +153,13 @@ var HTMLTemplates = NewHTMLTemplate("Root").MustAddParse("BackToTop",
 {{end -}}
 </main>
 
+{{- if .SubmissionsLen}}
+<section>
+<h1 id="submissions">Submissions</h1>
+{{template "Submissions" .Submissions}}
+</section>
+{{- end}}
+
 {{template "BackToTop"}}
 
 </body>
@@ -192,12 +209,40 @@ var HTMLTemplates = NewHTMLTemplate("Root").MustAddParse("BackToTop",
 ).MustAddParse("UserComments",
 	`{{range .}}
 <article class="comment">
-	<h2 id="{{.Number}}"><a href="#{{.Number}}">#{{.Number}}</a></h2>
+	<h2 id="{{.Number}}"><a href="#{{.Number}}">#{{.Number}}</a>{{template "Removal" .}}{{template "Edited" .}}</h2>
 	<table>
 	<tr>
 		<td>Date</td>
 		<td>{{.Created.Format "Monday 02 January 2006 15:04 MST"}}</td>
 	</tr>
+	<tr>
+		<td>Score</td>
+		<td>{{.Score}} <a href="/compendium/comment/{{.ID}}">(timeline)</a></td>
+	</tr>
+	<tr>
+		<td>Link</td>
+		<td><a href="https://www.reddit.com{{.Permalink}}">{{.Permalink}}</a></td>
+	</tr>
+	</table>
+
+	<blockquote>
+{{.BodyConvert}}
+	</blockquote>
+</article>
+{{end}}`,
+).MustAddParse("Submissions",
+	`{{range .}}
+<article class="comment">
+	<h2 id="submission-{{.Number}}"><a href="#submission-{{.Number}}">#{{.Number}}</a> {{.Title}}</h2>
+	<table>
+	<tr>
+		<td>Author</td>
+		<td><a href="/compendium/user/{{.Author}}">{{.Author}}</a></td>
+	</tr>
+	<tr>
+		<td>Date</td>
+		<td>{{.Created.Format "Monday 02 January 2006 15:04 MST"}}</td>
+	</tr>
 	<tr>
 		<td>Score</td>
 		<td>{{.Score}}</td>
@@ -207,10 +252,12 @@ var HTMLTemplates = NewHTMLTemplate("Root").MustAddParse("BackToTop",
 		<td><a href="https://www.reddit.com{{.Permalink}}">{{.Permalink}}</a></td>
 	</tr>
 	</table>
+	{{- if .Body}}
 
 	<blockquote>
 {{.BodyConvert}}
 	</blockquote>
+	{{- end}}
 </article>
 {{end}}`,
 ).MustAddParse("CompendiumStatsPerSub",
@@ -263,6 +310,9 @@ var HTMLTemplates = NewHTMLTemplate("Root").MustAddParse("BackToTop",
 		{{if .CommentsLen -}}
 		<li><a href="/compendium/user/{{.User.Name}}#top">Most downvoted</a></li>
 		{{- end}}
+		{{if .SubmissionsLen -}}
+		<li><a href="/compendium/user/{{.User.Name}}#top-submissions">Most downvoted submissions</a></li>
+		{{- end}}
 		{{if .Negative -}}
 		<li><a href="/compendium/user/{{.User.Name}}#named-negative">Negative per sub</a></li>
 		{{- end}}
@@ -282,6 +332,8 @@ var HTMLTemplates = NewHTMLTemplate("Root").MustAddParse("BackToTop",
 		<p><strong>Account deleted</strong></p>
 	{{else if .User.New -}}
 		<p><em>Not fully scanned yet.</em></p>
+	{{else if .User.BackfillSort -}}
+		<p><em>Older comments are still being retrieved.</em></p>
 	{{end -}}
 	{{$dateFormat := "Monday 02 January 2006 15:04 MST"}}
 	<table>
@@ -325,7 +377,36 @@ var HTMLTemplates = NewHTMLTemplate("Root").MustAddParse("BackToTop",
 			<td><strong>{{.Summary.Average}}</strong>{{if .SummaryNegative.Count}}, and <strong>{{.SummaryNegative.Average}}</strong> if negative only{{end}}<td>
 		</tr>
 		{{- end}}
+		{{- with .Karma}}{{if .Exists}}
+		<tr>
+			<td>Karma according to Reddit<td>
+			<td><strong>{{.Latest.CommentKarma}}</strong> from comments and <strong>{{.Latest.LinkKarma}}</strong> from submissions<td>
+		</tr>
+		<tr>
+			<td>Change of that karma<td>
+			<td>
+				<strong>{{if ge .CommentChange 0}}+{{end}}{{.CommentChange}}</strong> from comments and
+				<strong>{{if ge .LinkChange 0}}+{{end}}{{.LinkChange}}</strong> from submissions
+				since {{.Since.Format $dateFormat}}
+			<td>
+		</tr>
+		{{- with .Latest.Status}}
+		<tr>
+			<td>Account<td>
+			<td>{{.}}<td>
+		</tr>
+		{{- end}}
+		{{- end}}{{end}}
 	</table>
+	{{- if gt .KarmaHistoryLen 1}}
+	{{with .KarmaTimeline}}
+	<svg class="timeline" viewBox="0 0 {{.Width}} {{.Height}}" preserveAspectRatio="none" role="img">
+		<title>Karma of the comments according to Reddit, from {{.Min}} to {{.Max}}</title>
+		{{if .HasZero}}<line class="zero" x1="0" y1="{{.Zero}}" x2="{{.Width}}" y2="{{.Zero}}"/>{{end}}
+		<polyline points="{{.Points}}"/>
+	</svg>
+	{{end}}
+	{{- end}}
 </article>
 </header>
 
@@ -345,6 +426,15 @@ var HTMLTemplates = NewHTMLTemplate("Root").MustAddParse("BackToTop",
 </section>
 {{- end}}
 
+{{if .SubmissionsLen -}}
+<section>
+<h1 id="top-submissions">Most downvoted submissions</h1>
+<p>First {{.SubmissionsLen}} submissions.</p>
+{{template "Submissions" .Submissions}}
+{{template "BackToTop"}}
+</section>
+{{- end}}
+
 {{if .Negative -}}
 <section>
 <h1 id="named-negative">Negative per sub</h1>
@@ -395,7 +485,7 @@ Next {{.CommentsLen}} comments &rarr;
 ).MustAddParse("Comments",
 	`{{range .}}
 <article class="comment">
-	<h2 id="{{.Number}}"><a href="#{{.Number}}">#{{.Number}}</a></h2>
+	<h2 id="{{.Number}}"><a href="#{{.Number}}">#{{.Number}}</a>{{template "Removal" .}}{{template "Edited" .}}</h2>
 	<table>
 	<tr>
 		<td>Author</td>
@@ -407,7 +497,7 @@ Next {{.CommentsLen}} comments &rarr;
 	</tr>
 	<tr>
 		<td>Score</td>
-		<td>{{.Score}}</td>
+		<td>{{.Score}} <a href="/compendium/comment/{{.ID}}">(timeline)</a></td>
 	</tr>
 	<tr>
 		<td>Link</td>
@@ -471,6 +561,9 @@ Next {{.CommentsLen}} comments &rarr;
 	<ul>
 		<li><a href="/compendium#summary">Summary</a></li>
 		<li><a href="/compendium#top">Most downvoted</a></li>
+		{{- if .SubmissionsLen}}
+		<li><a href="/compendium#top-submissions">Most downvoted submissions</a></li>
+		{{- end}}
 		<li><a href="/compendium#named-negative">Negative karma per user</a></li>
 		<li><a href="/compendium#named">Karma per user</a></li>
 	</ul>
@@ -497,12 +590,21 @@ Next {{.CommentsLen}} comments &rarr;
 <section>
 <h1 id="top">Most downvoted</h1>
 <p>Top {{.CommentsLen}} most downvoted comments.</p>
-<p><a href="/compendium/comments">All comments.</a></p>
+<p><a href="/compendium/comments">All comments.</a> <a href="/compendium/removals">Removed comments.</a> <a href="/compendium/search">Search.</a></p>
 {{template "Comments" .Comments}}
 {{template "BackToTop"}}
 </section>
 {{end -}}
 
+{{if .SubmissionsLen -}}
+<section>
+<h1 id="top-submissions">Most downvoted submissions</h1>
+<p>Top {{.SubmissionsLen}} most downvoted submissions.</p>
+{{template "Submissions" .Submissions}}
+{{template "BackToTop"}}
+</section>
+{{end -}}
+
 {{if .Negative -}}
 <section>
 <h1 id="named-negative">Negative karma per user</h1>
@@ -555,6 +657,290 @@ Next {{.CommentsLen}} comments &rarr;
 <p>No comment yet.</p>
 {{end -}}
 </html>`,
+).MustAddParse("CompendiumComment",
+	`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8"/>
+	<meta name="viewport" content="initial-scale=1"/>
+	<title>Score of a comment of {{.Comment.Author}}</title>
+	<link rel="stylesheet" href="/css/main?version={{.Version}}">
+	<link rel="stylesheet" href="/css/compendium?version={{.Version}}">
+</head>
+<body>
+<div id="title"><a href="/compendium/user/{{.Comment.Author}}">Score of a comment of {{.Comment.Author}}</a></div>
+
+{{template "Comments" .Comments}}
+
+<section>
+<h2 id="timeline">Timeline</h2>
+{{with .Timeline}}
+<svg class="timeline" viewBox="0 0 {{.Width}} {{.Height}}" preserveAspectRatio="none" role="img">
+	<title>Score from {{.Min}} to {{.Max}}</title>
+	{{if .HasZero}}<line class="zero" x1="0" y1="{{.Zero}}" x2="{{.Width}}" y2="{{.Zero}}"/>{{end}}
+	<polyline points="{{.Points}}"/>
+</svg>
+{{end}}
+<table>
+<thead>
+<tr>
+	<th>Date</th>
+	<th>Score</th>
+</tr>
+</thead>
+<tbody>
+{{range .Scores -}}
+<tr>
+	<td>{{.Observed.Format "2006-01-02 15:04 MST"}}</td>
+	<td>{{.Score}}</td>
+</tr>
+{{end -}}
+</tbody>
+</table>
+</section>
+
+{{template "BackToTop"}}
+</body>
+</html>`,
+).MustAddParse("CompendiumCommentHistory",
+	`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8"/>
+	<meta name="viewport" content="initial-scale=1"/>
+	<title>History of a comment of {{.Comment.Author}}</title>
+	<link rel="stylesheet" href="/css/main?version={{.Version}}">
+	<link rel="stylesheet" href="/css/compendium?version={{.Version}}">
+</head>
+<body>
+<div id="title"><a href="/compendium/comment/{{.Comment.ID}}">History of a comment of {{.Comment.Author}}</a></div>
+
+{{template "Comments" .Comments}}
+
+<section>
+<h1 id="versions">Versions</h1>
+{{if .Revisions -}}
+{{range .Versions}}
+<article class="comment">
+	<h2 id="version-{{.Number}}"><a href="#version-{{.Number}}">Version {{.Number}}</a>
+	{{- if .Current}} (current){{else}}, replaced on {{.Replaced.Format "Monday 02 January 2006 15:04 MST"}}{{end}}</h2>
+	<blockquote class="diff">
+	{{- range .Diff -}}
+		{{if eq .Kind "insert"}}<ins>{{.Text}}</ins>{{else if eq .Kind "delete"}}<del>{{.Text}}</del>{{else}}{{.Text}}{{end}}
+	{{- end -}}
+	</blockquote>
+</article>
+{{end}}
+{{- else}}
+<p>This comment hasn't been edited since it was first saved.</p>
+{{end -}}
+</section>
+
+{{template "BackToTop"}}
+</body>
+</html>`,
+).MustAddParse("CompendiumRemovals",
+	`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8"/>
+	<meta name="viewport" content="initial-scale=1"/>
+	<title>Removed comments{{if .Sub}} in /r/{{.Sub}}{{end}}</title>
+	<link rel="stylesheet" href="/css/main?version={{.Version}}">
+</head>
+<body>
+<div id="title"><a href="/compendium{{if .Sub}}/removals{{end}}">Removed comments{{if .Sub}} in /r/{{.Sub}}{{end}}</a></div>
+
+{{if .Subs -}}
+<section>
+<h1 id="subs">Per sub</h1>
+<table class="large">
+<thead>
+<tr>
+	<th>Sub</th>
+	<th>Removed by moderators</th>
+	<th>Deleted by authors</th>
+	<th>Last removal</th>
+</tr>
+</thead>
+<tbody>
+{{range .Subs -}}
+<tr>
+	<td><a href="/compendium/removals/{{.Sub}}">{{.Sub}}</a></td>
+	<td>{{.ByModerator}}</td>
+	<td>{{.ByAuthor}}</td>
+	<td>
+		<span class="detail">{{.Latest.Format "15:04"}}</span>
+		<span>{{.Latest.Format "2006-01-02"}}</span>
+		<span class="detail">{{.Latest.Format "MST"}}</span>
+	</td>
+</tr>
+{{end -}}
+</tbody>
+</table>
+</section>
+{{- end}}
+
+{{if .CommentsLen}}
+<section>
+<h1 id="comments">Latest removals</h1>
+{{if eq (.CommentsLen) (.NbTop) -}}
+<nav>
+<a href="/compendium/removals{{if .Sub}}/{{.Sub}}{{end}}?limit={{.CommentsLen}}&offset={{.NextOffset}}">
+Next {{.CommentsLen}} comments &rarr;
+</a>
+</nav>
+{{end -}}
+
+{{template "Comments" .Comments}}
+
+{{template "BackToTop"}}
+</section>
+{{- else}}
+<p>No removed comment yet.</p>
+{{end -}}
+</body>
+</html>`,
+).MustAddParse("CompendiumSearch",
+	`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8"/>
+	<meta name="viewport" content="initial-scale=1"/>
+	<title>Search{{if .Searched}}: {{.Search.Query}}{{end}}</title>
+	<link rel="stylesheet" href="/css/main?version={{.Version}}">
+	<link rel="stylesheet" href="/css/compendium?version={{.Version}}">
+</head>
+<body>
+<div id="title"><a href="/compendium">Search</a></div>
+
+<form class="search" method="get" action="/compendium/search">
+	<label>Words <input type="search" name="q" value="{{.Params.Get "q"}}" required></label>
+	<label>Author <input type="text" name="author" value="{{.Params.Get "author"}}"></label>
+	<label>Sub <input type="text" name="sub" value="{{.Params.Get "sub"}}"></label>
+	<label>Minimum score <input type="number" name="min_score" value="{{.Params.Get "min_score"}}"></label>
+	<label>Maximum score <input type="number" name="max_score" value="{{.Params.Get "max_score"}}"></label>
+	<label>Since <input type="date" name="since" value="{{.Params.Get "since"}}"></label>
+	<label>Until <input type="date" name="until" value="{{.Params.Get "until"}}"></label>
+	<button type="submit">Search</button>
+</form>
+<p>All the words must be in the comments; put phrases between double quotes, and end a word with * to search for words that start with it.</p>
+
+{{if .Searched -}}
+{{if .CommentsLen}}
+<section>
+<h1 id="results">Results</h1>
+{{if eq (.CommentsLen) (.NbTop) -}}
+<nav>
+<a href="{{.NextURL}}">
+Next {{.CommentsLen}} comments &rarr;
+</a>
+</nav>
+{{end -}}
+
+{{template "Comments" .Comments}}
+
+{{template "BackToTop"}}
+</section>
+{{- else}}
+<p>No comment found.</p>
+{{end -}}
+{{end -}}
+</body>
+</html>`,
+).MustAddParse("CompendiumCandidates",
+	`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8"/>
+	<meta name="viewport" content="initial-scale=1"/>
+	<title>Candidates for registration</title>
+	<link rel="stylesheet" href="/css/main?version={{.Version}}">
+	<link rel="stylesheet" href="/css/compendium?version={{.Version}}">
+</head>
+<body>
+<div id="title"><a href="/compendium">Candidates for registration</a></div>
+
+{{if .Candidates -}}
+<section>
+<h1 id="candidates">Pending</h1>
+<table class="large">
+<thead>
+<tr>
+	<th>Name</th>
+	<th>Lowest score</th>
+	<th>Found</th>
+	<th>Decision</th>
+</tr>
+</thead>
+<tbody>
+{{range .Candidates -}}
+<tr>
+	<td><a href="https://www.reddit.com/u/{{.Name}}">{{.Name}}</a></td>
+	<td><a href="https://www.reddit.com{{.Permalink}}">{{.Score}}</a> in /r/{{.Sub}}</td>
+	<td>
+		<span class="detail">{{.Found.Format "15:04"}}</span>
+		<span>{{.Found.Format "2006-01-02"}}</span>
+		<span class="detail">{{.Found.Format "MST"}}</span>
+	</td>
+	<td>
+		<form class="decision" method="post" action="/compendium/candidates">
+			<input type="hidden" name="name" value="{{.Name}}">
+			<label class="detail"><input type="checkbox" name="hidden" value="1"> hidden</label>
+			<button type="submit" name="action" value="accept">Accept</button>
+			<button type="submit" name="action" value="reject">Reject</button>
+		</form>
+	</td>
+</tr>
+{{end -}}
+</tbody>
+</table>
+</section>
+{{- else}}
+<p>There is no pending candidate for registration.</p>
+{{end -}}
+</body>
+</html>`,
+).MustAddParse("BackupsIndex",
+	`<!DOCTYPE html>
+<html lang="en">
+<head>
+	<meta charset="utf-8"/>
+	<meta name="viewport" content="initial-scale=1"/>
+	<title>Backups of the database</title>
+	<link rel="stylesheet" href="/css/main?version={{.Version}}">
+</head>
+<body>
+<div id="title"><a href="/backups">Backups of the database</a></div>
+
+<p>Latest backup: <a href="/backup">/backup</a>.</p>
+
+{{if .Backups -}}
+<table class="large">
+<thead>
+<tr>
+	<th>File</th>
+	<th>Date</th>
+	<th>Size</th>
+	<th>SHA-256</th>
+</tr>
+</thead>
+<tbody>
+{{range .Backups -}}
+<tr>
+	<td><a href="/backups/{{.Name}}">{{.Name}}</a></td>
+	<td>{{.Created.Format "2006-01-02 15:04 MST"}}</td>
+	<td>{{.HumanSize}}</td>
+	<td><a href="/backups/{{.Name}}.sha256"><code>{{.Checksum}}</code></a></td>
+</tr>
+{{end -}}
+</tbody>
+</table>
+{{- else}}
+<p>There is no retained backup yet.</p>
+{{end -}}
+</body>
+</html>`,
 )
 
 // CSSMain is the main CSS stylesheet, to be served along the result of the HTML templates.
@@ -622,6 +1008,11 @@ h2 {
 	text-decoration: underline;
 }
 
+.removed, .edited {
+	color: crimson;
+	font-size: 0.8em;
+}
+
 .comment > blockquote, .comment a { overflow-wrap: break-word }
 .comment a { word-break: break-all }
 
@@ -708,6 +1099,50 @@ aside.md-link a::after {
 // CSSCompendium is the CSS stylesheet to be served with the HTML compendium pages.
 const CSSCompendium = `.suspended {
 	color: crimson;
+}
+
+form.decision {
+	display: flex;
+	gap: var(--spacing);
+	align-items: center;
+}
+
+form.search {
+	display: flex;
+	flex-wrap: wrap;
+	gap: var(--spacing);
+	align-items: center;
+}
+
+.diff {
+	white-space: pre-wrap;
+}
+
+.diff ins {
+	background: #dfd;
+	text-decoration: none;
+}
+
+.diff del {
+	background: #fdd;
+}
+
+.timeline {
+	height: 8em;
+	width: 100%;
+}
+
+.timeline polyline {
+	fill: none;
+	stroke: crimson;
+	stroke-width: 2;
+	vector-effect: non-scaling-stroke;
+}
+
+.timeline .zero {
+	stroke: grey;
+	stroke-dasharray: 4;
+	vector-effect: non-scaling-stroke;
 }`
 
 // MarkdownReport is the template for reports in markdow format.
@@ -715,6 +1150,10 @@ var MarkdownReport = text.Must(text.New("MarkdownReport").Parse(`
 {{- with .Header -}}
 {{- $dateFormat := "02 Jan 06 15:04 MST"}}
 **{{.Len}}** comments under {{.CutOff}} from {{.Start.Format $dateFormat}} to {{.End.Format $dateFormat}}.
+{{- if .SubmissionsLen}}
+
+**{{.SubmissionsLen}}** submissions under {{.SubmissionsCutOff}}.
+{{- end}}
 
 Top {{.Delta | len}} total negative karma change:
 {{range .Delta}}
@@ -737,12 +1176,82 @@ by [/u/{{.Name}}](https://www.reddit.com/user/{{.Name}})
 Author: [/u/{{.Author}}](https://www.reddit.com/user/{{.Author}}) ({{.Stats.Average}} week average)
 
 Score: **{{.Score}}**
+{{- if .RemovedBy}}
+
+Status: *{{.Removal}}*
+{{- end}}
 
 Link: [{{.Permalink}}](https://np.reddit.com{{.Permalink}})
 
-Comment text:
+Comment text{{if .Revised}} (edited since it was first seen){{end}}:
 
 {{range .BodyLines -}}
 > {{.}}
 {{end}}
-{{end}}`))
+{{end}}
+{{- if .SubmissionsLen}}
+* * *
+
+{{range .Submissions -}}
+# Submission \#{{.Number}}
+
+Author: [/u/{{.Author}}](https://www.reddit.com/user/{{.Author}})
+
+Score: **{{.Score}}**
+
+Title: [{{.Title}}](https://np.reddit.com{{.Permalink}})
+{{- if .Body}}
+
+Submission text:
+
+{{range .BodyLines -}}
+> {{.}}
+{{end}}
+{{- end}}
+
+{{end}}
+{{- end}}`))
+
+// MarkdownCompendium is the template for the index of the compendium in markdown format, such as for the wiki of a subreddit.
+var MarkdownCompendium = text.Must(text.New("MarkdownCompendium").Parse(`
+{{- $dateFormat := "02 Jan 06 15:04 MST" -}}
+**{{.Users | len}}** registered users, of which {{.HiddenUsersLen}} are hidden.
+{{- if .CommentsLen}}
+
+* * *
+
+Top {{.CommentsLen}} most downvoted comments:
+
+{{range .Comments -}}
+# \#{{.Number}}
+
+Author: [/u/{{.Author}}](https://www.reddit.com/user/{{.Author}})
+
+Score: **{{.Score}}**
+{{- if .RemovedBy}}
+
+Status: *{{.Removal}}*
+{{- end}}
+
+Link: [{{.Permalink}}](https://np.reddit.com{{.Permalink}})
+
+Comment text{{if .Revised}} (edited since it was first seen){{end}}:
+
+{{range .BodyLines -}}
+> {{.}}
+{{end}}
+{{end -}}
+{{- end}}
+{{- if .Negative}}
+* * *
+
+Negative karma per user:
+
+Rank|User|Karma|Count|Average|Last commented
+-:|:-|-:|-:|-:|:-
+{{range .Negative -}}
+{{if .Count -}}
+{{.Number}}|[/u/{{.Name}}](https://www.reddit.com/user/{{.Name}})|{{.Sum}}|{{.Count}}|{{.Average}}|{{.Latest.Format $dateFormat}}
+{{end -}}
+{{end}}
+{{- end}}`))
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -82,6 +84,23 @@ func SemVerFromInt(encoded int) SemVer {
 	return SemVer{byte(major), byte(minor), byte(patch)}
 }
 
+// ParseSemVer reads a SemVer in the format "major.minor.patch".
+func ParseSemVer(raw string) (SemVer, error) {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(raw), "v"), ".")
+	if len(parts) != 3 {
+		return SemVer{}, fmt.Errorf("invalid version %q, it must be in the format \"major.minor.patch\"", raw)
+	}
+	var v SemVer
+	for i, part := range parts {
+		nb, err := strconv.ParseUint(part, 10, 8)
+		if err != nil {
+			return SemVer{}, fmt.Errorf("invalid version %q: %v", raw, err)
+		}
+		v[i] = byte(nb)
+	}
+	return v, nil
+}
+
 // Sort allows to sort anything without copy-pasting nor generics.
 type Sort struct {
 	Len  func() int
@@ -3,6 +3,7 @@ package main
 import (
 	"compress/gzip"
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"github.com/russross/blackfriday/v2"
@@ -74,17 +75,19 @@ func (w *ResponseWriter) WriteHeader(statusCode int) {
 
 // ServeMux is a minimal wrapper for http.ServeMux uses our ResponseWriter
 type ServeMux struct {
-	actual   *http.ServeMux
-	logger   LevelLogger
-	IPHeader string
+	actual       *http.ServeMux
+	logger       LevelLogger
+	uncompressed map[string]bool // Patterns whose handlers manage the content coding of their responses
+	IPHeader     string
 }
 
 // NewServeMux returns a ServeMux wrapping an http.NewServeMux.
 func NewServeMux(logger LevelLogger, ipHeader string) *ServeMux {
 	return &ServeMux{
-		actual:   http.NewServeMux(),
-		logger:   logger,
-		IPHeader: ipHeader,
+		actual:       http.NewServeMux(),
+		logger:       logger,
+		uncompressed: make(map[string]bool),
+		IPHeader:     ipHeader,
 	}
 }
 
@@ -93,6 +96,13 @@ func (mux *ServeMux) HandleFunc(pattern string, handler func(http.ResponseWriter
 	mux.actual.HandleFunc(pattern, handler)
 }
 
+// HandleFuncUncompressed is like HandleFunc, for handlers whose responses must not be compressed on the fly,
+// like files served in ranges.
+func (mux *ServeMux) HandleFuncUncompressed(pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	mux.uncompressed[pattern] = true
+	mux.actual.HandleFunc(pattern, handler)
+}
+
 // Handle wrapper.
 func (mux *ServeMux) Handle(pattern string, handler http.Handler) {
 	mux.actual.Handle(pattern, handler)
@@ -112,6 +122,10 @@ func (mux *ServeMux) ServeHTTP(baseWriter http.ResponseWriter, r *http.Request)
 		return fmt.Sprintf("serve %s %s for %s with user agent %q",
 			r.Method, r.URL, getIP(r, mux.IPHeader), r.Header.Get("User-Agent"))
 	})
+	if _, pattern := mux.actual.Handler(r); mux.uncompressed[pattern] {
+		mux.actual.ServeHTTP(baseWriter, r)
+		return
+	}
 	w := NewResponseWriter(baseWriter, r)
 	mux.actual.ServeHTTP(w, r)
 	if err := w.Close(); err != nil {
@@ -130,6 +144,7 @@ func getIP(r *http.Request, header string) string {
 type WebServer struct {
 	sync.Mutex
 	WebConf
+	addUser    AddRedditUser
 	compendium CompendiumFactory
 	conns      StorageConnPool
 	logger     LevelLogger
@@ -139,9 +154,18 @@ type WebServer struct {
 }
 
 // NewWebServer creates a new WebServer.
-func NewWebServer(logger LevelLogger, storage *Storage, reports ReportFactory, compendium CompendiumFactory, conf WebConf) *WebServer {
+// The function to add users is used to accept candidates for registration, and can be nil if Reddit is unavailable.
+func NewWebServer(
+	logger LevelLogger,
+	storage *Storage,
+	reports ReportFactory,
+	compendium CompendiumFactory,
+	addUser AddRedditUser,
+	conf WebConf,
+) *WebServer {
 	wsrv := &WebServer{
 		WebConf:    conf,
+		addUser:    addUser,
 		compendium: compendium,
 		logger:     logger,
 		reports:    reports,
@@ -158,9 +182,20 @@ func NewWebServer(logger LevelLogger, storage *Storage, reports ReportFactory, c
 	mux.HandleFunc("/reports/stats/", wsrv.ReportStats)
 	mux.HandleFunc("/compendium", wsrv.CompendiumIndex)
 	mux.HandleFunc("/compendium/user/", wsrv.CompendiumUser)
+	mux.HandleFunc("/compendium/comment/", wsrv.CompendiumComment)
 	mux.HandleFunc("/compendium/comments", wsrv.CompendiumComments)
+	mux.HandleFunc("/compendium/removals", wsrv.CompendiumRemovals)
+	mux.HandleFunc("/compendium/removals/", wsrv.CompendiumRemovals)
 	mux.HandleFunc("/compendium/comments/user/", wsrv.CompendiumUserComments)
-	mux.HandleFunc("/backup", wsrv.Backup)
+	mux.HandleFunc("/compendium/search", wsrv.CompendiumSearch)
+	if len(conf.PrivilegedUsers) > 0 {
+		mux.HandleFunc("/compendium/candidates", wsrv.privileged(wsrv.CompendiumCandidates))
+	}
+	mux.HandleFuncUncompressed("/backup", wsrv.Backup)
+	if storage.BackupsAreRetained() {
+		mux.HandleFunc("/backups", wsrv.BackupsIndex)
+		mux.HandleFuncUncompressed("/backups/", wsrv.RetainedBackup)
+	}
 	if conf.RootDir != "" {
 		wsrv.logger.Infof("serving directory %q", wsrv.RootDir)
 		mux.Handle("/", http.FileServer(http.Dir(wsrv.RootDir)))
@@ -278,7 +313,7 @@ func (wsrv *WebServer) ReportSource(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		wsrv.err(w, r, err, http.StatusInternalServerError)
 		return
-	} else if report.Len() == 0 {
+	} else if report.Len() == 0 && report.SubmissionsLen() == 0 {
 		wsrv.errMsg(w, r, fmt.Sprintf("Empty report for %d/%d.", year, week), http.StatusNotFound)
 		return
 	}
@@ -334,7 +369,7 @@ func (wsrv *WebServer) Report(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		wsrv.err(w, r, err, http.StatusInternalServerError)
 		return
-	} else if report.Len() == 0 {
+	} else if report.Len() == 0 && report.SubmissionsLen() == 0 {
 		wsrv.errMsg(w, r, fmt.Sprintf("Empty report for %d/%d.", year, week), http.StatusNotFound)
 		return
 	}
@@ -409,6 +444,12 @@ func (wsrv *WebServer) CompendiumUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Serve each user from a single URL, with the capitalization of the name as registered.
+	if name := stats.User().Name; name != username {
+		http.Redirect(w, r, "/compendium/user/"+name, http.StatusTemporaryRedirect)
+		return
+	}
+
 	stats.CommentBodyConverter = wsrv.commentBodyConverter
 
 	w.Header().Set("Content-Type", "text/html")
@@ -452,6 +493,12 @@ func (wsrv *WebServer) CompendiumUserComments(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	if name := comments.User().Name; name != username {
+		target := &url.URL{Path: "/compendium/comments/user/" + name, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusTemporaryRedirect)
+		return
+	}
+
 	comments.CommentBodyConverter = wsrv.commentBodyConverter
 
 	w.Header().Set("Content-Type", "text/html")
@@ -460,6 +507,119 @@ func (wsrv *WebServer) CompendiumUserComments(w http.ResponseWriter, r *http.Req
 	}
 }
 
+// CompendiumComment serves the page of a single comment with the timeline of its score, whose ID is taken from the URL,
+// or the history of its edits if the URL ends with "/history".
+func (wsrv *WebServer) CompendiumComment(w http.ResponseWriter, r *http.Request) {
+	args := ignoreTrailing(subPath("/compendium/comment/", r))
+	if len(args) == 2 && args[1] == "history" {
+		wsrv.compendiumCommentHistory(w, r, args[0])
+		return
+	} else if len(args) != 1 {
+		msg := "invalid URL, use \"/compendium/comment/id\" to view the timeline of the comment \"id\", " +
+			"and \"/compendium/comment/id/history\" to view the history of its edits"
+		wsrv.errMsg(w, r, msg, http.StatusBadRequest)
+		return
+	}
+
+	id := args[0]
+	var comment CompendiumComment
+
+	err := wsrv.conns.WithConn(r.Context(), func(conn StorageConn) error {
+		var err error
+		comment, err = wsrv.compendium.Comment(conn, id)
+		if err != nil {
+			wsrv.err(w, r, err, http.StatusInternalServerError)
+			return ErrSentinel
+		} else if !comment.Exists() {
+			wsrv.errMsg(w, r, fmt.Sprintf("Comment %q doesn't exist.", id), http.StatusNotFound)
+			return ErrSentinel
+		}
+		return nil
+	})
+	if err != nil {
+		wsrv.err(w, r, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	comment.CommentBodyConverter = wsrv.commentBodyConverter
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := HTMLTemplates.ExecuteTemplate(w, "CompendiumComment", comment); err != nil {
+		panic(err)
+	}
+}
+
+// CompendiumRemovals serves the paginated HTML document of the removed comments from non-hidden users,
+// either with statistics per subreddit, or for a single subreddit whose name is taken from the URL.
+func (wsrv *WebServer) CompendiumRemovals(w http.ResponseWriter, r *http.Request) {
+	var sub string
+	if strings.HasPrefix(r.URL.Path, "/compendium/removals/") {
+		args := ignoreTrailing(subPath("/compendium/removals/", r))
+		if len(args) != 1 {
+			msg := "invalid URL, use \"/compendium/removals/sub\" to view the removed comments in \"sub\""
+			wsrv.errMsg(w, r, msg, http.StatusBadRequest)
+			return
+		}
+		sub = args[0]
+	}
+
+	page, err := wsrv.pagination(r.URL.Query())
+	if err != nil {
+		wsrv.err(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	var removals CompendiumRemovals
+	err = wsrv.conns.WithConn(r.Context(), func(conn StorageConn) error {
+		var err error
+		removals, err = wsrv.compendium.Removals(conn, sub, page)
+		if err != nil {
+			wsrv.err(w, r, err, http.StatusInternalServerError)
+			return ErrSentinel
+		}
+		return nil
+	})
+	if err != nil {
+		wsrv.err(w, r, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	removals.CommentBodyConverter = wsrv.commentBodyConverter
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := HTMLTemplates.ExecuteTemplate(w, "CompendiumRemovals", removals); err != nil {
+		panic(err)
+	}
+}
+
+func (wsrv *WebServer) compendiumCommentHistory(w http.ResponseWriter, r *http.Request, id string) {
+	var history CompendiumCommentHistory
+
+	err := wsrv.conns.WithConn(r.Context(), func(conn StorageConn) error {
+		var err error
+		history, err = wsrv.compendium.CommentHistory(conn, id)
+		if err != nil {
+			wsrv.err(w, r, err, http.StatusInternalServerError)
+			return ErrSentinel
+		} else if !history.Exists() {
+			wsrv.errMsg(w, r, fmt.Sprintf("Comment %q doesn't exist.", id), http.StatusNotFound)
+			return ErrSentinel
+		}
+		return nil
+	})
+	if err != nil {
+		wsrv.err(w, r, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	history.CommentBodyConverter = wsrv.commentBodyConverter
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := HTMLTemplates.ExecuteTemplate(w, "CompendiumCommentHistory", history); err != nil {
+		panic(err)
+	}
+}
+
 // CompendiumComments serves the paginated HTML document of all known comments from non-hidden users.
 func (wsrv *WebServer) CompendiumComments(w http.ResponseWriter, r *http.Request) {
 	page, err := wsrv.pagination(r.URL.Query())
@@ -491,7 +651,147 @@ func (wsrv *WebServer) CompendiumComments(w http.ResponseWriter, r *http.Request
 	}
 }
 
-// Backup triggers a backup if needed, and serves it.
+// CompendiumSearch serves the paginated HTML document of the full-text search in the comments of non-hidden users,
+// with the words to search in the parameter "q" of the URL, and filters in the parameters of CommentSearchFilters.
+func (wsrv *WebServer) CompendiumSearch(w http.ResponseWriter, r *http.Request) {
+	urlQuery := r.URL.Query()
+
+	page, err := wsrv.pagination(urlQuery)
+	if err != nil {
+		wsrv.err(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	search := NewCommentSearch(urlQuery.Get("q"))
+	for _, name := range CommentSearchFilters {
+		// Forms send empty fields along the others.
+		if value := strings.TrimSpace(urlQuery.Get(name)); value != "" {
+			if err := search.SetFilter(name, value, wsrv.compendium.Timezone); err != nil {
+				wsrv.err(w, r, err, http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	var results CompendiumSearch
+	err = wsrv.conns.WithConn(r.Context(), func(conn StorageConn) error {
+		var err error
+		results, err = wsrv.compendium.Search(conn, search, page)
+		if err != nil {
+			wsrv.err(w, r, err, http.StatusInternalServerError)
+			return ErrSentinel
+		}
+		return nil
+	})
+	if err != nil {
+		wsrv.err(w, r, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	results.CommentBodyConverter = wsrv.commentBodyConverter
+	results.Params = urlQuery
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := HTMLTemplates.ExecuteTemplate(w, "CompendiumSearch", results); err != nil {
+		panic(err)
+	}
+}
+
+// CompendiumCandidates serves the page of the pending candidates for registration,
+// and accepts or rejects candidates with the fields "action" and "name" of a form sent with POST.
+func (wsrv *WebServer) CompendiumCandidates(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		wsrv.decideCandidate(w, r)
+		return
+	} else if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		wsrv.errMsg(w, r, fmt.Sprintf("Method %s isn't allowed.", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var candidates CompendiumCandidates
+	err := wsrv.conns.WithConn(r.Context(), func(conn StorageConn) error {
+		var err error
+		candidates, err = wsrv.compendium.Candidates(conn)
+		return err
+	})
+	if err != nil {
+		wsrv.err(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := HTMLTemplates.ExecuteTemplate(w, "CompendiumCandidates", candidates); err != nil {
+		panic(err)
+	}
+}
+
+// decideCandidate accepts or rejects a candidate. Unlike the registrations from Discord, the privileged user
+// who accepted it isn't remembered as the one who registered it, since only Discord users can be mentioned
+// in the information about a user; the decision is only logged.
+func (wsrv *WebServer) decideCandidate(w http.ResponseWriter, r *http.Request) {
+	// Forms can be sent from anywhere, so make sure that it isn't another site that sends it on behalf of a privileged user.
+	// Browsers may leave out the origin, in which case the referrer is used, and requests without either are refused.
+	source := r.Header.Get("Origin")
+	if source == "" || source == "null" {
+		source = r.Header.Get("Referer")
+	}
+	if parsed, err := url.Parse(source); source == "" || err != nil || parsed.Host != r.Host {
+		wsrv.errMsg(w, r, "Forms can only be sent from this site.", http.StatusForbidden)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		wsrv.err(w, r, err, http.StatusBadRequest)
+		return
+	}
+	name := TrimUsername(strings.TrimSpace(r.PostForm.Get("name")))
+	if name == "" {
+		wsrv.errMsg(w, r, "The name of the candidate is missing.", http.StatusBadRequest)
+		return
+	}
+
+	var decide func(StorageConn) error
+	switch action := r.PostForm.Get("action"); action {
+	case "accept":
+		if wsrv.addUser == nil {
+			wsrv.errMsg(w, r, "Registration service is unavailable.", http.StatusServiceUnavailable)
+			return
+		}
+		hidden := r.PostForm.Get("hidden") != ""
+		decide = func(conn StorageConn) error {
+			query := AcceptCandidate(r.Context(), conn, wsrv.addUser, name, hidden)
+			if query.Error == nil && !query.Exists {
+				return fmt.Errorf("user %q not found on Reddit", name)
+			}
+			return query.Error
+		}
+	case "reject":
+		decide = func(conn StorageConn) error { return conn.RejectCandidate(name) }
+	default:
+		wsrv.errMsg(w, r, fmt.Sprintf("Invalid action %q, it must be either \"accept\" or \"reject\".", action), http.StatusBadRequest)
+		return
+	}
+
+	user, _, _ := r.BasicAuth()
+	wsrv.logger.Infof("%s wants to %s candidate %q", user, r.PostForm.Get("action"), name)
+
+	err := wsrv.conns.WithConn(r.Context(), func(conn StorageConn) error {
+		if err := conn.WithTx(func() error { return decide(conn) }); err != nil {
+			wsrv.err(w, r, err, http.StatusBadRequest)
+			return ErrSentinel
+		}
+		return nil
+	})
+	if err != nil {
+		wsrv.err(w, r, err, http.StatusServiceUnavailable)
+		return
+	}
+
+	http.Redirect(w, r, "/compendium/candidates", http.StatusSeeOther)
+}
+
+// Backup triggers a backup if needed, and serves it, compressed if the client accepts the compression of the backups.
+// Ranges can be requested, and the entity tag is the SHA-256 checksum of the file that is served.
 func (wsrv *WebServer) Backup(w http.ResponseWriter, r *http.Request) {
 	err := wsrv.conns.WithConn(r.Context(), func(conn StorageConn) error {
 		return wsrv.storage.Backup(r.Context(), conn)
@@ -500,8 +800,97 @@ func (wsrv *WebServer) Backup(w http.ResponseWriter, r *http.Request) {
 		wsrv.err(w, r, err, http.StatusInternalServerError)
 		return
 	}
+
+	encoding := wsrv.storage.BackupCompression().Encoding()
+	compressed := encoding != "" && acceptsEncoding(r.Header.Get("Accept-Encoding"), encoding)
+	backup, file, err := wsrv.storage.LatestBackup(compressed)
+	if err != nil {
+		wsrv.err(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	if encoding != "" {
+		w.Header().Set("Vary", "Accept-Encoding")
+	}
+	if compressed {
+		w.Header().Set("Content-Encoding", encoding)
+	}
 	w.Header().Set("Content-Type", "application/x-sqlite3")
-	http.ServeFile(w, r, wsrv.storage.BackupPath())
+	serveBackup(w, r, backup, file)
+}
+
+// BackupsIndex serves the list of the backups kept according to the retention policy.
+func (wsrv *WebServer) BackupsIndex(w http.ResponseWriter, r *http.Request) {
+	backups, err := wsrv.storage.RetainedBackups()
+	if err != nil {
+		wsrv.err(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html")
+	if err := HTMLTemplates.ExecuteTemplate(w, "BackupsIndex", BackupsIndex{Backups: backups, Version: Version}); err != nil {
+		panic(err)
+	}
+}
+
+// RetainedBackup serves a backup kept according to the retention policy, whose file name is taken from the URL,
+// or its checksum in the format of sha256sum if the name ends with BackupChecksumExtension.
+func (wsrv *WebServer) RetainedBackup(w http.ResponseWriter, r *http.Request) {
+	args := ignoreTrailing(subPath("/backups/", r))
+	if len(args) == 1 && args[0] == "" {
+		http.Redirect(w, r, "/backups", http.StatusMovedPermanently)
+		return
+	} else if len(args) != 1 {
+		wsrv.errMsg(w, r, "invalid URL, use \"/backups/name\" to download the backup \"name\"", http.StatusBadRequest)
+		return
+	}
+
+	name := args[0]
+	checksumOnly := strings.HasSuffix(name, BackupChecksumExtension)
+	backup, file, err := wsrv.storage.RetainedBackup(strings.TrimSuffix(name, BackupChecksumExtension))
+	if err == ErrNoBackup {
+		wsrv.errMsg(w, r, fmt.Sprintf("Backup %q doesn't exist.", name), http.StatusNotFound)
+		return
+	} else if err != nil {
+		wsrv.err(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	if checksumOnly {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "%s  %s\n", backup.Checksum, backup.Name)
+		return
+	}
+
+	w.Header().Set("Content-Type", backup.Compression.MIMEType())
+	serveBackup(w, r, backup, file)
+}
+
+func serveBackup(w http.ResponseWriter, r *http.Request, backup BackupFile, file *os.File) {
+	w.Header().Set("ETag", strconv.Quote(backup.Checksum))
+	http.ServeContent(w, r, backup.Name, backup.Created, file)
+}
+
+// acceptsEncoding tells if the value of an Accept-Encoding header allows a content coding.
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		name := strings.TrimSpace(params[0])
+		if !strings.EqualFold(name, encoding) && name != "*" {
+			continue
+		}
+		accepted := true
+		for _, param := range params[1:] {
+			if value := strings.TrimSpace(param); strings.HasPrefix(value, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(value, "q="), 64)
+				accepted = err == nil && q > 0
+			}
+		}
+		return accepted
+	}
+	return false
 }
 
 func (wsrv *WebServer) err(w http.ResponseWriter, r *http.Request, err error, code int) {
@@ -560,6 +949,22 @@ func (wsrv *WebServer) immutableCache(handler func(http.ResponseWriter, *http.Re
 	}
 }
 
+// privileged only lets through the requests authenticated with the credentials of one of the privileged users.
+func (wsrv *WebServer) privileged(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		expected, exists := wsrv.PrivilegedUsers[user]
+		// Compare the passwords even if the user doesn't exist, so as not to tell whether it does.
+		valid := subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+		if !ok || !exists || !valid {
+			w.Header().Set("WWW-Authenticate", `Basic realm="DAB", charset="UTF-8"`)
+			wsrv.errMsg(w, r, "This page is restricted to privileged users.", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
 func (wsrv *WebServer) pagination(urlQuery url.Values) (Pagination, error) {
 	var page Pagination
 
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// Compression with zstd uses an external command, as there is no implementation in the standard library.
+const (
+	ZstdCommand   = "zstd"
+	ZstdExtension = ".zst"
+)
+
+// CheckZstd returns an error if the command zstd can't be found, so that its absence is known before it is needed.
+// The purpose describes what the command is needed for.
+func CheckZstd(purpose string) error {
+	if _, err := exec.LookPath(ZstdCommand); err != nil {
+		return fmt.Errorf("%s requires the command %s: %v", purpose, ZstdCommand, err)
+	}
+	return nil
+}
+
+// zstdCompressFile writes to dest a copy of the file at src compressed with zstd.
+func zstdCompressFile(ctx context.Context, src, dest string) error {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ZstdCommand, "--quiet", "--force", "-o", dest, src)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return zstdError(err, &stderr)
+	}
+	return nil
+}
+
+func zstdError(err error, stderr *bytes.Buffer) error {
+	return fmt.Errorf("error from %s: %v %s", ZstdCommand, err, strings.TrimSpace(stderr.String()))
+}
+
+// zstdReader decompresses data with the command zstd.
+type zstdReader struct {
+	cmd    *exec.Cmd
+	closed bool
+	err    error
+	stderr bytes.Buffer
+	stdout io.ReadCloser
+}
+
+func newZstdReader(ctx context.Context, input io.Reader) (*zstdReader, error) {
+	if err := CheckZstd("decompressing the archive"); err != nil {
+		return nil, err
+	}
+
+	// Pushshift's dumps are compressed with a window bigger than what is allowed by default.
+	cmd := exec.CommandContext(ctx, ZstdCommand, "--decompress", "--stdout", "--quiet", "--long=31")
+	zr := &zstdReader{cmd: cmd}
+	cmd.Stdin = input
+	cmd.Stderr = &zr.stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	zr.stdout = stdout
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("couldn't run the command %s to decompress the archive: %v", ZstdCommand, err)
+	}
+	return zr, nil
+}
+
+// Read implements io.Reader.
+func (zr *zstdReader) Read(p []byte) (int, error) {
+	return zr.stdout.Read(p)
+}
+
+// Close stops the command if needed, and returns its error if it failed.
+func (zr *zstdReader) Close() error {
+	if zr.closed {
+		return zr.err
+	}
+	zr.closed = true
+	zr.stdout.Close()
+	if err := zr.cmd.Wait(); err != nil {
+		zr.err = zstdError(err, &zr.stderr)
+	}
+	return zr.err
+}